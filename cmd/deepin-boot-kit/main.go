@@ -72,8 +72,9 @@ func handleAction(m *bootkit.Bootkit, c *config.BootConfig) {
 		}
 		fmt.Printf("%s", version)
 	case _ACTION_UPDATE:
-		err := m.UpdateGrub()
+		err := m.UpdateBootloader()
 		if err != nil {
+			logger.Error("failed update the boot entries:", err)
 			os.Exit(-1)
 		}
 	case _ACTION_MKGRUBCONFIG:
@@ -91,6 +91,15 @@ func (m *Manager) makeProps() map[string]map[string]*prop.Prop {
 					return nil
 				},
 			},
+			"RepoUUIDList": &prop.Prop{
+				Value:    &m.RepoUUIDList,
+				Writable: false,
+				Emit:     prop.EmitTrue,
+				Callback: func(c *prop.Change) *dbus.Error {
+					logger.Debugf("RepoUUIDList changed: %s -> %s", c.Name, c.Value)
+					return nil
+				},
+			},
 			"DefaultConfig": &prop.Prop{
 				Value:    &m.DefaultConfig,
 				Writable: false,
@@ -154,11 +163,12 @@ func (m *Manager) SetAutoQuitHandler(interval time.Duration) {
 	m.quitCheckInterval = interval
 }
 
+// canQuit reports the daemon is idle, the versions mounted are kept until the daemon is shut down
 func (m *Manager) canQuit() bool {
 	m.mu.Lock()
 	running := m.running
 	m.mu.Unlock()
-	return !running
+	return !running && len(m.upgrade.MountedVersions()) == 0
 }
 
 func (m *Manager) DelayAutoQuit() {
@@ -177,6 +187,10 @@ func (m *Manager) listenQuit() {
 			switch s {
 			case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
 				m.upgrade.SendingExitSignal(m.emitStateChanged)
+				_, err := m.upgrade.UmountVersion("", "")
+				if err != nil {
+					logger.Warning("failed umount the versions, err:", err)
+				}
 				time.Sleep(1 * time.Second)
 				os.Exit(0)
 			default:
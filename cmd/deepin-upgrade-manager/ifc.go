@@ -14,6 +14,8 @@ import (
 	"deepin-upgrade-manager/pkg/upgrader"
 	"errors"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -32,6 +34,8 @@ type Manager struct {
 	hasCall       bool
 	ActiveVersion string
 	RepoUUID      string
+	// the uuids of the partitions of all the repos, the first is RepoUUID
+	RepoUUIDList  []string
 	DefaultConfig string
 }
 
@@ -47,11 +51,16 @@ func NewManager(config *config.Config, daemon bool) (*Manager, error) {
 	if err != nil {
 		uuid = ""
 	}
+	uuidList, err := upgrade.RepoUUIDs()
+	if err != nil {
+		uuidList = []string{}
+	}
 	var m = &Manager{
 		upgrade:       upgrade,
 		ActiveVersion: config.ActiveVersion,
 		running:       false,
 		RepoUUID:      uuid,
+		RepoUUIDList:  uuidList,
 		DefaultConfig: upgrade.ReadyDataPath(),
 	}
 
@@ -120,15 +129,25 @@ func (m *Manager) CancelRollback(sender dbus.Sender) *dbus.Error {
 			m.mu.Unlock()
 			single.Remove()
 		}()
-		m.upgrade.ResetGrub()
+		m.upgrade.CancelRollback()
 	}()
 	return nil
 }
 
 func (m *Manager) Rollback(version string, sender dbus.Sender) *dbus.Error {
+	return m.RollbackPaths(version, nil, false, sender)
+}
+
+// RollbackPaths only rolls back the paths within the subscribe list, empty is the whole subscribe list
+func (m *Manager) RollbackPaths(version string, paths []string, force bool, sender dbus.Sender) *dbus.Error {
 	if !single.SetSingleInstance() {
 		return dbus.MakeFailedError(errors.New("process already exists"))
 	}
+	err := m.upgrade.SetRollbackPaths(paths, force)
+	if err != nil {
+		single.Remove()
+		return dbus.MakeFailedError(err)
+	}
 	go func() {
 		m.DelayAutoQuit()
 		m.mu.Lock()
@@ -149,6 +168,35 @@ func (m *Manager) Rollback(version string, sender dbus.Sender) *dbus.Error {
 	return nil
 }
 
+// RollForward undoes the last rollback, returns to the system committed before it
+func (m *Manager) RollForward(sender dbus.Sender) *dbus.Error {
+	if !single.SetSingleInstance() {
+		return dbus.MakeFailedError(errors.New("process already exists"))
+	}
+	if len(m.upgrade.UndoVersion()) == 0 {
+		single.Remove()
+		return dbus.MakeFailedError(errors.New("no rollback to undo"))
+	}
+	go func() {
+		m.DelayAutoQuit()
+		m.mu.Lock()
+		m.running = true
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			m.running = false
+			m.mu.Unlock()
+			single.Remove()
+		}()
+		exitCode, err := m.upgrade.RollForward(m.emitStateChanged)
+		if err != nil {
+			logger.Errorf("failed to roll forward, err: %v, exit code: %d", err, exitCode)
+			return
+		}
+	}()
+	return nil
+}
+
 func (m *Manager) Commit(subject string, sender dbus.Sender) *dbus.Error {
 	if !single.SetSingleInstance() {
 		return dbus.MakeFailedError(errors.New("process already exists"))
@@ -183,6 +231,26 @@ func (m *Manager) Commit(subject string, sender dbus.Sender) *dbus.Error {
 	return nil
 }
 
+func (m *Manager) DryRun(action, version string) (string, *dbus.Error) {
+	report, _, err := m.upgrade.DryRun(action, version)
+	if err != nil {
+		logger.Errorf("failed to dry run %s, err: %v", action, err)
+		return "", dbus.MakeFailedError(err)
+	}
+	return report.ToJson(), nil
+}
+
+// Drift returns the paths changed on the live system since the active version in json
+func (m *Manager) Drift() (string, *dbus.Error) {
+	m.DelayAutoQuit()
+	report, exitCode, err := m.upgrade.Drift()
+	if err != nil {
+		logger.Errorf("failed to report the drift, err: %v, exit code: %d", err, exitCode)
+		return "", dbus.MakeFailedError(err)
+	}
+	return report.ToJson(), nil
+}
+
 func (m *Manager) Delete(version string) *dbus.Error {
 	if !single.SetSingleInstance() {
 		return dbus.MakeFailedError(errors.New("process already exists"))
@@ -208,6 +276,100 @@ func (m *Manager) Delete(version string) *dbus.Error {
 	return nil
 }
 
+// RestoreFile restores the path of the version to the dest, returns the backup of the current dest.
+// Only root restores the files
+func (m *Manager) RestoreFile(version, path, dest string, sender dbus.Sender) (string, *dbus.Error) {
+	uid, err := getUidWithSender(m.conn, sender)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	if uid != 0 {
+		return "", dbus.MakeFailedError(errors.New("only root can restore the files"))
+	}
+	if !single.SetSingleInstance() {
+		return "", dbus.MakeFailedError(errors.New("process already exists"))
+	}
+	defer single.Remove()
+	m.DelayAutoQuit()
+	backup, exitCode, err := m.upgrade.RestoreFile(version, path, dest)
+	if err != nil {
+		logger.Errorf("failed to restore file, err: %v, exit code: %d", err, exitCode)
+		return "", dbus.MakeFailedError(err)
+	}
+	return backup, nil
+}
+
+// Mount mounts the version read-only at the target, a dir of its own if empty. Returns the target mounted.
+// Only root mounts the version out of the dir of the versions
+func (m *Manager) Mount(version, target string, sender dbus.Sender) (string, *dbus.Error) {
+	if len(target) != 0 && !strings.HasPrefix(filepath.Clean(target), upgrader.DefaultVersionMountDir+"/") {
+		uid, err := getUidWithSender(m.conn, sender)
+		if err != nil {
+			return "", dbus.MakeFailedError(err)
+		}
+		if uid != 0 {
+			return "", dbus.MakeFailedError(fmt.Errorf("only root can mount the version out of %s",
+				upgrader.DefaultVersionMountDir))
+		}
+	}
+	if !single.SetSingleInstance() {
+		return "", dbus.MakeFailedError(errors.New("process already exists"))
+	}
+	defer single.Remove()
+	m.DelayAutoQuit()
+	target, exitCode, err := m.upgrade.MountVersion(version, target)
+	if err != nil {
+		logger.Errorf("failed to mount version, err: %v, exit code: %d", err, exitCode)
+		return "", dbus.MakeFailedError(err)
+	}
+	return target, nil
+}
+
+// Umount umounts the version mounted at the target, all mounts of the version if the target is empty
+func (m *Manager) Umount(version, target string) *dbus.Error {
+	m.DelayAutoQuit()
+	exitCode, err := m.upgrade.UmountVersion(version, target)
+	if err != nil {
+		logger.Errorf("failed to umount version, err: %v, exit code: %d", err, exitCode)
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// GetCmdline returns the kernel arguments of the version in json
+func (m *Manager) GetCmdline(version string) (string, *dbus.Error) {
+	if len(version) == 0 {
+		logger.Error("must special version")
+		return "", dbus.MakeFailedError(errors.New("must special version"))
+	}
+	return m.upgrade.Cmdline(version).ToJson(), nil
+}
+
+// SetCmdline replaces the kernel arguments the rollback entries of the version add and remove.
+// Only root adds the arguments choosing what runs as init
+func (m *Manager) SetCmdline(version string, add, remove []string, sender dbus.Sender) *dbus.Error {
+	if upgrader.HasInitArgs(add) {
+		uid, err := getUidWithSender(m.conn, sender)
+		if err != nil {
+			return dbus.MakeFailedError(err)
+		}
+		if uid != 0 {
+			return dbus.MakeFailedError(errors.New("only root can set the arguments choosing what runs as init"))
+		}
+	}
+	if !single.SetSingleInstance() {
+		return dbus.MakeFailedError(errors.New("process already exists"))
+	}
+	defer single.Remove()
+	m.DelayAutoQuit()
+	exitCode, err := m.upgrade.SetCmdline(version, add, remove)
+	if err != nil {
+		logger.Errorf("failed to set the kernel arguments, err: %v, exit code: %d", err, exitCode)
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
 func (m *Manager) QuerySubject(versions []string) ([]string, *dbus.Error) {
 	var subjects []string
 
@@ -225,6 +387,19 @@ func (m *Manager) QuerySubject(versions []string) ([]string, *dbus.Error) {
 	return subjects, nil
 }
 
+func (m *Manager) QueryBootInfo(versions []string) ([]string, *dbus.Error) {
+	var infos []string
+
+	if len(versions) == 0 {
+		logger.Error("must special version")
+		return nil, dbus.MakeFailedError(errors.New("must special version"))
+	}
+	for _, v := range versions {
+		infos = append(infos, m.upgrade.BootInfo(v).ToJson())
+	}
+	return infos, nil
+}
+
 func (m *Manager) GetGrubTitle(versions string) (string, *dbus.Error) {
 	if len(versions) == 0 {
 		logger.Error("must special version")
@@ -33,21 +33,44 @@ const (
 	_ACTION_SUBJECT  = "subject"
 	_ACTION_CANCEL   = "cancel"
 	_ACTION_SET      = "setdefaultconfig"
+	_ACTION_MARKGOOD = "mark-good"
+	_ACTION_RESTORE  = "restore-file"
+	_ACTION_FORWARD  = "rollforward"
+	_ACTION_TRY      = "try"
+	_ACTION_CMDLINE  = "cmdline"
+	_ACTION_SETCMD   = "set-cmdline"
+	_ACTION_GC       = "gc"
+	_ACTION_RUN      = "run"
+	_ACTION_MOUNT    = "mount"
+	_ACTION_UMOUNT   = "umount"
+	_ACTION_STATUS   = "status"
+	_ACTION_MIGRATE  = "migrate"
 )
 
 const (
 	FAILED_PROCESS_EXISTS = -255
 	FAILED_VERSION_EXISTS = -256
+	FAILED_INVALID_PATHS  = -257
 )
 
 var (
 	_config  = flag.String("config", "/etc/deepin-upgrade-manager/config.json", "the repo config file path")
 	_data    = flag.String("data", "/etc/deepin-upgrade-manager/ready/data.yaml", "the deepin v23 commit data config file path")
-	_action  = flag.String("action", "list", "the available actions: init, commit, rollback, list, cancel, setdefaultconfig")
+	_action  = flag.String("action", "list", "the available actions: init, commit, rollback, list, cancel, setdefaultconfig, mark-good, restore-file, rollforward, try, cmdline, set-cmdline, gc, run, mount, umount, status, migrate")
 	_version = flag.String("version", "", "the version which rollback")
 	_rootDir = flag.String("root", "/", "the rootfs mount point")
 	_daemon  = flag.Bool("daemon", false, "start dbus service")
 	_subject = flag.String("subject", "", "the commit subject")
+	_dryRun  = flag.Bool("dry-run", false, "only report what commit or rollback would do, nothing is written")
+	_auto    = flag.Bool("auto", false, "the rollback is selected by grub after repeated failed boots")
+	_paths   = flag.String("paths", "", "the comma separated paths to rollback, must be within the subscribe list")
+	_force   = flag.Bool("force", false, "rollback the paths even if the system may be inconsistent")
+	_path    = flag.String("path", "", "the file or directory to restore from the version")
+	_dest    = flag.String("dest", "", "the path the file restored to, the path itself or under "+upgrader.DefaultRestoreDir+", default is the path itself")
+	_add     = flag.String("add", "", "the space separated kernel arguments the rollback entries of the version add")
+	_remove  = flag.String("remove", "", "the space separated kernel arguments the rollback entries of the version remove")
+	_target  = flag.String("target", "", "the dir the version mounted at")
+	_json    = flag.Bool("json", false, "print the status report in json")
 )
 
 func main() {
@@ -94,6 +117,22 @@ func main() {
 func handleAction(m *upgrader.Upgrader, c *config.Config) {
 	var err error
 	var exitCode int
+	if *_action == _ACTION_ROLLBACK && len(*_paths) != 0 {
+		err = m.SetRollbackPaths(strings.Split(*_paths, ","), *_force)
+		if err != nil {
+			logger.Error("invalid rollback paths:", err)
+			os.Exit(FAILED_INVALID_PATHS)
+		}
+	}
+	if *_dryRun {
+		report, exitCode, err := m.DryRun(*_action, *_version)
+		if err != nil {
+			logger.Errorf("dry run %s: %v", *_action, err)
+			os.Exit(exitCode)
+		}
+		fmt.Println(report.ToJson())
+		return
+	}
 	switch *_action {
 	case _ACTION_INIT:
 		logger.Info("start initialize a new empty repo")
@@ -124,12 +163,30 @@ func handleAction(m *upgrader.Upgrader, c *config.Config) {
 			logger.Error("process already exists")
 			os.Exit(FAILED_PROCESS_EXISTS)
 		}
+		m.SetAutoRollback(*_auto)
 		exitCode, err = m.Rollback(*_version, nil)
 		if err != nil {
 			logger.Errorf("rollback %q: %v", *_version, err)
 			os.Exit(exitCode)
 		}
 		single.Remove()
+	case _ACTION_FORWARD:
+		if !single.SetSingleInstance() {
+			logger.Error("process already exists")
+			os.Exit(FAILED_PROCESS_EXISTS)
+		}
+		exitCode, err = m.RollForward(nil)
+		if err != nil {
+			logger.Error("roll forward failed:", err)
+			os.Exit(exitCode)
+		}
+		single.Remove()
+	case _ACTION_TRY:
+		exitCode, err = m.Try(*_version)
+		if err != nil {
+			logger.Errorf("try %q: %v", *_version, err)
+			os.Exit(exitCode)
+		}
 	case _ACTION_SNAPSHOT:
 		if len(*_version) == 0 {
 			logger.Error("must special version")
@@ -183,7 +240,109 @@ func handleAction(m *upgrader.Upgrader, c *config.Config) {
 			logger.Error("process already exists")
 			os.Exit(FAILED_PROCESS_EXISTS)
 		}
-		m.ResetGrub()
+		m.CancelRollback()
+	case _ACTION_MIGRATE:
+		err = m.MigrateGrubDefault()
+		if err != nil {
+			logger.Error("failed reset the grub default of the old release:", err)
+			os.Exit(-1)
+		}
+	case _ACTION_MARKGOOD:
+		exitCode, err := m.MarkGood()
+		if err != nil {
+			logger.Error("failed mark the boot as good:", err)
+			os.Exit(exitCode)
+		}
+	case _ACTION_RESTORE:
+		if len(*_version) == 0 || len(*_path) == 0 {
+			logger.Error("must special version and path")
+			os.Exit(FAILED_INVALID_PATHS)
+		}
+		backup, exitCode, err := m.RestoreFile(*_version, *_path, *_dest)
+		if err != nil {
+			logger.Errorf("restore %q of %q: %v", *_path, *_version, err)
+			os.Exit(exitCode)
+		}
+		if len(backup) != 0 {
+			fmt.Println(backup)
+		}
+	case _ACTION_CMDLINE:
+		if len(*_version) == 0 {
+			logger.Error("must special version")
+			os.Exit(FAILED_VERSION_EXISTS)
+		}
+		fmt.Println(m.Cmdline(*_version).ToJson())
+	case _ACTION_SETCMD:
+		if !single.SetSingleInstance() {
+			logger.Error("process already exists")
+			os.Exit(FAILED_PROCESS_EXISTS)
+		}
+		exitCode, err := m.SetCmdline(*_version, strings.Fields(*_add), strings.Fields(*_remove))
+		if err != nil {
+			logger.Errorf("set the kernel arguments of %q: %v", *_version, err)
+			os.Exit(exitCode)
+		}
+		single.Remove()
+	case _ACTION_GC:
+		if !single.SetSingleInstance() {
+			logger.Error("process already exists")
+			os.Exit(FAILED_PROCESS_EXISTS)
+		}
+		removed, freed := m.GC(true, nil)
+		for _, v := range removed {
+			fmt.Println(v)
+		}
+		logger.Infof("removed %d checkouts, freed %d bytes", len(removed), freed)
+		single.Remove()
+	case _ACTION_RUN:
+		if len(*_version) == 0 || flag.NArg() == 0 {
+			logger.Error("must special version and the command after '--'")
+			os.Exit(FAILED_VERSION_EXISTS)
+		}
+		if !single.SetSingleInstance() {
+			logger.Error("process already exists")
+			os.Exit(FAILED_PROCESS_EXISTS)
+		}
+		exitCode, err := m.RunCommand(*_version, flag.Args())
+		single.Remove()
+		if err != nil {
+			logger.Errorf("run %v in %q: %v", flag.Args(), *_version, err)
+		}
+		os.Exit(exitCode)
+	case _ACTION_MOUNT:
+		if len(*_version) == 0 {
+			logger.Error("must special version")
+			os.Exit(FAILED_VERSION_EXISTS)
+		}
+		if !single.SetSingleInstance() {
+			logger.Error("process already exists")
+			os.Exit(FAILED_PROCESS_EXISTS)
+		}
+		target, exitCode, err := m.MountVersion(*_version, *_target)
+		single.Remove()
+		if err != nil {
+			logger.Errorf("mount %q: %v", *_version, err)
+			os.Exit(exitCode)
+		}
+		fmt.Println(target)
+	case _ACTION_UMOUNT:
+		exitCode, err := m.UmountVersion(*_version, *_target)
+		if err != nil {
+			logger.Errorf("umount %q: %v", *_version, err)
+			os.Exit(exitCode)
+		}
+	case _ACTION_STATUS:
+		report, exitCode, err := m.Drift()
+		if err != nil {
+			logger.Error("status:", err)
+			os.Exit(exitCode)
+		}
+		if *_json {
+			fmt.Println(report.ToJson())
+			return
+		}
+		fmt.Printf("Changes since %s:\n", report.Version)
+		fmt.Print(report.String())
 	case _ACTION_SET:
 		if !util.IsExists(*_data) {
 			logger.Error("data isn't exist")
@@ -196,6 +355,13 @@ func handleAction(m *upgrader.Upgrader, c *config.Config) {
 	}
 }
 
+func getUidWithSender(conn *dbus.Conn, sender dbus.Sender) (uint32, error) {
+	var uid uint32
+	err := conn.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixUser",
+		0, string(sender)).Store(&uid)
+	return uid, err
+}
+
 func getLocaleEnvVarsWithSender(conn *dbus.Conn, sender dbus.Sender) ([]string, error) {
 	var result []string
 	var pid uint32
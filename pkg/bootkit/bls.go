@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package bootkit
+
+import (
+	config "deepin-upgrade-manager/pkg/config/bootkit"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/langselector"
+	"deepin-upgrade-manager/pkg/module/util"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// the Boot Loader Specification Type #1 entries, relative to the esp
+	BLSEntriesDir = "/loader/entries"
+	// systemd-boot only loads from the esp, the snapshot kernels are copied here
+	BLSKernelDir = "/deepin-rollback"
+
+	blsEntrySuffix = ".conf"
+)
+
+// the kernel arguments of the current system aren't passed to the rollback
+var rollbackDropOptions = []string{"BOOT_IMAGE=", "initrd=", "back_version=", "back_scheme=",
+	"backup_uuid=", "back_auto=", "back_try="}
+
+// GenerateBLSEntries writes the entries of the versions for systemd-boot, the stale entries are removed
+func (b *Bootkit) GenerateBLSEntries() error {
+	espDir := b.conf.ESPPath()
+	entriesDir := filepath.Join(espDir, BLSEntriesDir)
+	err := os.MkdirAll(entriesDir, 0755)
+	if err != nil {
+		return err
+	}
+	options := kernelOptions()
+	keep := make(map[string]bool)
+	for _, v := range b.bootVersionList() {
+		kernel, initrd, err := copyBLSKernel(espDir, v)
+		if err != nil {
+			logger.Warningf("failed copy the kernel of %s to the esp, err: %v", v.Version, err)
+			continue
+		}
+		cmdline := versionCmdline(options, v)
+		entries := map[string]string{
+			RollbackMenuIDPrefix + v.Version: GenerateBLSEntry(rollbackTitle(v), v, kernel, initrd, cmdline),
+			SafeMenuIDPrefix + v.Version: GenerateBLSEntry(safeTitle(v), v, kernel, initrd,
+				safeModeCmdline(cmdline)),
+			TryMenuIDPrefix + v.Version: GenerateBLSEntry(tryTitle(v), v, kernel, initrd,
+				cmdline+" back_try=1"),
+		}
+		for id, content := range entries {
+			err = writeConfigFile(filepath.Join(entriesDir, id+blsEntrySuffix), content)
+			if err != nil {
+				return err
+			}
+			keep[id+blsEntrySuffix] = true
+		}
+		keep[v.Version] = true
+	}
+	cleanupBLSEntries(entriesDir, filepath.Join(espDir, BLSKernelDir), keep)
+	return nil
+}
+
+// GenerateBLSEntry returns the entry booting the version, the paths are relative to the esp
+func GenerateBLSEntry(title string, v *config.VersionConfig, kernel, initrd, options string) string {
+	args := []string{options, "back_version=" + v.Version, "back_scheme=" + v.Scheme}
+	if len(v.UUID) != 0 {
+		args = append(args, "backup_uuid="+v.UUID)
+	}
+	var lines []string
+	lines = append(lines,
+		"title "+title,
+		"version "+v.Version,
+		"linux "+kernel,
+		"initrd "+initrd,
+		"options "+strings.TrimSpace(strings.Join(args, " ")))
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// writeConfigFile replaces the file atomically, the file isn't touched if unchanged
+func writeConfigFile(filename, content string) error {
+	old, err := ioutil.ReadFile(filename)
+	if err == nil && string(old) == content {
+		return nil
+	}
+	tmpFile := filename + "-" + util.MakeRandomString(util.MinRandomLen)
+	fd, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fd.WriteString(content)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if err1 := fd.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, filename)
+}
+
+// the entries and kernels of the versions deleted or out of retention
+func cleanupBLSEntries(entriesDir, kernelDir string, keep map[string]bool) {
+	fiList, _ := ioutil.ReadDir(entriesDir)
+	for _, fi := range fiList {
+		name := fi.Name()
+		if !strings.HasSuffix(name, blsEntrySuffix) || keep[name] {
+			continue
+		}
+		if !strings.HasPrefix(name, RollbackMenuIDPrefix) && !strings.HasPrefix(name, TryMenuIDPrefix) &&
+			!strings.HasPrefix(name, SafeMenuIDPrefix) {
+			continue
+		}
+		logger.Info("remove the stale entry:", name)
+		_ = os.Remove(filepath.Join(entriesDir, name))
+	}
+	fiList, _ = ioutil.ReadDir(kernelDir)
+	for _, fi := range fiList {
+		if keep[fi.Name()] {
+			continue
+		}
+		logger.Info("remove the stale kernel dir:", fi.Name())
+		_ = os.RemoveAll(filepath.Join(kernelDir, fi.Name()))
+	}
+}
+
+// copyBLSKernel copies the kernel and initrd of the version to the esp, returns their paths in the esp
+func copyBLSKernel(espDir string, v *config.VersionConfig) (string, string, error) {
+	dir := filepath.Join(BLSKernelDir, v.Version)
+	err := os.MkdirAll(filepath.Join(espDir, dir), 0755)
+	if err != nil {
+		return "", "", err
+	}
+	var list []string
+	for _, src := range []string{v.Kernel, v.Initrd} {
+		dst := filepath.Join(dir, filepath.Base(src))
+		err = CopyFileNoAttr(bootFilePath(src), filepath.Join(espDir, dst))
+		if err != nil {
+			return "", "", err
+		}
+		list = append(list, dst)
+	}
+	return list[0], list[1], nil
+}
+
+// bootFilePath returns the path in the system, the path is relative to the separate boot partition
+func bootFilePath(path string) string {
+	if strings.HasPrefix(path, "/boot/") {
+		return path
+	}
+	return filepath.Join("/boot", path)
+}
+
+// CopyFileNoAttr copies the file to the esp, which is vfat, the ownership and the mode can't be kept.
+// The file up to date is skipped
+func CopyFileNoAttr(src, dst string) error {
+	sfi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	dfi, err := os.Stat(dst)
+	if err == nil && dfi.Size() == sfi.Size() && !dfi.ModTime().Before(sfi.ModTime()) {
+		return nil
+	}
+	fs, err := os.Open(filepath.Clean(src))
+	if err != nil {
+		return err
+	}
+	defer fs.Close()
+	tmpFile := dst + "-" + util.MakeRandomString(util.MinRandomLen)
+	fd, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fd, fs)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if err1 := fd.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, dst)
+}
+
+// kernelOptions returns the kernel arguments of the system, as kernel-install does
+func kernelOptions() string {
+	content, err := ioutil.ReadFile("/etc/kernel/cmdline")
+	if err != nil {
+		content, err = ioutil.ReadFile("/proc/cmdline")
+		if err != nil {
+			return ""
+		}
+	}
+	return dropOptions(string(content))
+}
+
+// dropOptions removes the rollback arguments from the kernel arguments
+func dropOptions(cmdline string) string {
+	var options []string
+	for _, v := range strings.Fields(cmdline) {
+		isDrop := false
+		for _, prefix := range rollbackDropOptions {
+			if strings.HasPrefix(v, prefix) {
+				isDrop = true
+				break
+			}
+		}
+		if !isDrop {
+			options = append(options, v)
+		}
+	}
+	return strings.Join(options, " ")
+}
+
+func rollbackTitle(v *config.VersionConfig) string {
+	if len(v.DisplayInfo) != 0 {
+		return v.DisplayInfo
+	}
+	return fmt.Sprintf("Rollback to %s", v.Version)
+}
+
+func tryTitle(v *config.VersionConfig) string {
+	text, _ := util.GetBootKitText(msgTryVersion, langselector.LocalLangEnv())
+	return fmt.Sprintf(text, v.Version)
+}
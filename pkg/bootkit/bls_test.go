@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package bootkit
+
+import (
+	config "deepin-upgrade-manager/pkg/config/bootkit"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestGenerateBLSEntry(t *testing.T) {
+	v := &config.VersionConfig{
+		Version: "v23.0.0.20230101",
+		Scheme:  "atomic",
+		UUID:    "5e2a7b3c-8f41-4d0a-a6c9-1b7e3d2f9a04",
+	}
+	got := GenerateBLSEntry("Rollback to v23.0.0.20230101", v, "/deepin-rollback/v23.0.0.20230101/vmlinuz-6.1.11",
+		"/deepin-rollback/v23.0.0.20230101/initrd.img-6.1.11", "root=UUID=0a6a2c1f ro quiet")
+	want := `title Rollback to v23.0.0.20230101
+version v23.0.0.20230101
+linux /deepin-rollback/v23.0.0.20230101/vmlinuz-6.1.11
+initrd /deepin-rollback/v23.0.0.20230101/initrd.img-6.1.11
+options root=UUID=0a6a2c1f ro quiet back_version=v23.0.0.20230101 back_scheme=atomic backup_uuid=5e2a7b3c-8f41-4d0a-a6c9-1b7e3d2f9a04
+`
+	if got != want {
+		t.Errorf("Except:\n%s\nbut got:\n%s", want, got)
+	}
+}
+
+func TestBLSEntryName(t *testing.T) {
+	var cases = []struct {
+		name       string
+		id         string
+		left, done int
+	}{
+		{"deepin.conf", "deepin.conf", -1, 0},
+		{"deepin+3.conf", "deepin.conf", 3, 0},
+		{"deepin+2-1.conf", "deepin.conf", 2, 1},
+		{"deepin+0-3.conf", "deepin.conf", 0, 3},
+		{"6.1.11+deb-amd64.conf", "6.1.11+deb-amd64.conf", -1, 0},
+	}
+	for _, c := range cases {
+		id, left, done := ParseBLSEntryName(c.name)
+		if id != c.id || left != c.left || done != c.done {
+			t.Errorf("%s: except %s %d %d, but got %s %d %d", c.name, c.id, c.left, c.done, id, left, done)
+		}
+		if name := BLSEntryName(id, left, done); name != c.name {
+			t.Errorf("Except %s, but got %s", c.name, name)
+		}
+	}
+}
+
+func listDir(t *testing.T, dir string) []string {
+	fiList, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	var list []string
+	for _, fi := range fiList {
+		list = append(list, fi.Name())
+	}
+	sort.Strings(list)
+	return list
+}
+
+func TestBLSCounter(t *testing.T) {
+	espDir := t.TempDir()
+	rootDir := t.TempDir()
+	entriesDir := filepath.Join(espDir, BLSEntriesDir)
+	err := os.MkdirAll(entriesDir, 0755)
+	if err == nil {
+		err = os.MkdirAll(filepath.Join(rootDir, filepath.Dir(KernelTriesFile)), 0755)
+	}
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	files := map[string]string{
+		"0a1b2c-6.1.11-amd64.conf":                     "title Deepin\nsort-key deepin\nversion 6.1.11-amd64\n",
+		"0a1b2c-6.1.32-amd64+1-2.conf":                 "title Deepin\nsort-key deepin\nversion 6.1.32-amd64\n",
+		RollbackMenuIDPrefix + "v23.0.0.20230101.conf": "title Rollback\nversion v23.0.0.20230101\noptions ro back_version=v23.0.0.20230101\n",
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(entriesDir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal("Except nil, but got error:", err)
+		}
+	}
+	if err = ArmBLSCounter(espDir, rootDir, "v23.0.0.20230301", 3); err == nil {
+		t.Error("Except error of the version without rollback entry, but got nil")
+	}
+	err = ArmBLSCounter(espDir, rootDir, "v23.0.0.20230101", 3)
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	want := []string{"0a1b2c-6.1.11-amd64+3.conf", "0a1b2c-6.1.32-amd64+1-2.conf",
+		BLSAutoRollbackID + "+1.conf", RollbackMenuIDPrefix + "v23.0.0.20230101.conf"}
+	if got := listDir(t, entriesDir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Except %v, but got %v", want, got)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(entriesDir, BLSAutoRollbackID+"+1.conf"))
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	if !strings.HasPrefix(string(content), "sort-key "+blsAutoSortKey+"\n") ||
+		!strings.Contains(string(content), "back_version=v23.0.0.20230101 back_auto=1\n") {
+		t.Errorf("Except the auto rollback entry, but got:\n%s", string(content))
+	}
+	if tries, _ := ioutil.ReadFile(filepath.Join(rootDir, KernelTriesFile)); string(tries) != "3\n" {
+		t.Errorf("Except the kernel tries 3, but got %q", string(tries))
+	}
+	if version := BLSArmedVersion(espDir); version != "v23.0.0.20230101" {
+		t.Errorf("Except v23.0.0.20230101, but got %s", version)
+	}
+
+	err = DisarmBLSCounter(espDir, rootDir)
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	want = []string{"0a1b2c-6.1.11-amd64.conf", "0a1b2c-6.1.32-amd64.conf", RollbackMenuIDPrefix + "v23.0.0.20230101.conf"}
+	if got := listDir(t, entriesDir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Except %v, but got %v", want, got)
+	}
+	if _, err = os.Stat(filepath.Join(rootDir, KernelTriesFile)); !os.IsNotExist(err) {
+		t.Error("Except the kernel tries removed, but got:", err)
+	}
+	if version := BLSArmedVersion(espDir); len(version) != 0 {
+		t.Errorf("Except not armed, but got %s", version)
+	}
+
+	// the auto rollback entry would be booted first
+	err = ioutil.WriteFile(filepath.Join(entriesDir, "legacy.conf"), []byte("title Legacy\n"), 0644)
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	if err = ArmBLSCounter(espDir, rootDir, "v23.0.0.20230101", 3); err == nil {
+		t.Error("Except error of the entry without sort-key, but got nil")
+	}
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package bootkit
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// the entry rolling back after the entries of the system are bad, the boot counting of
+	// systemd-boot sorts the bad entries to the end, the first good entry is booted
+	BLSAutoRollbackID = "deepin-auto-rollback"
+	// sorted after the sort keys of the system, the entries without sort key are sorted after it
+	blsAutoSortKey = "~deepin"
+
+	// kernel-install counts the boots of the entries added by the upgrade
+	KernelTriesFile = "/etc/kernel/tries"
+	// the kernel tries are written by the counter, removed with it
+	blsKernelTriesMark = "# kernel tries"
+
+	// the efi variables of systemd-boot
+	efiVarsDir       = "/sys/firmware/efi/efivars"
+	loaderVendorGUID = "4a67b082-0a4c-41cf-b6c7-440b29bb8c4f"
+	LoaderOneShotVar = "LoaderEntryOneShot"
+)
+
+// ParseBLSEntryName returns the id of the entry file and its boot counter, ex: 'a+2-1.conf'
+// is 'a.conf' with 2 tries left and 1 done. The tries left is -1 if the entry isn't counted
+func ParseBLSEntryName(name string) (string, int, int) {
+	base := strings.TrimSuffix(name, blsEntrySuffix)
+	index := strings.LastIndex(base, "+")
+	if index < 0 {
+		return name, -1, 0
+	}
+	counter := strings.SplitN(base[index+1:], "-", 2)
+	left, err := strconv.Atoi(counter[0])
+	if err != nil || left < 0 {
+		return name, -1, 0
+	}
+	done := 0
+	if len(counter) == 2 {
+		done, err = strconv.Atoi(counter[1])
+		if err != nil || done < 0 {
+			return name, -1, 0
+		}
+	}
+	return base[:index] + blsEntrySuffix, left, done
+}
+
+// BLSEntryName returns the file name of the entry counting the boots, the entry isn't counted if left is negative
+func BLSEntryName(id string, left, done int) string {
+	if left < 0 {
+		return id
+	}
+	base := strings.TrimSuffix(id, blsEntrySuffix) + "+" + strconv.Itoa(left)
+	if done > 0 {
+		base += "-" + strconv.Itoa(done)
+	}
+	return base + blsEntrySuffix
+}
+
+// GenerateBLSAutoEntry returns the entry rolling back automatically from the rollback entry,
+// it is sorted after the entries of the system and boots once
+func GenerateBLSAutoEntry(rollbackEntry string, isTriesMarked bool) string {
+	var lines []string
+	lines = append(lines, "sort-key "+blsAutoSortKey)
+	for _, line := range strings.Split(strings.TrimSpace(rollbackEntry), "\n") {
+		if strings.HasPrefix(line, "options ") {
+			line += " back_auto=1"
+		}
+		lines = append(lines, line)
+	}
+	if isTriesMarked {
+		lines = append(lines, blsKernelTriesMark)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// isSystemBLSEntry returns whether the entry is added by the system rather than deepin-boot-kit
+func isSystemBLSEntry(name string) bool {
+	return strings.HasSuffix(name, blsEntrySuffix) && !strings.HasPrefix(name, "deepin-")
+}
+
+// findBLSAutoEntry returns the file name of the auto rollback entry, empty if not armed
+func findBLSAutoEntry(entriesDir string) string {
+	fiList, _ := ioutil.ReadDir(entriesDir)
+	for _, fi := range fiList {
+		id, _, _ := ParseBLSEntryName(fi.Name())
+		if id == BLSAutoRollbackID+blsEntrySuffix {
+			return fi.Name()
+		}
+	}
+	return ""
+}
+
+// ArmBLSCounter counts the boots of the entries of the system, systemd-boot boots the auto rollback
+// entry of the version after the tries of all of them are used up without being disarmed
+func ArmBLSCounter(espDir, rootDir, version string, tries int) error {
+	entriesDir := filepath.Join(espDir, BLSEntriesDir)
+	rollbackEntry, err := ioutil.ReadFile(filepath.Join(entriesDir, RollbackMenuIDPrefix+version+blsEntrySuffix))
+	if err != nil {
+		return fmt.Errorf("no rollback entry of %s: %v", version, err)
+	}
+	fiList, err := ioutil.ReadDir(entriesDir)
+	if err != nil {
+		return err
+	}
+	var uncounted []string
+	for _, fi := range fiList {
+		if !isSystemBLSEntry(fi.Name()) {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(entriesDir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		// the auto rollback entry would be sorted before it
+		if !strings.Contains("\n"+string(content), "\nsort-key ") {
+			return fmt.Errorf("the entry %s has no sort-key", fi.Name())
+		}
+		if _, left, _ := ParseBLSEntryName(fi.Name()); left < 0 {
+			uncounted = append(uncounted, fi.Name())
+		}
+	}
+	isTriesMarked := false
+	if _, err = os.Stat(filepath.Join(rootDir, KernelTriesFile)); os.IsNotExist(err) {
+		err = ioutil.WriteFile(filepath.Join(rootDir, KernelTriesFile), []byte(strconv.Itoa(tries)+"\n"), 0644)
+		if err != nil {
+			return err
+		}
+		isTriesMarked = true
+	}
+	if old := findBLSAutoEntry(entriesDir); len(old) != 0 {
+		_ = os.Remove(filepath.Join(entriesDir, old))
+	}
+	err = writeConfigFile(filepath.Join(entriesDir, BLSEntryName(BLSAutoRollbackID+blsEntrySuffix, 1, 0)),
+		GenerateBLSAutoEntry(string(rollbackEntry), isTriesMarked))
+	if err != nil {
+		return err
+	}
+	for _, name := range uncounted {
+		err = os.Rename(filepath.Join(entriesDir, name), filepath.Join(entriesDir, BLSEntryName(name, tries, 0)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisarmBLSCounter removes the auto rollback entry and marks the entries of the system as good
+func DisarmBLSCounter(espDir, rootDir string) error {
+	entriesDir := filepath.Join(espDir, BLSEntriesDir)
+	autoEntry := findBLSAutoEntry(entriesDir)
+	if len(autoEntry) == 0 {
+		return nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join(entriesDir, autoEntry))
+	if err == nil && strings.Contains(string(content), blsKernelTriesMark) {
+		err = os.Remove(filepath.Join(rootDir, KernelTriesFile))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	fiList, err := ioutil.ReadDir(entriesDir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fiList {
+		if !isSystemBLSEntry(fi.Name()) {
+			continue
+		}
+		id, left, _ := ParseBLSEntryName(fi.Name())
+		if left < 0 {
+			continue
+		}
+		err = os.Rename(filepath.Join(entriesDir, fi.Name()), filepath.Join(entriesDir, id))
+		if err != nil {
+			return err
+		}
+	}
+	return os.Remove(filepath.Join(entriesDir, autoEntry))
+}
+
+// BLSArmedVersion returns the version the auto rollback entry rolls back to, empty if not armed
+func BLSArmedVersion(espDir string) string {
+	entriesDir := filepath.Join(espDir, BLSEntriesDir)
+	autoEntry := findBLSAutoEntry(entriesDir)
+	if len(autoEntry) == 0 {
+		return ""
+	}
+	content, err := ioutil.ReadFile(filepath.Join(entriesDir, autoEntry))
+	if err != nil {
+		return ""
+	}
+	for _, v := range strings.Fields(string(content)) {
+		if strings.HasPrefix(v, "back_version=") {
+			return strings.TrimPrefix(v, "back_version=")
+		}
+	}
+	return ""
+}
+
+// SetBLSOneShot boots the entry once, systemd-boot clears it before booting. Empty cancels it
+func SetBLSOneShot(id string) error {
+	out, err := exec.Command("bootctl", "set-oneshot", id).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, string(out))
+	}
+	return nil
+}
+
+// ReadLoaderVar returns the efi variable of systemd-boot, the value is a UTF-16 string
+func ReadLoaderVar(name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(efiVarsDir, name+"-"+loaderVendorGUID))
+	if err != nil {
+		return "", err
+	}
+	// the attributes of the variable come first
+	if len(data) < 4 || len(data)%2 != 0 {
+		return "", errors.New("invalid efi variable " + name)
+	}
+	var chars []uint16
+	for i := 4; i+1 < len(data); i += 2 {
+		c := uint16(data[i]) | uint16(data[i+1])<<8
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars)), nil
+}
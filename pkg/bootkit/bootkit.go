@@ -10,6 +10,7 @@ import (
 	"deepin-upgrade-manager/pkg/module/generator"
 	"deepin-upgrade-manager/pkg/module/grub"
 	"deepin-upgrade-manager/pkg/module/langselector"
+	"deepin-upgrade-manager/pkg/module/secureboot"
 	"deepin-upgrade-manager/pkg/module/util"
 	"fmt"
 	"os"
@@ -20,9 +21,18 @@ import (
 
 const (
 	ToolScriptDir = "/var/lib/deepin-boot-kit/scripts"
+
+	RollbackSubmenuID    = "deepin-rollback"
+	RollbackMenuIDPrefix = "deepin-rollback-"
+	TryMenuIDPrefix      = "deepin-try-"
+	SafeMenuIDPrefix     = "deepin-safe-"
+
+	// the time to interrupt the automatic rollback in the grub menu
+	autoRollbackTimeout = 3
 )
 
 var msgRollBack = util.Tr("System Recovery")
+var msgTryVersion = util.Tr("Try %s without rolling back")
 
 type Bootkit struct {
 	conf            *config.BootConfig
@@ -30,6 +40,8 @@ type Bootkit struct {
 	versionListInfo config.VersionListInfo
 
 	verManager generator.ListManager
+
+	grubDevices map[string]*GrubDevice
 }
 
 func NewBootkit(conf *config.BootConfig) (*Bootkit, error) {
@@ -87,6 +99,23 @@ func (b *Bootkit) UpdateGrub() error {
 	return err
 }
 
+// UpdateBootloader updates the entries of the bootloader configured
+func (b *Bootkit) UpdateBootloader() error {
+	switch b.conf.Bootloader() {
+	case config.BootloaderGrub:
+		return b.UpdateGrub()
+	case config.BootloaderSystemdBoot:
+		logger.Info("start update the systemd-boot entries")
+		b.InitVersionInfo()
+		return b.GenerateBLSEntries()
+	case config.BootloaderExtlinux:
+		logger.Info("start update the extlinux menu")
+		b.InitVersionInfo()
+		return b.GenerateExtlinuxConfig()
+	}
+	return fmt.Errorf("unknown bootloader: %s", b.conf.Bootloader())
+}
+
 func (b *Bootkit) UpdateInitramfs() error {
 	logger.Info("start update initramfs")
 	err := util.ExecCommand(" update-initramfs", []string{"-u", "-k", "all"})
@@ -101,16 +130,18 @@ func (b *Bootkit) GetScriptDirList() []string {
 	return scriptsList
 }
 
-func (b *Bootkit) GenerateGrubMenu(menu, linux, initrd, grubcmdlinelinux, grubcmdlinelinuxdefault,
+func (b *Bootkit) GenerateGrubMenu(menu, id, linux, initrd, grubcmdlinelinux, grubcmdlinelinuxdefault,
 	backversion, backscheme, backuuid string) []string {
 	var menus []string
-	arg := grubcmdlinelinux + " " + grubcmdlinelinuxdefault + " " + backversion + " " + backscheme + " " + backuuid
+	// set by the boot counter when the entry is selected automatically
+	autoRollback := "$" + grub.AutoRollbackEnv
+	arg := grubcmdlinelinux + " " + grubcmdlinelinuxdefault + " " + backversion + " " + backscheme + " " + backuuid +
+		" " + autoRollback
 	CLASS := "--class gnu-linux --class gnu --class os"
-	UUID := os.Getenv("GRUB_DEVICE_UUID")
 	submenu_indentation := os.Getenv("grub_tab")
 	menuentry_id_option := os.Getenv("menuentry_id_option")
-	menuentry := fmt.Sprintf("%smenuentry '%s' %s %s $menuentry_id_option 'gnulinux-simple-%s' {",
-		submenu_indentation, menu, CLASS, menuentry_id_option, UUID)
+	menuentry := fmt.Sprintf("%smenuentry '%s' %s %s $menuentry_id_option '%s' {",
+		submenu_indentation, menu, CLASS, menuentry_id_option, id)
 	menus = append(menus, menuentry)
 
 	quick_boot := os.Getenv("$quick_boot")
@@ -144,15 +175,16 @@ func (b *Bootkit) GenerateGrubMenu(menu, linux, initrd, grubcmdlinelinux, grubcm
 	menus = append(menus, insmod)
 	grub_platform := fmt.Sprintf("%s if [ x $grub_platform = xxen ]; then insmod xzio; insmod lzopio; fi", submenu_indentation)
 	menus = append(menus, grub_platform)
-	prepare := os.Getenv("BOOT_KIT_PREPARE")
-	menus = append(menus, prepare)
+	// the snapshot kernel may be on an encrypted or lvm device
+	menus = append(menus, b.grubPrologue(linux, submenu_indentation))
 
 	linuxMessage := ("	echo 'Loading Linux  ...'")
 	menus = append(menus, linuxMessage)
 
 	linuxDir := linux + ".efi.signed"
 	linuxRoot := os.Getenv("LINUX_ROOT_DEVICE")
-	if util.IsExists("/sys/firmware/efi") && util.IsExists(linuxDir) {
+	// the path of the kernel is relative to the separate boot partition
+	if util.IsExists("/sys/firmware/efi") && util.IsExists(bootFilePath(linuxDir)) {
 		linuxCmd := fmt.Sprintf("	linux %s root=%s ro %s", linuxDir, linuxRoot, arg)
 		menus = append(menus, linuxCmd)
 	} else {
@@ -167,15 +199,31 @@ func (b *Bootkit) GenerateGrubMenu(menu, linux, initrd, grubcmdlinelinux, grubcm
 	return menus
 }
 
-func (b *Bootkit) GenerateDefaultGrub() string {
-	var grubInfos []string
-
+// bootVersionList returns the versions with boot entries, the newest first
+func (b *Bootkit) bootVersionList() config.VersionListConf {
 	var list config.VersionListConf
 	for _, v := range b.versionListInfo {
-		list = append(list, v.VersionList...)
+		for _, info := range v.VersionList {
+			// the entry could never boot
+			if secureboot.IsFailed(info.SecureBoot) {
+				logger.Warningf("hide %s, the kernel is %s by secure boot", info.Version, info.SecureBoot)
+				continue
+			}
+			list = append(list, info)
+		}
+	}
+	list = list.Sort()
+	if len(list) > b.conf.Kit.MaxVersionRetention {
+		list = list[:b.conf.Kit.MaxVersionRetention]
 	}
-	sortList := list.Sort()
-	if len(list) == 0 {
+	return list
+}
+
+func (b *Bootkit) GenerateDefaultGrub() string {
+	var grubInfos []string
+
+	sortList := b.bootVersionList()
+	if len(sortList) == 0 {
 		return ""
 	}
 	var usersLine string
@@ -184,38 +232,65 @@ func (b *Bootkit) GenerateDefaultGrub() string {
 	if nil == err && len(encryptedUsers) != 0 {
 		usersLine = fmt.Sprintf("--users %s ", strings.Join(encryptedUsers, " "))
 	}
-	UUID := os.Getenv("GRUB_DEVICE_UUID")
 	menuentry_id_option := os.Getenv("menuentry_id_option")
 	getTextOut, _ := util.GetBootKitText(msgRollBack, langselector.LocalLangEnv())
-	submenu := fmt.Sprintf("submenu '%s' $menuentry_id_option %s 'gnulinux-advanced-%s' %s{",
-		getTextOut, menuentry_id_option, UUID, usersLine)
+	submenu := fmt.Sprintf("submenu '%s' $menuentry_id_option %s '%s' %s{",
+		getTextOut, menuentry_id_option, RollbackSubmenuID, usersLine)
 	grubInfos = append(grubInfos, submenu)
-	for index, v := range sortList {
-		if index >= b.conf.Kit.MaxVersionRetention {
-			break
-		}
-		display := v.DisplayInfo
-		if len(display) == 0 {
-			display = fmt.Sprintf("Rollback to %s", v)
-		}
-
-		menu_entry := display
-		grubCmdliuxLinux := os.Getenv("GRUB_CMDLINE_LINUX")
-		grubCmdliuxLinuxDefault := os.Getenv("GRUB_CMDLINE_LINUX_DEFAULT")
+	grubCmdline := os.Getenv("GRUB_CMDLINE_LINUX") + " " + os.Getenv("GRUB_CMDLINE_LINUX_DEFAULT")
+	for _, v := range sortList {
+		// the arguments the version boots with differ from the current system's
+		cmdline := versionCmdline(grubCmdline, v)
 		backVersion := fmt.Sprintf("back_version=%s", v.Version)
 		backScheme := fmt.Sprintf("back_scheme=%s", v.Scheme)
 		var backuuid string
 		if len(v.UUID) != 0 {
 			backuuid = fmt.Sprintf("backup_uuid=%s", v.UUID)
 		}
-		menus := b.GenerateGrubMenu(menu_entry, v.Kernel, v.Initrd, grubCmdliuxLinux,
-			grubCmdliuxLinuxDefault, backVersion, backScheme, backuuid)
+		menus := b.GenerateGrubMenu(rollbackTitle(v), RollbackMenuIDPrefix+v.Version, v.Kernel, v.Initrd, cmdline,
+			"", backVersion, backScheme, backuuid)
+		grubInfos = append(grubInfos, menus...)
+		menus = b.GenerateGrubMenu(safeTitle(v), SafeMenuIDPrefix+v.Version, v.Kernel, v.Initrd,
+			safeModeCmdline(cmdline), "", backVersion, backScheme, backuuid)
+		grubInfos = append(grubInfos, menus...)
+		// boot the version read-only without rolling back
+		menus = b.GenerateGrubMenu(tryTitle(v), TryMenuIDPrefix+v.Version, v.Kernel, v.Initrd, cmdline,
+			"back_try=1", backVersion, backScheme, backuuid)
 		grubInfos = append(grubInfos, menus...)
 	}
 	grubInfos = append(grubInfos, "}")
+	grubInfos = append(grubInfos, b.GenerateBootCounter()...)
 	return util.SliceToString(grubInfos)
 }
 
+// GenerateBootCounter counts down the boot tries saved in grubenv on every boot,
+// when no tries are left the rollback entry of the saved version becomes the default
+func (b *Bootkit) GenerateBootCounter() []string {
+	var lines []string
+	tries := "${" + grub.BootTriesEnv + "}"
+	lines = append(lines,
+		"if [ -s $prefix/grubenv ]; then",
+		fmt.Sprintf("	load_env %s %s", grub.BootTriesEnv, grub.RollbackVersionEnv),
+		"fi",
+		fmt.Sprintf("if [ -n \"%s\" -a -n \"${%s}\" ]; then", tries, grub.RollbackVersionEnv),
+		fmt.Sprintf("	if [ \"%s\" = \"0\" ]; then", tries),
+		fmt.Sprintf("		unset %s", grub.BootTriesEnv),
+		fmt.Sprintf("		set %s=\"back_auto=1\"", grub.AutoRollbackEnv),
+		fmt.Sprintf("		export %s", grub.AutoRollbackEnv),
+		fmt.Sprintf("		set default=\"%s>%s${%s}\"", RollbackSubmenuID, RollbackMenuIDPrefix, grub.RollbackVersionEnv),
+		fmt.Sprintf("		set timeout=%d", autoRollbackTimeout))
+	for i := 1; i <= grub.MaxBootTries; i++ {
+		lines = append(lines,
+			fmt.Sprintf("	elif [ \"%s\" = \"%d\" ]; then", tries, i),
+			fmt.Sprintf("		set %s=%d", grub.BootTriesEnv, i-1))
+	}
+	lines = append(lines,
+		"	fi",
+		fmt.Sprintf("	save_env %s", grub.BootTriesEnv),
+		"fi")
+	return lines
+}
+
 func (b *Bootkit) CopyToolScripts() error {
 	err := os.MkdirAll(ToolScriptDir, 0644)
 	if err != nil {
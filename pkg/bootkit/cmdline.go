@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package bootkit
+
+import (
+	config "deepin-upgrade-manager/pkg/config/bootkit"
+	"strings"
+)
+
+// the safe mode boots the version without the graphics driver
+var (
+	safeModeAdd    = []string{"nomodeset"}
+	safeModeRemove = []string{"quiet", "splash"}
+)
+
+// ApplyCmdline removes and adds the kernel arguments, an argument to remove without value
+// matches the argument whatever its value, an argument to add replaces the same key
+func ApplyCmdline(cmdline string, add, remove []string) string {
+	var args []string
+	for _, v := range strings.Fields(cmdline) {
+		if !matchArgs(v, remove, false) && !matchArgs(v, add, true) {
+			args = append(args, v)
+		}
+	}
+	args = append(args, add...)
+	return strings.Join(args, " ")
+}
+
+func matchArgs(arg string, list []string, byKey bool) bool {
+	key := strings.SplitN(arg, "=", 2)[0]
+	for _, v := range list {
+		if v == arg {
+			return true
+		}
+		if byKey && strings.Contains(v, "=") && strings.SplitN(v, "=", 2)[0] == key {
+			return true
+		}
+		if !byKey && !strings.Contains(v, "=") && v == key {
+			return true
+		}
+	}
+	return false
+}
+
+// versionCmdline returns the kernel arguments of the version based on the arguments of the current system
+func versionCmdline(cmdline string, v *config.VersionConfig) string {
+	return ApplyCmdline(cmdline, v.CmdlineAdd, v.CmdlineRemove)
+}
+
+func safeModeCmdline(cmdline string) string {
+	return ApplyCmdline(cmdline, safeModeAdd, safeModeRemove)
+}
+
+func safeTitle(v *config.VersionConfig) string {
+	return rollbackTitle(v) + " (safe mode)"
+}
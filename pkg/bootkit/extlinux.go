@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package bootkit
+
+import (
+	config "deepin-upgrade-manager/pkg/config/bootkit"
+	"deepin-upgrade-manager/pkg/module/langselector"
+	"deepin-upgrade-manager/pkg/module/util"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// the lines between the marks are owned by deepin-boot-kit, the rest of the file is kept
+	extlinuxBeginMark = "# BEGIN deepin-boot-kit"
+	extlinuxEndMark   = "# END deepin-boot-kit"
+)
+
+// the devicetree lines of the default label copied to the rollback entries
+var extlinuxDTBKeys = []string{"fdt", "fdtdir", "devicetree", "devicetreedir"}
+
+// the lines read from the default label
+var extlinuxLabelKeys = append([]string{"append", "linux", "kernel"}, extlinuxDTBKeys...)
+
+// GenerateExtlinuxConfig writes the rollback entries of the versions into extlinux.conf of U-Boot
+func (b *Bootkit) GenerateExtlinuxConfig() error {
+	content, err := ioutil.ReadFile(config.ExtlinuxConfigFile)
+	if err != nil {
+		return err
+	}
+	title, _ := util.GetBootKitText(msgRollBack, langselector.LocalLangEnv())
+	menu := GenerateExtlinuxMenu(title, b.bootVersionList(), defaultExtlinuxLabel(string(content)))
+	return writeConfigFile(config.ExtlinuxConfigFile, MergeExtlinuxConfig(string(content), menu))
+}
+
+// GenerateExtlinuxMenu returns the labels rolling back to or trying the versions, U-Boot has no
+// submenu, the labels are prefixed by the title. The lines of the default label except the kernel
+// and initrd are copied to every label
+func GenerateExtlinuxMenu(title string, list config.VersionListConf, defLabel map[string]string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	options := dropOptions(defLabel["append"])
+	defKernel := defLabel["linux"]
+	if len(defKernel) == 0 {
+		defKernel = defLabel["kernel"]
+	}
+	var lines []string
+	lines = append(lines, extlinuxBeginMark)
+	for _, v := range list {
+		cmdline := versionCmdline(options, v)
+		args := []string{"back_version=" + v.Version, "back_scheme=" + v.Scheme}
+		if len(v.UUID) != 0 {
+			args = append(args, "backup_uuid="+v.UUID)
+		}
+		backArgs := strings.Join(args, " ")
+		entries := []struct {
+			id, label, cmdline string
+		}{
+			{RollbackMenuIDPrefix + v.Version, rollbackTitle(v), cmdline + " " + backArgs},
+			{SafeMenuIDPrefix + v.Version, safeTitle(v), safeModeCmdline(cmdline) + " " + backArgs},
+			{TryMenuIDPrefix + v.Version, tryTitle(v), cmdline + " " + backArgs + " back_try=1"},
+		}
+		for _, entry := range entries {
+			lines = append(lines,
+				"",
+				"label "+entry.id,
+				fmt.Sprintf("	menu label %s: %s", title, entry.label),
+				"	linux "+v.Kernel,
+				"	initrd "+v.Initrd)
+			for _, key := range extlinuxDTBKeys {
+				if value, ok := defLabel[key]; ok {
+					lines = append(lines, fmt.Sprintf("	%s %s", key, dtbPath(value, defKernel, v.Kernel)))
+				}
+			}
+			lines = append(lines, "	append "+strings.TrimSpace(entry.cmdline))
+		}
+	}
+	lines = append(lines, extlinuxEndMark)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// MergeExtlinuxConfig replaces the block of deepin-boot-kit in the config by the menu,
+// the menu is appended if the config has no block, the block is removed if the menu is empty
+func MergeExtlinuxConfig(content, menu string) string {
+	var kept []string
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		switch strings.TrimSpace(line) {
+		case extlinuxBeginMark:
+			inBlock = true
+			continue
+		case extlinuxEndMark:
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			kept = append(kept, line)
+		}
+	}
+	result := strings.TrimRight(strings.Join(kept, "\n"), "\n") + "\n"
+	if len(menu) != 0 {
+		result += "\n" + menu
+	}
+	return result
+}
+
+// defaultExtlinuxLabel returns the lines of the default label, the first label if no default
+func defaultExtlinuxLabel(content string) map[string]string {
+	labels := make(map[string]map[string]string)
+	var order []string
+	var defName, current string
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == extlinuxBeginMark || line == extlinuxEndMark {
+			inBlock = line == extlinuxBeginMark
+			continue
+		}
+		fields := strings.Fields(line)
+		if inBlock || len(fields) < 2 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value := strings.ToLower(fields[0]), strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+		switch key {
+		case "default":
+			defName = value
+		case "label":
+			current = value
+			labels[current] = make(map[string]string)
+			order = append(order, current)
+		default:
+			if len(current) == 0 {
+				continue
+			}
+			for _, v := range extlinuxLabelKeys {
+				if key == v {
+					labels[current][key] = value
+				}
+			}
+		}
+	}
+	if label, ok := labels[defName]; ok {
+		return label
+	}
+	if len(order) != 0 {
+		return labels[order[0]]
+	}
+	return make(map[string]string)
+}
+
+// the mount point of the boot partition, the paths in extlinux.conf are relative to it if separated
+var bootMountPoint = "/boot"
+
+// dtbPath returns the devicetree path of the kernel, the devicetree of the default kernel
+// is usually installed in a directory named by its release. The devicetree extracted into
+// the boot snapshot of the version is used if exists, the one of the system may be removed
+func dtbPath(path, defKernel, kernel string) string {
+	defRelease := kernelRelease(defKernel)
+	if len(defRelease) == 0 {
+		return path
+	}
+	release := kernelRelease(kernel)
+	defDir := config.KernelDTBDirPrefix + defRelease
+	if path == defDir || strings.HasPrefix(path, defDir+"/") {
+		snapDir := filepath.Join(filepath.Dir(kernel), config.SnapshotDTBDir,
+			filepath.Base(config.KernelDTBDirPrefix+release))
+		if util.IsExists(filepath.Join(bootMountPoint, strings.TrimPrefix(snapDir, "/boot"))) {
+			return snapDir + strings.TrimPrefix(path, defDir)
+		}
+	}
+	return strings.ReplaceAll(path, defRelease, release)
+}
+
+func kernelRelease(kernel string) string {
+	name := filepath.Base(kernel)
+	for _, prefix := range []string{"vmlinuz-", "vmlinux-", "kernel-"} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package bootkit
+
+import (
+	config "deepin-upgrade-manager/pkg/config/bootkit"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var _update = flag.Bool("update", false, "update the golden files")
+
+func checkGolden(t *testing.T, golden, got string) {
+	if *_update {
+		err := ioutil.WriteFile(golden, []byte(got), 0644)
+		if err != nil {
+			t.Fatal("Except nil, but got error:", err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	if string(want) != got {
+		t.Errorf("%s mismatch, except:\n%s\nbut got:\n%s", golden, string(want), got)
+	}
+}
+
+func TestGenerateExtlinuxConfig(t *testing.T) {
+	var list = config.VersionListConf{
+		{
+			Version: "v23.0.0.20230301",
+			Kernel:  "/boot/vmlinuz-6.1.32-arm64-desktop-rolling",
+			Initrd:  "/boot/initrd.img-6.1.32-arm64-desktop-rolling",
+			Scheme:  "atomic",
+			UUID:    "5e2a7b3c-8f41-4d0a-a6c9-1b7e3d2f9a04",
+		},
+		{
+			Version:     "v23.0.0.20230101",
+			Kernel:      "/boot/vmlinuz-6.1.11-arm64-desktop-rolling",
+			Initrd:      "/boot/initrd.img-6.1.11-arm64-desktop-rolling",
+			Scheme:      "atomic",
+			DisplayInfo: "Rollback to v23.0.0.20230101 (2023/01/01)",
+			// booted before the graphics driver changed
+			CmdlineAdd:    []string{"nomodeset", "console=tty1"},
+			CmdlineRemove: []string{"splash"},
+		},
+	}
+	var cases = []struct {
+		name string
+		list config.VersionListConf
+	}{
+		{"extlinux.conf", list},
+		// the stale block is replaced
+		{"extlinux-stale.conf", list[1:]},
+	}
+	for _, c := range cases {
+		content, err := ioutil.ReadFile("testdata/extlinux/" + c.name)
+		if err != nil {
+			t.Fatal("Except nil, but got error:", err)
+		}
+		menu := GenerateExtlinuxMenu("System Recovery", c.list, defaultExtlinuxLabel(string(content)))
+		got := MergeExtlinuxConfig(string(content), menu)
+		checkGolden(t, "testdata/extlinux/"+c.name+".golden", got)
+		// generating again changes nothing
+		menu = GenerateExtlinuxMenu("System Recovery", c.list, defaultExtlinuxLabel(got))
+		if again := MergeExtlinuxConfig(got, menu); again != got {
+			t.Errorf("Except unchanged, but got:\n%s", again)
+		}
+	}
+}
+
+func TestMergeExtlinuxConfigRemove(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/extlinux/extlinux-stale.conf")
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	checkGolden(t, "testdata/extlinux/extlinux-stale.conf.removed.golden", MergeExtlinuxConfig(string(content), ""))
+}
+
+func TestDTBPath(t *testing.T) {
+	bootMountPoint = t.TempDir()
+	defer func() { bootMountPoint = "/boot" }()
+	err := os.MkdirAll(filepath.Join(bootMountPoint, "snapshot/v23.0.0.20230101/dtbs/linux-image-6.1.11-arm64"), 0755)
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	defKernel := "/boot/vmlinuz-6.1.32-arm64"
+	var cases = []struct {
+		path, kernel, want string
+	}{
+		// extracted into the boot snapshot
+		{"/usr/lib/linux-image-6.1.32-arm64/", "/boot/snapshot/v23.0.0.20230101/vmlinuz-6.1.11-arm64",
+			"/boot/snapshot/v23.0.0.20230101/dtbs/linux-image-6.1.11-arm64/"},
+		{"/usr/lib/linux-image-6.1.32-arm64/rockchip/rk3588.dtb", "/boot/snapshot/v23.0.0.20230101/vmlinuz-6.1.11-arm64",
+			"/boot/snapshot/v23.0.0.20230101/dtbs/linux-image-6.1.11-arm64/rockchip/rk3588.dtb"},
+		// the boot partition is separated
+		{"/usr/lib/linux-image-6.1.32-arm64/", "/snapshot/v23.0.0.20230101/vmlinuz-6.1.11-arm64",
+			"/snapshot/v23.0.0.20230101/dtbs/linux-image-6.1.11-arm64/"},
+		// not extracted
+		{"/usr/lib/linux-image-6.1.32-arm64/", "/boot/snapshot/v23.0.0.20230301/vmlinuz-6.1.20-arm64",
+			"/usr/lib/linux-image-6.1.20-arm64/"},
+		{"/dtb-6.1.32-arm64/", "/boot/snapshot/v23.0.0.20230101/vmlinuz-6.1.11-arm64", "/dtb-6.1.11-arm64/"},
+	}
+	for _, c := range cases {
+		if got := dtbPath(c.path, defKernel, c.kernel); got != c.want {
+			t.Errorf("Except %s, but got %s", c.want, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package bootkit
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GrubDevice is how grub accesses the device holding a path, probed as prepare_grub_to_access_device of grub-mkconfig
+type GrubDevice struct {
+	Partmaps     []string // the partition tables, e.g. gpt, msdos
+	Abstractions []string // the abstraction modules, e.g. cryptodisk luks2 lvm
+	Filesystems  []string
+	CryptoUUIDs  []string // the luks containers to unlock
+	Hint         string   // the compatibility hint, e.g. hd0,gpt2 or lvmid/...
+	FsUUID       string
+	SearchHints  string // the hints of search, e.g. --hint-efi=hd0,gpt2
+}
+
+// ProbeGrubDevice probes the device of the path by grub-probe
+func ProbeGrubDevice(path string) (*GrubDevice, error) {
+	var dev GrubDevice
+	for _, v := range []struct {
+		target string
+		list   *[]string
+	}{
+		{"partmap", &dev.Partmaps},
+		{"abstraction", &dev.Abstractions},
+		{"fs", &dev.Filesystems},
+		{"cryptodisk_uuid", &dev.CryptoUUIDs},
+	} {
+		out, err := grubProbe(v.target, path)
+		if err != nil {
+			return nil, err
+		}
+		*v.list = strings.Fields(out)
+	}
+	// grub may be unable to identify the following
+	dev.Hint, _ = grubProbe("compatibility_hint", path)
+	dev.FsUUID, _ = grubProbe("fs_uuid", path)
+	if len(dev.FsUUID) != 0 {
+		dev.SearchHints, _ = grubProbe("hints_string", path)
+	}
+	return &dev, nil
+}
+
+func grubProbe(target, path string) (string, error) {
+	out, err := exec.Command("grub-probe", "--target="+target, path).Output()
+	if err != nil {
+		return "", fmt.Errorf("grub-probe --target=%s %s: %v", target, path, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Prologue returns the commands of the menu entry making the device the root of grub, the luks
+// containers are unlocked only if cryptodisk enabled, the fs uuid isn't searched if disableUUID
+func (dev *GrubDevice) Prologue(cryptodisk, disableUUID bool) []string {
+	var lines []string
+	for _, v := range dev.Partmaps {
+		switch v {
+		case "netbsd", "openbsd":
+			lines = append(lines, "insmod part_bsd")
+		default:
+			lines = append(lines, "insmod part_"+v)
+		}
+	}
+	// the abstraction modules aren't loaded automatically
+	for _, v := range dev.Abstractions {
+		lines = append(lines, "insmod "+v)
+	}
+	for _, v := range dev.Filesystems {
+		lines = append(lines, "insmod "+v)
+	}
+	if cryptodisk {
+		for _, v := range dev.CryptoUUIDs {
+			lines = append(lines, "cryptomount -u "+v)
+		}
+	}
+	if len(dev.Hint) != 0 {
+		lines = append(lines, fmt.Sprintf("set root='%s'", dev.Hint))
+	}
+	if !disableUUID && len(dev.FsUUID) != 0 {
+		search := "search --no-floppy --fs-uuid --set=root "
+		if len(dev.SearchHints) != 0 {
+			lines = append(lines,
+				"if [ x$feature_platform_search_hint = xy ]; then",
+				"  "+search+dev.SearchHints+" "+dev.FsUUID,
+				"else",
+				"  "+search+dev.FsUUID,
+				"fi")
+		} else {
+			lines = append(lines, search+dev.FsUUID)
+		}
+	}
+	return lines
+}
+
+// grubPrologue returns the prologue accessing the device of the kernel, the device the grub
+// scripts prepared is used if failed to probe
+func (b *Bootkit) grubPrologue(kernel, indentation string) string {
+	var lines []string
+	dir := filepath.Dir(bootFilePath(kernel))
+	// the rollback and trial entries of a version share the kernel
+	dev, ok := b.grubDevices[dir]
+	if !ok {
+		var err error
+		dev, err = ProbeGrubDevice(dir)
+		if err != nil {
+			logger.Warningf("failed probe the device of %s, err: %v", kernel, err)
+			return os.Getenv("BOOT_KIT_PREPARE")
+		}
+		if b.grubDevices == nil {
+			b.grubDevices = make(map[string]*GrubDevice)
+		}
+		b.grubDevices[dir] = dev
+	}
+	for _, v := range dev.Prologue(os.Getenv("GRUB_ENABLE_CRYPTODISK") == "y",
+		os.Getenv("GRUB_DISABLE_UUID") == "true") {
+		lines = append(lines, indentation+"	"+v)
+	}
+	return strings.Join(lines, "\n")
+}
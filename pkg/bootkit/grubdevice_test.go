@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package bootkit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGrubDevicePrologue(t *testing.T) {
+	var cases = []struct {
+		layout     string
+		dev        GrubDevice
+		cryptodisk bool
+	}{
+		{
+			// /boot on the root partition
+			layout: "plain",
+			dev: GrubDevice{
+				Partmaps:    []string{"gpt"},
+				Filesystems: []string{"ext2"},
+				Hint:        "hd0,gpt3",
+				FsUUID:      "8c1a2f4e-3b7d-4e91-a2c5-6f0d9b8e7a13",
+				SearchHints: "--hint-bios=hd0,gpt3 --hint-efi=hd0,gpt3 --hint-baremetal=ahci0,gpt3",
+			},
+		},
+		{
+			layout: "separate-boot",
+			dev: GrubDevice{
+				Partmaps:    []string{"msdos"},
+				Filesystems: []string{"ext2"},
+				Hint:        "hd0,msdos1",
+				FsUUID:      "2d6f0b7a-9e4c-4a38-8d15-c7b3e1f05a62",
+				SearchHints: "--hint-bios=hd0,msdos1 --hint-efi=hd0,msdos1 --hint-baremetal=ahci0,msdos1",
+			},
+		},
+		{
+			layout: "lvm",
+			dev: GrubDevice{
+				Partmaps:     []string{"gpt"},
+				Abstractions: []string{"lvm"},
+				Filesystems:  []string{"ext2"},
+				Hint:         "lvmid/Xq3K1v-aP7e-Lm2d-Rt9s-Bw4n-Hc6j-Yz8uQf/Gh5TnE-k2Vb-Jm7p-Dq1w-Ls3x-Nr8c-Ua4fPo",
+				FsUUID:       "b47e1c09-5d2a-4f63-9e8b-0a7c3d6f2e15",
+				SearchHints:  "--hint='lvmid/Xq3K1v-aP7e-Lm2d-Rt9s-Bw4n-Hc6j-Yz8uQf/Gh5TnE-k2Vb-Jm7p-Dq1w-Ls3x-Nr8c-Ua4fPo'",
+			},
+		},
+		{
+			layout: "luks1",
+			dev: GrubDevice{
+				Partmaps:     []string{"gpt"},
+				Abstractions: []string{"cryptodisk", "luks", "gcry_rijndael", "gcry_sha256"},
+				Filesystems:  []string{"ext2"},
+				CryptoUUIDs:  []string{"5f2c8a0d7e3b4c91a6f2d8e0b3c7a5f1"},
+				Hint:         "cryptouuid/5f2c8a0d7e3b4c91a6f2d8e0b3c7a5f1",
+				FsUUID:       "e91d3a6c-0f7b-4d25-8c4e-3b9a1f6d0e72",
+				SearchHints:  "--hint='cryptouuid/5f2c8a0d7e3b4c91a6f2d8e0b3c7a5f1'",
+			},
+			cryptodisk: true,
+		},
+		{
+			layout: "luks2",
+			dev: GrubDevice{
+				Partmaps:     []string{"gpt"},
+				Abstractions: []string{"cryptodisk", "luks2", "gcry_rijndael", "gcry_sha256"},
+				Filesystems:  []string{"ext2"},
+				CryptoUUIDs:  []string{"a03e7d5c2b9f4e18b6c1d7f3e0a9b5c4"},
+				Hint:         "cryptouuid/a03e7d5c2b9f4e18b6c1d7f3e0a9b5c4",
+				FsUUID:       "0c5b9e2f-7a1d-4e63-b8f4-2d6a0c9e3f17",
+				SearchHints:  "--hint='cryptouuid/a03e7d5c2b9f4e18b6c1d7f3e0a9b5c4'",
+			},
+			cryptodisk: true,
+		},
+		{
+			// GRUB_ENABLE_CRYPTODISK isn't set, the container is never unlocked
+			layout: "luks2-nocryptodisk",
+			dev: GrubDevice{
+				Partmaps:     []string{"gpt"},
+				Abstractions: []string{"cryptodisk", "luks2", "gcry_rijndael", "gcry_sha256"},
+				Filesystems:  []string{"ext2"},
+				CryptoUUIDs:  []string{"a03e7d5c2b9f4e18b6c1d7f3e0a9b5c4"},
+				Hint:         "cryptouuid/a03e7d5c2b9f4e18b6c1d7f3e0a9b5c4",
+				FsUUID:       "0c5b9e2f-7a1d-4e63-b8f4-2d6a0c9e3f17",
+			},
+		},
+		{
+			// lvm on luks
+			layout: "luks-lvm",
+			dev: GrubDevice{
+				Partmaps:     []string{"gpt"},
+				Abstractions: []string{"cryptodisk", "luks2", "gcry_rijndael", "gcry_sha256", "lvm"},
+				Filesystems:  []string{"ext2"},
+				CryptoUUIDs:  []string{"d6b1f4a9e2c74083b5e9a1c6f0d2b7e8"},
+				Hint:         "lvmid/Pn4W7c-Qe2r-Tk8y-Ha1s-Dm5v-Xg3b-Zj6uLo/Rc9VfA-u3Mn-Ek7w-Sp2d-Gy4h-Bt1x-Wq8iKe",
+				FsUUID:       "f38a0d6b-2e9c-4b71-a5d3-9c1e7b4f0a26",
+				SearchHints:  "--hint='lvmid/Pn4W7c-Qe2r-Tk8y-Ha1s-Dm5v-Xg3b-Zj6uLo/Rc9VfA-u3Mn-Ek7w-Sp2d-Gy4h-Bt1x-Wq8iKe'",
+			},
+			cryptodisk: true,
+		},
+	}
+	for _, c := range cases {
+		got := strings.Join(c.dev.Prologue(c.cryptodisk, false), "\n") + "\n"
+		checkGolden(t, "testdata/grub/"+c.layout+".golden", got)
+	}
+}
+
+func TestGrubDevicePrologueDisableUUID(t *testing.T) {
+	dev := GrubDevice{
+		Partmaps:    []string{"gpt"},
+		Filesystems: []string{"ext2"},
+		Hint:        "hd0,gpt3",
+		FsUUID:      "8c1a2f4e-3b7d-4e91-a2c5-6f0d9b8e7a13",
+	}
+	for _, v := range dev.Prologue(false, true) {
+		if strings.HasPrefix(v, "search") {
+			t.Errorf("Except no search, but got: %s", v)
+		}
+	}
+}
+
+// stubGrubProbe puts a grub-probe answering the targets by the script on PATH
+func stubGrubProbe(t *testing.T, script string) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "grub-probe"), []byte("#!/bin/sh\n"+script), 0755)
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+":"+path)
+	t.Cleanup(func() {
+		os.Setenv("PATH", path)
+	})
+}
+
+func TestProbeGrubDevice(t *testing.T) {
+	stubGrubProbe(t, `[ "$2" = /boot/snapshot ] || exit 1
+case "$1" in
+--target=partmap) echo gpt ;;
+--target=abstraction) echo "cryptodisk luks2 lvm" ;;
+--target=fs) echo ext2 ;;
+--target=cryptodisk_uuid) echo d6b1f4a9e2c74083b5e9a1c6f0d2b7e8 ;;
+--target=compatibility_hint) echo lvmid/Pn4W7c/Rc9VfA ;;
+--target=fs_uuid) echo f38a0d6b-2e9c-4b71-a5d3-9c1e7b4f0a26 ;;
+--target=hints_string) echo "--hint='lvmid/Pn4W7c/Rc9VfA'" ;;
+*) exit 1 ;;
+esac
+`)
+	dev, err := ProbeGrubDevice("/boot/snapshot")
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	want := &GrubDevice{
+		Partmaps:     []string{"gpt"},
+		Abstractions: []string{"cryptodisk", "luks2", "lvm"},
+		Filesystems:  []string{"ext2"},
+		CryptoUUIDs:  []string{"d6b1f4a9e2c74083b5e9a1c6f0d2b7e8"},
+		Hint:         "lvmid/Pn4W7c/Rc9VfA",
+		FsUUID:       "f38a0d6b-2e9c-4b71-a5d3-9c1e7b4f0a26",
+		SearchHints:  "--hint='lvmid/Pn4W7c/Rc9VfA'",
+	}
+	if !reflect.DeepEqual(dev, want) {
+		t.Errorf("Except %+v, but got %+v", want, dev)
+	}
+}
+
+func TestProbeGrubDeviceUnidentified(t *testing.T) {
+	// grub knows the partition but not the filesystem uuid
+	stubGrubProbe(t, `case "$1" in
+--target=partmap) echo msdos ;;
+--target=fs) echo ext2 ;;
+--target=abstraction|--target=cryptodisk_uuid) ;;
+*) exit 1 ;;
+esac
+`)
+	dev, err := ProbeGrubDevice("/boot")
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	if len(dev.FsUUID) != 0 || len(dev.SearchHints) != 0 || len(dev.Hint) != 0 {
+		t.Errorf("Except no uuid and hints, but got %+v", dev)
+	}
+	if len(dev.Abstractions) != 0 || len(dev.CryptoUUIDs) != 0 {
+		t.Errorf("Except no abstraction, but got %+v", dev)
+	}
+
+	stubGrubProbe(t, "exit 1\n")
+	if _, err = ProbeGrubDevice("/boot"); err == nil {
+		t.Error("Except error of the device unknown to grub, but got nil")
+	}
+}
@@ -5,6 +5,7 @@
 package config
 
 import (
+	"deepin-upgrade-manager/pkg/module/util"
 	"encoding/json"
 	"io/ioutil"
 	"os"
@@ -16,8 +17,25 @@ type KitConfig struct {
 	Data                string `json:"data_path"`
 	Config              string `json:"config_dir"`
 	MaxVersionRetention int    `json:"max_version_retention"`
+	Bootloader          string `json:"bootloader"`
+	ESPPath             string `json:"esp_path"`
 }
 
+const (
+	BootloaderGrub        = "grub"
+	BootloaderSystemdBoot = "systemd-boot"
+	BootloaderExtlinux    = "extlinux"
+
+	// the config of U-Boot, the extlinux backend is chosen if it exists
+	ExtlinuxConfigFile = "/boot/extlinux/extlinux.conf"
+	// the devicetree dir installed with the kernel, ex: /usr/lib/linux-image-<release>,
+	// copied into the dir of SnapshotDTBDir in the boot snapshot of the version
+	KernelDTBDirPrefix = "/usr/lib/linux-image-"
+	SnapshotDTBDir     = "dtbs"
+
+	DefaultESPPath = "/boot/efi"
+)
+
 type kitConfig *KitConfig
 
 type BootConfig struct {
@@ -34,6 +52,24 @@ func (c *BootConfig) BootPrepare() error {
 	return nil
 }
 
+// Bootloader returns the bootloader the entries are generated for, detected if not configured
+func (c *BootConfig) Bootloader() string {
+	if c.Kit == nil || len(c.Kit.Bootloader) == 0 {
+		if util.IsExists(ExtlinuxConfigFile) {
+			return BootloaderExtlinux
+		}
+		return BootloaderGrub
+	}
+	return c.Kit.Bootloader
+}
+
+func (c *BootConfig) ESPPath() string {
+	if len(c.Kit.ESPPath) == 0 {
+		return DefaultESPPath
+	}
+	return c.Kit.ESPPath
+}
+
 func BootloadFile(infos *BootConfig, dirpath string) error {
 	infos.filename = dirpath
 	content, err := ioutil.ReadFile(filepath.Clean(dirpath))
@@ -20,6 +20,10 @@ type VersionConfig struct {
 	Scheme      string `json:"scheme"`
 	DisplayInfo string `json:"display"`
 	UUID        string `json:"uuid"`
+
+	CmdlineAdd    []string `json:"cmdline_add"`
+	CmdlineRemove []string `json:"cmdline_remove"`
+	SecureBoot    string   `json:"secure_boot"`
 }
 
 type VersionListConf []*VersionConfig
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package config
+
+import (
+	"deepin-upgrade-manager/pkg/module/util"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	VERSION_ATTR_PATH = "attr.json"
+)
+
+// FileAttrs is the inode flags set by chattr on the files of the version by path, the files without are omitted
+type FileAttrs map[string]int32
+
+func (c *Config) VersionAttrPath(version, rootDir string) string {
+	return filepath.Join(rootDir, c.RepoList[0].ConfigDir, version, VERSION_ATTR_PATH)
+}
+
+// LoadVersionAttrs returns the inode flags of the version, empty if never captured
+func (c *Config) LoadVersionAttrs(version, rootDir string) FileAttrs {
+	var attrs = make(FileAttrs)
+	err := loadFile(&attrs, c.VersionAttrPath(version, rootDir))
+	if err != nil || attrs == nil {
+		return make(FileAttrs)
+	}
+	return attrs
+}
+
+func (c *Config) SetVersionAttrs(version, rootDir string, attrs FileAttrs) error {
+	filename := c.VersionAttrPath(version, rootDir)
+	err := os.MkdirAll(filepath.Dir(filename), 0750)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	tmpFile := filename + "-" + util.MakeRandomString(util.MinRandomLen)
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = util.Move(filename, tmpFile, true)
+	return err
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package config
+
+import (
+	"deepin-upgrade-manager/pkg/module/util"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	VERSION_BOOT_PATH = "boot.json"
+)
+
+// BootInfo records the version booted successfully, saved next to the version data
+type BootInfo struct {
+	Good   bool    `json:"good"`
+	Time   int64   `json:"time"`
+	Kernel string  `json:"kernel"`
+	Uptime float64 `json:"uptime"`
+}
+
+func (info *BootInfo) ToJson() string {
+	b, _ := json.Marshal(info)
+	return string(b)
+}
+
+func (c *Config) VersionBootPath(version, rootDir string) string {
+	return filepath.Join(rootDir, c.RepoList[0].ConfigDir, version, VERSION_BOOT_PATH)
+}
+
+// LoadVersionBoot returns the boot info of the version, empty if never booted successfully
+func (c *Config) LoadVersionBoot(version, rootDir string) *BootInfo {
+	var info BootInfo
+	err := loadFile(&info, c.VersionBootPath(version, rootDir))
+	if err != nil {
+		return &BootInfo{}
+	}
+	return &info
+}
+
+func (c *Config) IsVersionGood(version, rootDir string) bool {
+	return c.LoadVersionBoot(version, rootDir).Good
+}
+
+func (c *Config) SetVersionBoot(version, rootDir string, info *BootInfo) error {
+	filename := c.VersionBootPath(version, rootDir)
+	err := os.MkdirAll(filepath.Dir(filename), 0750)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmpFile := filename + "-" + util.MakeRandomString(util.MinRandomLen)
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = util.Move(filename, tmpFile, true)
+	return err
+}
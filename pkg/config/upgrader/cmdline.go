@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package config
+
+import (
+	"deepin-upgrade-manager/pkg/module/util"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	VERSION_CMDLINE_PATH = "cmdline.json"
+)
+
+// CmdlineInfo is the kernel arguments the rollback entries of the version boot with
+type CmdlineInfo struct {
+	Captured []string `json:"captured"` // the arguments the system booted with when committed
+	Add      []string `json:"add,omitempty"`
+	Remove   []string `json:"remove,omitempty"`
+}
+
+func (info *CmdlineInfo) ToJson() string {
+	b, _ := json.Marshal(info)
+	return string(b)
+}
+
+func (c *Config) VersionCmdlinePath(version, rootDir string) string {
+	return filepath.Join(rootDir, c.RepoList[0].ConfigDir, version, VERSION_CMDLINE_PATH)
+}
+
+// LoadVersionCmdline returns the kernel arguments of the version, empty if never captured
+func (c *Config) LoadVersionCmdline(version, rootDir string) *CmdlineInfo {
+	var info CmdlineInfo
+	err := loadFile(&info, c.VersionCmdlinePath(version, rootDir))
+	if err != nil {
+		return &CmdlineInfo{}
+	}
+	return &info
+}
+
+func (c *Config) SetVersionCmdline(version, rootDir string, info *CmdlineInfo) error {
+	filename := c.VersionCmdlinePath(version, rootDir)
+	err := os.MkdirAll(filepath.Dir(filename), 0750)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmpFile := filename + "-" + util.MakeRandomString(util.MinRandomLen)
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = util.Move(filename, tmpFile, true)
+	return err
+}
@@ -39,6 +39,14 @@ type RepoConfig struct {
 }
 type RepoListConfig []*RepoConfig
 
+// BootCheckConfig is the automatic rollback after repeated failed boots,
+// a boot is good when the health check command succeeds, or the target is reached without the command
+type BootCheckConfig struct {
+	MaxBootTries int    `json:"max_boot_tries"`
+	GoodTarget   string `json:"good_target"`
+	HealthCheck  string `json:"health_check"`
+}
+
 type Config struct {
 	filename string
 	dataname string
@@ -54,6 +62,40 @@ type Config struct {
 
 	MaxVersionRetention int32 `json:"max_version_retention"`
 	MaxRepoRetention    int32 `json:"max_repo_retention"`
+
+	BootCheck *BootCheckConfig `json:"boot_check,omitempty"`
+
+	// the EFI System Partition is committed and rolled back with the first repo
+	IncludeESP bool `json:"include_esp"`
+
+	SnapshotCache *SnapshotCacheConfig `json:"snapshot_cache,omitempty"`
+
+	// the restored paths are compared with the version after the rollback, 'report', 'revert' or 'off'
+	VerifyRollback string `json:"verify_rollback,omitempty"`
+
+	// the local changes of /etc since the active version are merged into the /etc rolled back to
+	EtcMerge bool `json:"etc_merge,omitempty"`
+}
+
+const (
+	DefaultMaxBootTries = 3
+	DefaultGoodTarget   = "multi-user.target"
+)
+
+const (
+	VerifyRollbackReport = "report"
+	VerifyRollbackRevert = "revert"
+	VerifyRollbackOff    = "off"
+)
+
+// VerifyRollbackMode returns how the mismatches found after the rollback are handled, reported by default
+func (c *Config) VerifyRollbackMode() string {
+	switch c.VerifyRollback {
+	case VerifyRollbackRevert, VerifyRollbackOff:
+		return c.VerifyRollback
+	default:
+		return VerifyRollbackReport
+	}
 }
 
 func (c *Config) Prepare() error {
@@ -134,9 +176,12 @@ func (c *Config) Save() error {
 	return err
 }
 
+// ChangeRepoMountPoint moves the repos on the mount point of the first repo,
+// the other repos keep their partitions
 func (c *Config) ChangeRepoMountPoint(mountpoint string) {
+	primary := c.RepoList[0].RepoMountPoint
 	for _, v := range c.RepoList {
-		if v.RepoMountPoint == mountpoint {
+		if v.RepoMountPoint == mountpoint || v.RepoMountPoint != primary {
 			continue
 		}
 		if mountpoint == "/" {
@@ -161,59 +206,177 @@ func (c *Config) SetDistribution(version string) {
 	}
 }
 
+// BootCheckConfig returns the boot check config, the config files before the boot check use the default
+func (c *Config) BootCheckConfig() BootCheckConfig {
+	if c.BootCheck == nil {
+		return BootCheckConfig{
+			MaxBootTries: DefaultMaxBootTries,
+			GoodTarget:   DefaultGoodTarget,
+		}
+	}
+	return *c.BootCheck
+}
+
 func (c *Config) SetCacheDir(dir string) {
 	c.CacheDir = dir
 }
 
+// AppendCommit appends the dirs to the repos subscribing them, the others to the first repo
 func (c *Config) AppendCommit(dirs []string, isClear bool) {
+	routed := c.RepoList.route(dirs)
+	c.RepoList[0].appendCommit(routed[0], isClear)
+	for i, v := range c.RepoList[1:] {
+		v.appendCommit(routed[i+1], false)
+	}
+	c.RepoList.filterRepoDirs()
+}
+
+// AppendFilter appends the dirs to the repos subscribing them, the others to the first repo
+func (c *Config) AppendFilter(dirs []string, isClear bool) {
+	routed := c.RepoList.route(dirs)
+	c.RepoList[0].appendFilter(routed[0], isClear)
+	for i, v := range c.RepoList[1:] {
+		v.appendFilter(routed[i+1], false)
+	}
+}
+
+// isSubscribed returns whether the path is one of the dirs or under them
+func isSubscribed(path string, dirs []string) bool {
+	for _, v := range dirs {
+		if path == v || v == "/" || strings.HasPrefix(path, strings.TrimSuffix(v, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// route splits the dirs by the repos, a dir goes to the first of the other repos subscribing it,
+// the rest go to the first repo
+func (list RepoListConfig) route(dirs []string) [][]string {
+	routed := make([][]string, len(list))
+	for _, dir := range dirs {
+		index := 0
+		for i, v := range list[1:] {
+			if isSubscribed(dir, v.SubscribeList) {
+				index = i + 1
+				break
+			}
+		}
+		routed[index] = append(routed[index], dir)
+	}
+	return routed
+}
+
+func (repo *RepoConfig) appendCommit(dirs []string, isClear bool) {
 	if isClear {
-		c.RepoList[0].SubscribeList = c.RepoList[0].SubscribeList[:0]
+		repo.SubscribeList = repo.SubscribeList[:0]
 	}
 	for _, v := range dirs {
-		if len(v) == 0 || util.IsRootSame(c.RepoList[0].SubscribeList, v) {
+		if len(v) == 0 || util.IsRootSame(repo.SubscribeList, v) {
 			continue
 		} else {
-			c.RepoList[0].SubscribeList = append(c.RepoList[0].SubscribeList, v)
+			repo.SubscribeList = append(repo.SubscribeList, v)
 		}
 	}
 }
 
-func (c *Config) AppendFilter(dirs []string, isClear bool) {
+func (repo *RepoConfig) appendFilter(dirs []string, isClear bool) {
 	if isClear {
-		c.RepoList[0].FilterList = c.RepoList[0].FilterList[:0]
+		repo.FilterList = repo.FilterList[:0]
 	}
 	for _, v := range dirs {
-		if len(v) == 0 || util.IsRootSame(c.RepoList[0].FilterList, v) {
+		if len(v) == 0 || util.IsRootSame(repo.FilterList, v) {
 			continue
 		} else {
-			c.RepoList[0].FilterList = append(c.RepoList[0].FilterList, v)
+			repo.FilterList = append(repo.FilterList, v)
 		}
 	}
 }
 
-func (c *Config) LoadData(path string) {
+// loadData applies the data to the repos, the lists of the first repo are replaced by the data,
+// the dirs subscribed by the other repos are appended to them
+func (list RepoListConfig) loadData(path string) {
 	dataCf, err := LoadDataConfig(path)
 	if err != nil {
 		logger.Warning(err)
 	}
-	c.RepoList[0].DataOrigin = path
+	repo := list[0]
+	repo.DataOrigin = path
+
+	backupList := list.route(dataCf.Target.Backup_list)
+	holdList := list.route(dataCf.Target.Hold_list)
+	repo.appendCommit(backupList[0], true)
+	repo.appendFilter(holdList[0], true)
+	for i, v := range list[1:] {
+		v.appendCommit(backupList[i+1], false)
+		v.appendFilter(holdList[i+1], false)
+	}
+	repo.AfterRun = dataCf.Target.After_run
+	repo.PlymouthTheme = dataCf.Target.Plymouth_theme
+	list.filterRepoDirs()
+}
 
-	c.AppendCommit(dataCf.Target.Backup_list, true)
-	c.AppendFilter(dataCf.Target.Hold_list, true)
-	c.RepoList[0].AfterRun = dataCf.Target.After_run
-	c.RepoList[0].PlymouthTheme = dataCf.Target.Plymouth_theme
+// filterRepoDirs filters the repo dirs out of the subscribed dirs, and the dirs of the other repos
+// out of the first repo, a path is committed to one repo
+func (list RepoListConfig) filterRepoDirs() {
 	const versionManager = "/var/lib/deepin-boot-kit"
-	for _, v := range c.RepoList[0].SubscribeList {
-		if strings.HasPrefix(c.RepoList[0].RepoMountPoint, v) {
-			c.AppendFilter([]string{filepath.Dir(c.RepoList[0].Repo)}, false)
+	for i, repo := range list {
+		for _, v := range repo.SubscribeList {
+			if strings.HasPrefix(repo.RepoMountPoint, v) {
+				repo.appendFilter([]string{filepath.Dir(repo.Repo)}, false)
+			}
+			//  Special handling to prevent the version number error
+			if strings.HasPrefix(versionManager, v) {
+				repo.appendFilter([]string{versionManager}, false)
+			}
 		}
-		//  Special handling to prevent the version number error
-		if strings.HasPrefix(versionManager, v) {
-			c.AppendFilter([]string{versionManager}, false)
+		for j, other := range list {
+			if i == j {
+				continue
+			}
+			if isSubscribed(other.Repo, repo.SubscribeList) {
+				repo.appendFilter([]string{filepath.Dir(other.Repo)}, false)
+			}
+			if i != 0 {
+				continue
+			}
+			for _, v := range other.SubscribeList {
+				if isSubscribed(v, repo.SubscribeList) {
+					repo.appendFilter([]string{v}, false)
+				}
+			}
 		}
 	}
 }
 
+func (c *Config) LoadData(path string) {
+	c.RepoList.loadData(path)
+}
+
+// PreviewData returns a copy of the repo configs with the data file applied,
+// the loaded config is not changed and not saved
+func (c *Config) PreviewData(path string) RepoListConfig {
+	list := make(RepoListConfig, len(c.RepoList))
+	for i, v := range c.RepoList {
+		repo := *v
+		repo.SubscribeList = append([]string(nil), v.SubscribeList...)
+		repo.FilterList = append([]string(nil), v.FilterList...)
+		list[i] = &repo
+	}
+	if util.IsExists(path) {
+		list.loadData(path)
+	}
+	return list
+}
+
+func (c *Config) DataPath() string {
+	return c.dataname
+}
+
+func (c *Config) VersionDataPath(version, rootDir string) string {
+	return filepath.Join(rootDir, c.RepoList[0].ConfigDir, version, "data.yaml")
+}
+
 func (c *Config) LoadReadyData() {
 	if !util.IsExists(c.dataname) {
 		return
@@ -227,7 +390,7 @@ func (c *Config) LoadVersionData(version, rootDir string) error {
 	if !util.IsExists(c.dataname) || len(version) == 0 {
 		return fmt.Errorf("failed load version yaml config, path:%s, version:%s", c.dataname, version)
 	}
-	versionConfigPath := filepath.Join(rootDir, c.RepoList[0].ConfigDir, version, "data.yaml")
+	versionConfigPath := c.VersionDataPath(version, rootDir)
 	logger.Debug("load version config path: ", versionConfigPath)
 	c.LoadData(versionConfigPath)
 	c.Save()
@@ -243,6 +406,14 @@ func (c *Config) SetVersionConfig(version string) {
 	util.CopyFile(c.dataname, filepath.Join(versionConfig, "data.yaml"), false)
 }
 
+// RemoveVersionConfig removes the data dir of the version, ex: the version failed to commit
+func (c *Config) RemoveVersionConfig(version, rootDir string) error {
+	if len(version) == 0 {
+		return errors.New("empty version")
+	}
+	return os.RemoveAll(filepath.Join(rootDir, c.RepoList[0].ConfigDir, version))
+}
+
 func (c *Config) ReadyDataPath() string {
 	path := filepath.Join(c.RepoList[0].ConfigDir, DATA_YAML_PATH)
 	if util.IsExists(path) {
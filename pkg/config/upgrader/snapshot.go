@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package config
+
+import (
+	"deepin-upgrade-manager/pkg/module/util"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	SNAPSHOT_CACHE_PATH = ".snapshot-cache.json"
+
+	// MiB
+	DefaultSnapshotMaxSize      = 4096
+	DefaultSnapshotMinFreeSpace = 2048
+)
+
+// SnapshotCacheConfig is the budget of the checkouts in the snapshot dir, the least recently used
+// checkouts are removed while they are larger than MaxSize or the free space is less than MinFreeSpace, in MiB
+type SnapshotCacheConfig struct {
+	MaxSize      int64 `json:"max_size"`
+	MinFreeSpace int64 `json:"min_free_space"`
+}
+
+// SnapshotEntry is a checkout of the version in the snapshot dir
+type SnapshotEntry struct {
+	Checksum string `json:"checksum"`
+	LastUsed int64  `json:"last_used"`
+	Size     int64  `json:"size"`
+}
+
+// SnapshotCache is the checkouts in the snapshot dir of a repo by version
+type SnapshotCache map[string]*SnapshotEntry
+
+// SnapshotCacheConfig returns the snapshot cache config, the config files before the cache use the default
+func (c *Config) SnapshotCacheConfig() SnapshotCacheConfig {
+	if c.SnapshotCache == nil {
+		return SnapshotCacheConfig{
+			MaxSize:      DefaultSnapshotMaxSize,
+			MinFreeSpace: DefaultSnapshotMinFreeSpace,
+		}
+	}
+	return *c.SnapshotCache
+}
+
+func (repo *RepoConfig) SnapshotCachePath(rootDir string) string {
+	return filepath.Join(rootDir, repo.SnapshotDir, SNAPSHOT_CACHE_PATH)
+}
+
+// LoadSnapshotCache returns the checkouts of the repo, empty if never recorded
+func (repo *RepoConfig) LoadSnapshotCache(rootDir string) SnapshotCache {
+	var cache = make(SnapshotCache)
+	err := loadFile(&cache, repo.SnapshotCachePath(rootDir))
+	if err != nil || cache == nil {
+		return make(SnapshotCache)
+	}
+	return cache
+}
+
+func (repo *RepoConfig) SetSnapshotCache(rootDir string, cache SnapshotCache) error {
+	filename := repo.SnapshotCachePath(rootDir)
+	err := os.MkdirAll(filepath.Dir(filename), 0750)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	tmpFile := filename + "-" + util.MakeRandomString(util.MinRandomLen)
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = util.Move(filename, tmpFile, true)
+	return err
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package config
+
+import (
+	"deepin-upgrade-manager/pkg/module/util"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	VERSION_UNDO_PATH = "undo.json"
+)
+
+// UndoInfo tags the version committed before a rollback, rolling forward returns to it
+type UndoInfo struct {
+	ReplacedBy string   `json:"replaced_by"`
+	Time       int64    `json:"time"`
+	Paths      []string `json:"paths,omitempty"`
+}
+
+func (c *Config) VersionUndoPath(version, rootDir string) string {
+	return filepath.Join(rootDir, c.RepoList[0].ConfigDir, version, VERSION_UNDO_PATH)
+}
+
+// LoadVersionUndo returns the undo info of the version, nil if it isn't committed before a rollback
+func (c *Config) LoadVersionUndo(version, rootDir string) *UndoInfo {
+	var info UndoInfo
+	err := loadFile(&info, c.VersionUndoPath(version, rootDir))
+	if err != nil || len(info.ReplacedBy) == 0 {
+		return nil
+	}
+	return &info
+}
+
+func (c *Config) SetVersionUndo(version, rootDir string, info *UndoInfo) error {
+	filename := c.VersionUndoPath(version, rootDir)
+	err := os.MkdirAll(filepath.Dir(filename), 0750)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmpFile := filename + "-" + util.MakeRandomString(util.MinRandomLen)
+	err = ioutil.WriteFile(tmpFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = util.Move(filename, tmpFile, true)
+	return err
+}
@@ -5,6 +5,7 @@
 package bootkitinfo
 
 import (
+	config "deepin-upgrade-manager/pkg/config/bootkit"
 	"deepin-upgrade-manager/pkg/module/dirinfo"
 	"deepin-upgrade-manager/pkg/module/generator"
 	"deepin-upgrade-manager/pkg/module/repo/branch"
@@ -24,6 +25,10 @@ type BootInfo struct {
 	Scheme      string `json:"scheme"`
 	DisplayInfo string `json:"display"`
 	UUID        string `json:"uuid"`
+
+	CmdlineAdd    []string `json:"cmdline_add,omitempty"`
+	CmdlineRemove []string `json:"cmdline_remove,omitempty"`
+	SecureBoot    string   `json:"secure_boot,omitempty"` // the validation status of the kernel, empty if not enforced
 }
 
 type BootInfos []*BootInfo
@@ -36,6 +41,8 @@ const (
 	BOOT_SNAPSHOT_DIR = "/boot/snapshot"
 	SCHEME            = "atomic"
 	DEEPIN_BOOT_KIT   = "/usr/sbin/deepin-boot-kit"
+
+	DEEPIN_BOOT_KIT_CONFIG = "/usr/share/deepin-boot-kit/config.json"
 )
 
 func (infolist BootInfoList) ToJson() string {
@@ -74,6 +81,24 @@ func Update() error {
 	return nil
 }
 
+// Bootloader returns the bootloader deepin-boot-kit generates the entries for
+func Bootloader() string {
+	conf, err := config.BootLoadConfig(DEEPIN_BOOT_KIT_CONFIG)
+	if err != nil {
+		return config.BootloaderGrub
+	}
+	return conf.Bootloader()
+}
+
+// ESPPath returns the mount point of the esp the entries of systemd-boot are written to
+func ESPPath() string {
+	conf, err := config.BootLoadConfig(DEEPIN_BOOT_KIT_CONFIG)
+	if err != nil || conf.Kit == nil {
+		return config.DefaultESPPath
+	}
+	return conf.ESPPath()
+}
+
 func (infolist *BootInfoList) SetVersionName(version, display string) {
 	for _, v := range infolist.VersionList {
 		if v.Version == version {
@@ -82,6 +107,16 @@ func (infolist *BootInfoList) SetVersionName(version, display string) {
 	}
 }
 
+// SetVersionCmdline sets the kernel arguments the entries of the version add and remove
+func (infolist *BootInfoList) SetVersionCmdline(version string, add, remove []string) {
+	for _, v := range infolist.VersionList {
+		if v.Version == version {
+			v.CmdlineAdd = add
+			v.CmdlineRemove = remove
+		}
+	}
+}
+
 func (infolist *BootInfoList) VmlinuxName(vmlinuxs []string) string {
 	var vmlinux, maxVersion string
 	for _, v := range vmlinuxs {
@@ -134,6 +169,10 @@ func Load(versionList []string, RepoUUID string) BootInfoList {
 			if fi.IsDir() {
 				continue
 			}
+			// the signed kernel is chosen by the bootloader
+			if strings.HasSuffix(fi.Name(), ".efi.signed") {
+				continue
+			}
 			if strings.HasPrefix(fi.Name(), "vmlinuz-") ||
 				strings.HasPrefix(fi.Name(), "kernel-") ||
 				strings.HasPrefix(fi.Name(), "vmlinux-") {
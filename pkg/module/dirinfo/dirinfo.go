@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 type Node struct {
@@ -58,6 +59,37 @@ func GetDirSize(path string) int64 {
 	return size
 }
 
+// GetDirOwnSize returns the disk usage of the dir freed by removing it, the files also linked
+// outside the dir, ex: the objects of the repo hardlinked by the checkout, are not counted
+func GetDirOwnSize(path string) int64 {
+	type inode struct {
+		dev, ino uint64
+	}
+	links := make(map[inode]uint64)
+	var size int64
+	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		if info.IsDir() || st.Nlink <= 1 {
+			size += st.Blocks * 512
+			return nil
+		}
+		key := inode{dev: uint64(st.Dev), ino: st.Ino}
+		links[key]++
+		// all the links are in the dir
+		if links[key] == uint64(st.Nlink) {
+			size += st.Blocks * 512
+		}
+		return nil
+	})
+	return size
+}
+
 func GetPartitionFreeSize(dirPath string) (uint64, error) {
 	if !util.IsExists(dirPath) {
 		return 0, errors.New("dir isn't exist")
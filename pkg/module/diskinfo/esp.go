@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package diskinfo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// the gpt partition type of the EFI System Partition
+	ESPPartitionType = "c12a7328-f81f-11d2-ba4b-00a0c93ec93b"
+	// the mbr partition type of the EFI System Partition
+	ESPMBRPartitionType = "0xef"
+
+	udevDataDir = "/run/udev/data"
+)
+
+// ESPMountPoints are where the EFI System Partition is usually mounted
+var ESPMountPoints = []string{"/boot/efi", "/efi", "/boot"}
+
+// IsESPPartition returns whether the partition is an EFI System Partition by the partition
+// type udev recorded, known is false if udev has no record of the partition
+func IsESPPartition(partition string) (isESP bool, known bool) {
+	var st syscall.Stat_t
+	err := syscall.Stat(partition, &st)
+	if err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return false, false
+	}
+	dev := uint64(st.Rdev)
+	major := (dev>>8)&0xfff | (dev>>32)&^uint64(0xfff)
+	minor := dev&0xff | (dev>>12)&^uint64(0xff)
+	filename := filepath.Join(udevDataDir, fmt.Sprintf("b%d:%d", major, minor))
+	fr, err := os.Open(filepath.Clean(filename))
+	if err != nil {
+		return false, false
+	}
+	defer fr.Close()
+	scanner := bufio.NewScanner(fr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "E:ID_PART_ENTRY_TYPE=") {
+			continue
+		}
+		ty := strings.ToLower(strings.TrimPrefix(line, "E:ID_PART_ENTRY_TYPE="))
+		return ty == ESPPartitionType || ty == ESPMBRPartitionType, true
+	}
+	return false, false
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+// '/var/lib/dpkg/info/*.md5sums' parser
+package md5sums
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	InfoDir = "/var/lib/dpkg/info"
+
+	_MD5SUMS_SUFFIX = ".md5sums"
+)
+
+// FileSum is the md5 of the file installed by the package, conffiles are not listed
+type FileSum struct {
+	Package string
+	MD5     string
+}
+
+// FileSumMap is the file sums by absolute path
+type FileSumMap map[string]*FileSum
+
+// Load parses the md5sums of all packages in the info dir
+func Load(infoDir string) (FileSumMap, error) {
+	files, err := filepath.Glob(filepath.Join(infoDir, "*"+_MD5SUMS_SUFFIX))
+	if err != nil {
+		return nil, err
+	}
+	sums := make(FileSumMap)
+	for _, filename := range files {
+		// ex: 'libc6:amd64.md5sums'
+		pkg := strings.TrimSuffix(filepath.Base(filename), _MD5SUMS_SUFFIX)
+		if idx := strings.Index(pkg, ":"); idx != -1 {
+			pkg = pkg[:idx]
+		}
+		err = sums.loadFile(filename, pkg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+// ex: 'd41d8cd98f00b204e9800998ecf8427e  usr/share/doc/acl/copyright'
+func (sums FileSumMap) loadFile(filename, pkg string) error {
+	fr, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+	scanner := bufio.NewScanner(fr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.Index(line, " ")
+		if idx != 32 {
+			continue
+		}
+		path := strings.TrimLeft(line[idx:], " ")
+		if len(path) == 0 {
+			continue
+		}
+		sums[filepath.Join("/", path)] = &FileSum{Package: pkg, MD5: line[:idx]}
+	}
+	return scanner.Err()
+}
+
+// Match returns the sum of the file, the merged '/usr' dirs are looked up by both paths
+func (sums FileSumMap) Match(path string) *FileSum {
+	if sum, ok := sums[path]; ok {
+		return sum
+	}
+	if strings.HasPrefix(path, "/usr/") {
+		return sums[strings.TrimPrefix(path, "/usr")]
+	}
+	return sums[filepath.Join("/usr", path)]
+}
+
+// FileMD5 returns the md5 of the file content in hex
+func FileMD5(filename string) (string, error) {
+	fr, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fr.Close()
+	h := md5.New()
+	_, err = io.Copy(h, fr)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package md5sums
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	sums, err := Load("testdata/info")
+	if err != nil {
+		t.Error("Except nil, but got error:", err)
+		return
+	}
+	if len(sums) != 3 {
+		t.Errorf("Except 3 files, but got %d", len(sums))
+		return
+	}
+	var list = []struct {
+		path string
+		pkg  string
+		md5  string
+	}{
+		{"/usr/bin/hello", "hello", "b1946ac92492d2347c6235b4d2611184"},
+		{"/usr/share/doc/hello/copy right", "hello", "d41d8cd98f00b204e9800998ecf8427e"},
+		{"/usr/bin/true", "coreutils", "6f5902ac237024bdd0c176cb93063dc4"},
+		{"/bin/hello", "hello", "b1946ac92492d2347c6235b4d2611184"},
+	}
+	for _, v := range list {
+		sum := sums.Match(v.path)
+		if sum == nil {
+			t.Errorf("Except %s matched, but got nil", v.path)
+			continue
+		}
+		if sum.Package != v.pkg || sum.MD5 != v.md5 {
+			t.Errorf("Except %s %s, but got %s %s", v.pkg, v.md5, sum.Package, sum.MD5)
+		}
+	}
+	if sums.Match("/etc/hello") != nil {
+		t.Error("Except /etc/hello not matched")
+	}
+}
+
+func TestFileMD5(t *testing.T) {
+	sum, err := FileMD5("testdata/hello")
+	if err != nil {
+		t.Error("Except nil, but got error:", err)
+		return
+	}
+	if sum != "b1946ac92492d2347c6235b4d2611184" {
+		t.Errorf("Except b1946ac92492d2347c6235b4d2611184, but got %s", sum)
+	}
+}
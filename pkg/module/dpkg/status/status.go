@@ -46,7 +46,11 @@ const (
 )
 
 const (
+	StatusFile = "/var/lib/dpkg/status"
+
 	_STATUS_DELIM = ": "
+
+	_KEY_CONFFILES = "Conffiles:"
 )
 
 func DiffStatusFile(origFile, newFile string) (PackageStatusList, error) {
@@ -217,6 +221,30 @@ func (info *PackageStatus) Bytes() []byte {
 	return []byte(strings.Join(lines, "\n"))
 }
 
+// Conffiles returns the conffiles of the package, the obsolete ones included
+// ex: ' /etc/default/acpid 5b934527919a9bba89c7978d15e918b3'
+func (info *PackageStatus) Conffiles() []string {
+	var list []string
+	inConffiles := false
+	for _, line := range info.Contents {
+		if line == _KEY_CONFFILES {
+			inConffiles = true
+			continue
+		}
+		if !inConffiles {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			break
+		}
+		items := strings.Fields(line)
+		if len(items) != 0 {
+			list = append(list, items[0])
+		}
+	}
+	return list
+}
+
 func newPackageStatus(line string, scanner *bufio.Scanner) *PackageStatus {
 	var info PackageStatus
 
@@ -78,6 +78,24 @@ func TestGetPackageStatus(t *testing.T) {
 	}
 }
 
+func TestPackageConffiles(t *testing.T) {
+	var origList = []string{"/etc/default/acpid", "/etc/init.d/acpid"}
+	list := _acpidInfo.Conffiles()
+	if len(list) != len(origList) {
+		t.Errorf("Except %v, but got %v", origList, list)
+		return
+	}
+	for i := 0; i < len(origList); i++ {
+		if origList[i] != list[i] {
+			t.Errorf("Except %s, but got %s", origList[i], list[i])
+		}
+	}
+	info := PackageStatus{Package: "test"}
+	if len(info.Conffiles()) != 0 {
+		t.Errorf("Except no conffiles, but got %v", info.Conffiles())
+	}
+}
+
 func TestPackageStatusSave(t *testing.T) {
 	data := `Package: acpid
 Status: install ok installed
@@ -6,11 +6,13 @@ package fstabinfo
 
 import (
 	"bufio"
+	"bytes"
 	"deepin-upgrade-manager/pkg/logger"
 	"deepin-upgrade-manager/pkg/module/dirinfo"
 	"deepin-upgrade-manager/pkg/module/diskinfo"
 	"deepin-upgrade-manager/pkg/module/util"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -56,6 +58,26 @@ func (fs FsInfoList) MatchDestPoint(point string) FsInfo {
 	return info
 }
 
+// ESP returns the EFI System Partition in fstab, nil if there is none
+func (fs FsInfoList) ESP() *FsInfo {
+	var unknown *FsInfo
+	for _, dir := range diskinfo.ESPMountPoints {
+		for _, v := range fs {
+			if v.DestPoint != dir || v.FSType != "vfat" || v.Bind || v.Remote {
+				continue
+			}
+			isESP, known := diskinfo.IsESPPartition(v.SrcPoint)
+			if isESP {
+				return v
+			}
+			if !known && unknown == nil {
+				unknown = v
+			}
+		}
+	}
+	return unknown
+}
+
 func (fs FsInfoList) IsInFstabPoint(rootdir, point string) bool {
 	for _, v := range fs {
 		src := util.TrimRootdir(rootdir, v.SrcPoint)
@@ -120,12 +142,21 @@ func Load(filename, rootDir string) (FsInfoList, error) {
 			logger.Warningf("error closing file: %v", err)
 		}
 	}()
+	return parse(fr, rootDir)
+}
+
+// Parse the fstab content, ex: the fstab read from a repo version
+func Parse(content []byte, rootDir string) (FsInfoList, error) {
+	return parse(bytes.NewReader(content), rootDir)
+}
+
+func parse(r io.Reader, rootDir string) (FsInfoList, error) {
 	var infos FsInfoList
 	dsInfos, err := diskinfo.Load("/dev/disk")
 	if err != nil {
 		return infos, err
 	}
-	scanner := bufio.NewScanner(fr)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
 		line = strings.TrimSpace(line)
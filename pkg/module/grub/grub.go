@@ -98,7 +98,7 @@ func (m *GrubManager) Join() error {
 	}(ch)
 	canExit, err := m.IsUpdating()
 	if err != nil {
-		logger.Warning("failed get properties, %v", err)
+		logger.Warningf("failed get properties, %v", err)
 	}
 	if canExit && nil == err {
 		ticker := time.NewTicker(3 * time.Minute)
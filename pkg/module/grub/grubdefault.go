@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package grub
+
+import (
+	"deepin-upgrade-manager/pkg/module/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	GrubParamsFile = "/etc/default/grub"
+
+	GrubDefaultParam = "GRUB_DEFAULT"
+	GrubTimeoutParam = "GRUB_TIMEOUT"
+)
+
+// ReadGrubParam returns the unquoted value of the key in /etc/default/grub, the last one wins as in shell
+func ReadGrubParam(rootDir, key string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(rootDir, GrubParamsFile))
+	if err != nil {
+		return "", err
+	}
+	var value string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, key+"=") {
+			value = strings.Trim(strings.TrimPrefix(line, key+"="), `"'`)
+		}
+	}
+	return value, nil
+}
+
+// SetGrubParams replaces the values of the keys in /etc/default/grub in place, the comments and the
+// other lines are kept. The keys absent are appended
+func SetGrubParams(rootDir string, params map[string]string) error {
+	path := filepath.Join(rootDir, GrubParamsFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	written := make(map[string]bool)
+	for i, line := range lines {
+		for key, value := range params {
+			if strings.HasPrefix(strings.TrimSpace(line), key+"=") {
+				lines[i] = key + "=" + quoteGrubParam(value)
+				written[key] = true
+			}
+		}
+	}
+	for key, value := range params {
+		if !written[key] {
+			lines = append(lines, key+"="+quoteGrubParam(value))
+		}
+	}
+	tmpFile := path + "-" + util.MakeRandomString(util.MinRandomLen)
+	err = ioutil.WriteFile(tmpFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, path)
+}
+
+func quoteGrubParam(value string) string {
+	if strings.ContainsAny(value, " \t>'\"$") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package grub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetGrubParams(t *testing.T) {
+	rootDir := t.TempDir()
+	paramsFile := filepath.Join(rootDir, GrubParamsFile)
+	os.MkdirAll(filepath.Dir(paramsFile), 0755)
+	origin := `# If you change this file, run 'update-grub' afterwards
+GRUB_DEFAULT="System Recovery>Deepin 23 (2023/01/01 10:00:00)"
+GRUB_TIMEOUT=0
+GRUB_CMDLINE_LINUX_DEFAULT="splash quiet"
+`
+	err := ioutil.WriteFile(paramsFile, []byte(origin), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, err := ReadGrubParam(rootDir, GrubDefaultParam)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def != "System Recovery>Deepin 23 (2023/01/01 10:00:00)" {
+		t.Errorf("failed read GRUB_DEFAULT, got %q", def)
+	}
+
+	err = SetGrubParams(rootDir, map[string]string{GrubDefaultParam: "0", GrubTimeoutParam: "5", "GRUB_DISABLE_OS_PROBER": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(paramsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# If you change this file, run 'update-grub' afterwards
+GRUB_DEFAULT=0
+GRUB_TIMEOUT=5
+GRUB_CMDLINE_LINUX_DEFAULT="splash quiet"
+GRUB_DISABLE_OS_PROBER=true
+`
+	if string(data) != want {
+		t.Errorf("failed set the params, got:\n%s", string(data))
+	}
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package grub
+
+import (
+	"bytes"
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	GrubEnvFile = "/boot/grub/grubenv"
+
+	// the entry booted once, cleared by grub after booting it
+	NextEntryEnv = "next_entry"
+
+	grubEnvHeader = "# GRUB Environment Block\n"
+	grubEnvSize   = 1024
+)
+
+// the boot counter, armed after a commit and cleared by 'mark-good',
+// grub selects the rollback entry of the version once no tries are left
+const (
+	BootTriesEnv       = "deepin_boot_tries"
+	RollbackVersionEnv = "deepin_rollback_version"
+	AutoRollbackEnv    = "deepin_auto_rollback"
+
+	// grub script can't do arithmetic, the counter is unrolled up to this value
+	MaxBootTries = 9
+)
+
+// GrubEnv is the environment block loaded by grub 'load_env' and written by 'save_env'
+type GrubEnv struct {
+	content map[string]string
+	path    string
+	locker  sync.RWMutex
+}
+
+func LoadGrubEnv(rootDir string) (*GrubEnv, error) {
+	env := GrubEnv{
+		content: make(map[string]string),
+		path:    filepath.Join(rootDir, GrubEnvFile),
+	}
+	data, err := ioutil.ReadFile(filepath.Clean(env.path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &env, nil
+		}
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, []byte(grubEnvHeader)) {
+		return nil, errors.New("invalid grub environment block")
+	}
+	for _, line := range strings.Split(string(data[len(grubEnvHeader):]), "\n") {
+		if len(line) == 0 || line[0] == '#' || !strings.Contains(line, "=") {
+			continue
+		}
+		list := strings.SplitN(line, "=", 2)
+		env.content[list[0]] = unescapeEnvValue(list[1])
+	}
+	return &env, nil
+}
+
+func (env *GrubEnv) Get(key string) string {
+	env.locker.RLock()
+	defer env.locker.RUnlock()
+	return env.content[key]
+}
+
+func (env *GrubEnv) Set(key, value string) {
+	env.locker.Lock()
+	env.content[key] = value
+	env.locker.Unlock()
+}
+
+func (env *GrubEnv) Unset(keys ...string) {
+	env.locker.Lock()
+	for _, key := range keys {
+		delete(env.content, key)
+	}
+	env.locker.Unlock()
+}
+
+func (env *GrubEnv) Save() error {
+	env.locker.RLock()
+	defer env.locker.RUnlock()
+
+	keys := make(sort.StringSlice, 0, len(env.content))
+	for k := range env.content {
+		keys = append(keys, k)
+	}
+	keys.Sort()
+
+	var buf bytes.Buffer
+	buf.WriteString(grubEnvHeader)
+	for _, k := range keys {
+		buf.WriteString(k + "=" + escapeEnvValue(env.content[k]) + "\n")
+	}
+	// grub requires the block to keep the fixed size, fill with '#'
+	if buf.Len() > grubEnvSize {
+		return errors.New("grub environment block overflow")
+	}
+	buf.Write(bytes.Repeat([]byte("#"), grubEnvSize-buf.Len()))
+
+	err := os.MkdirAll(filepath.Dir(env.path), 0755)
+	if err != nil {
+		return err
+	}
+	tmpFile := env.path + "-" + util.MakeRandomString(util.MinRandomLen)
+	err = ioutil.WriteFile(tmpFile, buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	_, err = util.Move(env.path, tmpFile, true)
+	return err
+}
+
+func escapeEnvValue(value string) string {
+	return strings.ReplaceAll(value, "\\", "\\\\")
+}
+
+func unescapeEnvValue(value string) string {
+	return strings.ReplaceAll(value, "\\\\", "\\")
+}
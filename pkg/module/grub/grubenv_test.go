@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package grub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGrubEnv(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "grubenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	envFile := filepath.Join(rootDir, GrubEnvFile)
+	os.MkdirAll(filepath.Dir(envFile), 0755)
+	origin := grubEnvHeader + "saved_entry=gnulinux-simple\n"
+	origin += strings.Repeat("#", grubEnvSize-len(origin))
+	err = ioutil.WriteFile(envFile, []byte(origin), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := LoadGrubEnv(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Get("saved_entry") != "gnulinux-simple" {
+		t.Errorf("failed load saved_entry, got %q", env.Get("saved_entry"))
+	}
+	env.Set(BootTriesEnv, "3")
+	env.Set(RollbackVersionEnv, "v23.0.0.20220218")
+	err = env.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) != grubEnvSize {
+		t.Errorf("the environment block size is %d, want %d", len(content), grubEnvSize)
+	}
+	env, err = LoadGrubEnv(rootDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Get(BootTriesEnv) != "3" || env.Get(RollbackVersionEnv) != "v23.0.0.20220218" ||
+		env.Get("saved_entry") != "gnulinux-simple" {
+		t.Errorf("failed reload the environment block: %q", string(content))
+	}
+
+	env.Unset(BootTriesEnv, RollbackVersionEnv)
+	err = env.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, _ = LoadGrubEnv(rootDir)
+	if len(env.Get(BootTriesEnv)) != 0 || env.Get("saved_entry") != "gnulinux-simple" {
+		t.Error("failed unset the boot counter")
+	}
+}
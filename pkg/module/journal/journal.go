@@ -0,0 +1,220 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package journal
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/util"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Step string
+
+const (
+	StepPrepare    Step = "prepare"
+	StepFilterCopy Step = "filter-copy"
+	StepSwap       Step = "swap"
+	StepCleanup    Step = "cleanup"
+
+	// the dir is replaced by moving its entries out, then the prepared entries in
+	StepMoveOut Step = "move-out"
+	StepMoveIn  Step = "move-in"
+)
+
+// the steps after which the system is partially replaced
+var swapSteps = []Step{StepSwap, StepMoveOut, StepMoveIn, StepCleanup}
+
+// Record is a step on the dir, written before the step is started and again when it's done
+type Record struct {
+	Dir  string        `json:"dir"`
+	Step Step          `json:"step"`
+	Done bool          `json:"done"`
+	Swap *util.DirSwap `json:"swap,omitempty"`
+}
+
+// Journal is the write-ahead journal of the rollback, every record is fsynced before the step
+type Journal struct {
+	Version string    `json:"version"`
+	Records []*Record `json:"records"`
+
+	filename string
+}
+
+// Load returns the journal of the version, the records of another version are discarded
+func Load(filename, version string) *Journal {
+	var j Journal
+	content, err := ioutil.ReadFile(filepath.Clean(filename))
+	if err == nil {
+		err = json.Unmarshal(content, &j)
+		if err != nil {
+			logger.Warning("failed load the rollback journal, err:", err)
+		}
+	}
+	if j.Version != version {
+		j = Journal{Version: version}
+	}
+	j.filename = filename
+	return &j
+}
+
+func (j *Journal) record(dir string, step Step) *Record {
+	for _, v := range j.Records {
+		if v.Dir == dir && v.Step == step {
+			return v
+		}
+	}
+	return nil
+}
+
+// Begun reports the step on the dir is started, maybe done
+func (j *Journal) Begun(dir string, step Step) bool {
+	return j.record(dir, step) != nil
+}
+
+func (j *Journal) Done(dir string, step Step) bool {
+	rec := j.record(dir, step)
+	return rec != nil && rec.Done
+}
+
+// Do runs the step on the dir unless it's done, the step is recorded before and after running
+func (j *Journal) Do(dir string, step Step, fn func() error) error {
+	rec := j.record(dir, step)
+	if rec != nil && rec.Done {
+		return nil
+	}
+	if rec == nil {
+		rec = &Record{Dir: dir, Step: step}
+		j.Records = append(j.Records, rec)
+		err := j.save()
+		if err != nil {
+			return err
+		}
+	}
+	err := fn()
+	if err != nil {
+		return err
+	}
+	rec.Done = true
+	return j.save()
+}
+
+// Swap returns the exchange of the dir recorded, nil if the dir isn't exchanged
+func (j *Journal) Swap(dir string) *util.DirSwap {
+	rec := j.record(dir, StepSwap)
+	if rec == nil {
+		return nil
+	}
+	return rec.Swap
+}
+
+// SetSwap records the exchange of the dir before exchanging, nil if the dir isn't exchanged
+func (j *Journal) SetSwap(dir string, swap *util.DirSwap) error {
+	rec := j.record(dir, StepSwap)
+	if rec == nil {
+		rec = &Record{Dir: dir, Step: StepSwap}
+		j.Records = append(j.Records, rec)
+	}
+	rec.Swap = swap
+	return j.save()
+}
+
+// IsSwapping reports a dir is partially replaced, the rollback can only be finished or reversed
+func (j *Journal) IsSwapping(dirs []string) bool {
+	for _, v := range j.records(dirs) {
+		for _, step := range swapSteps {
+			if v.Step == step {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// records returns the records of the dirs and their sub dirs
+func (j *Journal) records(dirs []string) []*Record {
+	var list []*Record
+	for _, v := range j.Records {
+		if isCovered(v.Dir, dirs) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Drop removes the records of the dirs, the journal is removed if empty
+func (j *Journal) Drop(dirs []string) error {
+	var list []*Record
+	for _, v := range j.Records {
+		if !isCovered(v.Dir, dirs) {
+			list = append(list, v)
+		}
+	}
+	j.Records = list
+	if len(j.Records) == 0 {
+		err := os.Remove(j.filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return j.save()
+}
+
+func isCovered(dir string, dirs []string) bool {
+	for _, v := range dirs {
+		if dir == v || strings.HasPrefix(dir, strings.TrimSuffix(v, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the journal to a temporary file, then renames it, both are fsynced
+func (j *Journal) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(j.filename), 0750)
+	if err != nil {
+		return err
+	}
+	tmpFile := j.filename + "-" + util.MakeRandomString(util.MinRandomLen)
+	f, err := os.OpenFile(filepath.Clean(tmpFile), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	n, err := f.Write(data)
+	if err == nil && n < len(data) {
+		err = io.ErrShortWrite
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, j.filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return err
+	}
+	d, err := os.Open(filepath.Dir(j.filename))
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err1 := d.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
@@ -0,0 +1,295 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package journal
+
+import (
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const _version = "v23.0.0.1"
+
+var errCrash = errors.New("crash")
+
+// the step the crash is injected into, 0 never crashes
+type crasher struct {
+	at    int
+	count int
+	after bool
+}
+
+// step runs the fn, then crashes if it's the step to crash after, or crashes instead of running it
+func (c *crasher) step(fn func() error) error {
+	c.count++
+	if c.count == c.at && !c.after {
+		return errCrash
+	}
+	err := fn()
+	if err != nil {
+		return err
+	}
+	if c.count == c.at {
+		return errCrash
+	}
+	return nil
+}
+
+// testOps roll back the dirs under root/sys to root/snap, the file 'keep' is filtered
+type testOps struct {
+	j           *Journal
+	root        string
+	canExchange bool
+	crash       *crasher
+}
+
+func (ops *testOps) rel(dir string) string {
+	return strings.TrimPrefix(dir, ops.root)
+}
+
+func (ops *testOps) Prepare(dir string) error {
+	return ops.crash.step(func() error {
+		_, err := util.HandlerDirPrepare(strings.Replace(ops.rel(dir), "/sys/", "/snap/", 1),
+			ops.rel(dir), _version, ops.root, nil)
+		return err
+	})
+}
+
+func (ops *testOps) FilterCopy(dir string) error {
+	return ops.crash.step(func() error {
+		keep := filepath.Join(dir, "keep")
+		if !util.IsExists(keep) {
+			return nil
+		}
+		return util.CopyFile(keep, filepath.Join(dir, "."+_version, "keep"), true)
+	})
+}
+
+func (ops *testOps) Swap(dir string) error {
+	return ops.j.SwapDir(dir, ops.canExchange,
+		func() error {
+			return ops.crash.step(func() error {
+				_, err := util.HandlerDirMoveOut("", ops.rel(dir), _version, ops.root, nil)
+				return err
+			})
+		},
+		func() error {
+			return ops.crash.step(func() error {
+				_, err := util.HandlerDirMoveIn("", ops.rel(dir), _version, ops.root, nil)
+				return err
+			})
+		})
+}
+
+func (ops *testOps) Cleanup(dir string) error {
+	return ops.crash.step(func() error {
+		err := os.RemoveAll(filepath.Join(dir, ".old"+_version))
+		if err != nil {
+			return err
+		}
+		return os.RemoveAll(filepath.Join(dir, "."+_version))
+	})
+}
+
+func (ops *testOps) Discard(dir string) error {
+	return ops.crash.step(func() error {
+		return os.RemoveAll(filepath.Join(dir, "."+_version))
+	})
+}
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		filename := filepath.Join(root, name)
+		err := os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			t.Fatal("Except nil, but got error:", err)
+		}
+		err = ioutil.WriteFile(filename, []byte(content), 0644)
+		if err != nil {
+			t.Fatal("Except nil, but got error:", err)
+		}
+	}
+}
+
+// readTree returns the files and dirs under the root as 'path=content'
+func readTree(t *testing.T, root string) string {
+	var list []string
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(path, root)
+		if fi.IsDir() {
+			list = append(list, rel+"/")
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		list = append(list, rel+"="+string(content))
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	sort.Strings(list)
+	return strings.Join(list, "\n")
+}
+
+func setupRoot(t *testing.T) (string, []string) {
+	root, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	writeTree(t, root, map[string]string{
+		"sys/etc/a":      "old a",
+		"sys/etc/b":      "old b",
+		"sys/etc/keep":   "keep",
+		"sys/etc/sub/c":  "old c",
+		"sys/usr/bin/x":  "old x",
+		"snap/etc/a":     "new a",
+		"snap/etc/sub/c": "new c",
+		"snap/etc/sub/d": "new d",
+		"snap/usr/bin/x": "new x",
+		"snap/usr/lib/y": "new y",
+	})
+	return root, []string{filepath.Join(root, "sys/etc"), filepath.Join(root, "sys/usr")}
+}
+
+const _rolledBack = `/
+/etc/
+/etc/a=new a
+/etc/keep=keep
+/etc/sub/
+/etc/sub/c=new c
+/etc/sub/d=new d
+/usr/
+/usr/bin/
+/usr/bin/x=new x
+/usr/lib/
+/usr/lib/y=new y`
+
+// rollback runs the rollback until crashed, returns whether crashed
+func rollback(t *testing.T, root string, dirs []string, canExchange bool, crash *crasher) bool {
+	j := Load(filepath.Join(root, "rollback.journal"), _version)
+	ops := &testOps{j: j, root: root, canExchange: canExchange, crash: crash}
+	err := j.Run(dirs, ops)
+	if err == nil {
+		err = j.Finish(dirs, ops)
+	}
+	if errors.Is(err, errCrash) {
+		return true
+	}
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	return false
+}
+
+func TestRollbackCrash(t *testing.T) {
+	for _, canExchange := range []bool{true, false} {
+		for _, after := range []bool{true, false} {
+			for at := 1; ; at++ {
+				name := fmt.Sprintf("exchange=%v/after=%v/step=%d", canExchange, after, at)
+				root, dirs := setupRoot(t)
+				crashed := rollback(t, root, dirs, canExchange, &crasher{at: at, after: after})
+				// replayed at the next run
+				if crashed && rollback(t, root, dirs, canExchange, &crasher{}) {
+					t.Fatal(name, "crashed without crash injected")
+				}
+				if got := readTree(t, filepath.Join(root, "sys")); got != _rolledBack {
+					t.Errorf("%s: except:\n%s\nbut got:\n%s", name, _rolledBack, got)
+				}
+				if util.IsExists(filepath.Join(root, "rollback.journal")) {
+					t.Error(name, "the journal isn't removed")
+				}
+				os.RemoveAll(root)
+				if !crashed {
+					break
+				}
+			}
+		}
+	}
+}
+
+// the crashes in the exchange: the prepared dir moved to the sibling, exchanged, and the replaced dir moved in
+func TestRollbackCrashInExchange(t *testing.T) {
+	for steps := 1; steps <= 3; steps++ {
+		root, dirs := setupRoot(t)
+		j := Load(filepath.Join(root, "rollback.journal"), _version)
+		ops := &testOps{j: j, root: root, canExchange: true, crash: &crasher{}}
+		etc := dirs[0]
+		for _, dir := range dirs {
+			for _, fn := range []func(string) error{ops.Prepare, ops.FilterCopy} {
+				if err := fn(dir); err != nil {
+					t.Fatal("Except nil, but got error:", err)
+				}
+			}
+		}
+		swap, err := util.NewDirSwap(etc, _version)
+		if err != nil {
+			t.Fatal("Except nil, but got error:", err)
+		}
+		for _, step := range []Step{StepPrepare, StepFilterCopy} {
+			for _, dir := range dirs {
+				j.Records = append(j.Records, &Record{Dir: dir, Step: step, Done: true})
+			}
+		}
+		j.Records = append(j.Records, &Record{Dir: etc, Step: StepSwap})
+		if err := j.SetSwap(etc, swap); err != nil {
+			t.Fatal("Except nil, but got error:", err)
+		}
+		staged := filepath.Join(root, "sys/.etc."+_version)
+		renames := [][2]string{
+			{filepath.Join(etc, "."+_version), staged},
+			{staged, etc},
+			{staged, filepath.Join(etc, ".old"+_version)},
+		}
+		for i, v := range renames[:steps] {
+			if i == 1 {
+				err = util.Exchange(v[0], v[1])
+			} else {
+				err = os.Rename(v[0], v[1])
+			}
+			if err != nil {
+				t.Fatal("Except nil, but got error:", err)
+			}
+		}
+		if rollback(t, root, dirs, true, &crasher{}) {
+			t.Fatal("crashed without crash injected")
+		}
+		if got := readTree(t, filepath.Join(root, "sys")); got != _rolledBack {
+			t.Errorf("step %d: except:\n%s\nbut got:\n%s", steps, _rolledBack, got)
+		}
+		os.RemoveAll(root)
+	}
+}
+
+func TestLoadOtherVersion(t *testing.T) {
+	root, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	defer os.RemoveAll(root)
+	filename := filepath.Join(root, "rollback.journal")
+	j := Load(filename, _version)
+	err = j.Do("/etc", StepPrepare, func() error { return nil })
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	if !Load(filename, _version).Done("/etc", StepPrepare) {
+		t.Error("Except the step done")
+	}
+	if len(Load(filename, "v23.0.0.2").Records) != 0 {
+		t.Error("Except the records of another version discarded")
+	}
+}
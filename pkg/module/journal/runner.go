@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package journal
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+)
+
+// Ops are the steps of the rollback on a subscribed dir. Swap and Cleanup are run again if interrupted,
+// they must finish the work left. Discard removes what Prepare and FilterCopy left
+type Ops interface {
+	Prepare(dir string) error
+	FilterCopy(dir string) error
+	Swap(dir string) error
+	Cleanup(dir string) error
+	Discard(dir string) error
+}
+
+// Run prepares, filter-copies and swaps the dirs step by step. The rollback interrupted is replayed:
+// it's finished if any dir has been swapping, otherwise the prepared dirs are discarded and the run starts over
+func (j *Journal) Run(dirs []string, ops Ops) error {
+	isSwapping := j.IsSwapping(dirs)
+	if isSwapping {
+		logger.Info("finish the interrupted rollback of", dirs)
+	} else if len(j.records(dirs)) != 0 {
+		logger.Info("discard the interrupted rollback of", dirs)
+		for _, dir := range dirs {
+			if !j.Begun(dir, StepPrepare) {
+				continue
+			}
+			err := ops.Discard(dir)
+			if err != nil {
+				return err
+			}
+		}
+		err := j.Drop(dirs)
+		if err != nil {
+			return err
+		}
+	}
+	if !isSwapping {
+		for _, step := range []struct {
+			step Step
+			fn   func(string) error
+		}{
+			{StepPrepare, ops.Prepare},
+			{StepFilterCopy, ops.FilterCopy},
+		} {
+			for _, dir := range dirs {
+				fn := step.fn
+				dir := dir
+				err := j.Do(dir, step.step, func() error { return fn(dir) })
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, dir := range dirs {
+		dir := dir
+		err := j.Do(dir, StepSwap, func() error { return ops.Swap(dir) })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish cleans up the dirs swapped, then drops their records
+func (j *Journal) Finish(dirs []string, ops Ops) error {
+	for _, dir := range dirs {
+		dir := dir
+		err := j.Do(dir, StepCleanup, func() error { return ops.Cleanup(dir) })
+		if err != nil {
+			return err
+		}
+	}
+	return j.Drop(dirs)
+}
+
+// SwapDir replaces the dir by its prepared dir of the version, run again if interrupted. The dir is
+// exchanged in one step if possible, the inodes are recorded before exchanging. Otherwise moveOut moves
+// the entries of the dir out, then moveIn moves the prepared entries in, both must be resumable
+func (j *Journal) SwapDir(dir string, canExchange bool, moveOut, moveIn func() error) error {
+	swap := j.Swap(dir)
+	if swap == nil && canExchange && !j.Begun(dir, StepMoveOut) {
+		var err error
+		swap, err = util.NewDirSwap(dir, j.Version)
+		if err != nil {
+			logger.Infof("unable to swap %s, err: %v", dir, err)
+		} else {
+			err = j.SetSwap(dir, swap)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if swap != nil {
+		err := swap.Complete()
+		if !errors.Is(err, util.ErrExchangeUnsupported) {
+			return err
+		}
+		logger.Infof("unable to swap %s, err: %v", dir, err)
+		// nothing is swapped, the prepared dir is moved back
+		err = swap.Reverse()
+		if err != nil {
+			return err
+		}
+		err = j.SetSwap(dir, nil)
+		if err != nil {
+			return err
+		}
+	}
+	err := j.Do(dir, StepMoveOut, moveOut)
+	if err != nil {
+		return err
+	}
+	return j.Do(dir, StepMoveIn, moveIn)
+}
@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"deepin-upgrade-manager/pkg/logger"
 	"deepin-upgrade-manager/pkg/module/dirinfo"
+	"deepin-upgrade-manager/pkg/module/diskinfo"
 	"os"
 	"path/filepath"
 	"strings"
@@ -48,6 +49,26 @@ func (infos MountInfoList) Match(dir string) *MountInfo {
 	return nil
 }
 
+// ESP returns the EFI System Partition mounted under the root dir, nil if not mounted. The vfat
+// partition of the ESP type is preferred, the type is unknown without udev
+func (infos MountInfoList) ESP(rootDir string) *MountInfo {
+	var unknown *MountInfo
+	for _, dir := range diskinfo.ESPMountPoints {
+		info := infos.Match(filepath.Join(rootDir, dir))
+		if info == nil || info.FSType != "vfat" {
+			continue
+		}
+		isESP, known := diskinfo.IsESPPartition(info.Partition)
+		if isESP {
+			return info
+		}
+		if !known && unknown == nil {
+			unknown = info
+		}
+	}
+	return unknown
+}
+
 func (infos MountInfoList) MatchPartition(partiton string) *MountInfo {
 	for _, info := range infos {
 		if info.Partition == partiton {
@@ -7,7 +7,6 @@ package mountpoint
 import (
 	"deepin-upgrade-manager/pkg/logger"
 	"deepin-upgrade-manager/pkg/module/util"
-	"strings"
 )
 
 const (
@@ -39,18 +38,20 @@ func (list MountPointList) Mount() (MountPointList, error) {
 	return mounted, err
 }
 
+// Umount umounts the mount points in the reverse order, the nested ones first. All are tried, the first error is returned
 func (list MountPointList) Umount() error {
-	var items []string
-	for _, mp := range list {
-		if isInListByPrefix(mp.Dest, items) {
-			continue
+	var err error
+	for i := len(list) - 1; i >= 0; i-- {
+		logger.Info("Will umount:", list[i].Dest)
+		e := list[i].Umount()
+		if e != nil {
+			logger.Warningf("failed umount %s, err: %v", list[i].Dest, e)
+			if err == nil {
+				err = e
+			}
 		}
-		items = append(items, mp.Dest)
 	}
-	var args []string
-	args = append(args, items...)
-	logger.Info("Will umount:", args)
-	return util.ExecCommand(_CMD_UMOUNT, args)
+	return err
 }
 
 func (mp *MountPoint) Mount() error {
@@ -61,7 +62,12 @@ func (mp *MountPoint) Mount() error {
 
 	var args []string
 	if mp.Bind {
-		args = append(args, []string{"-o", "bind"}...)
+		// the options such as 'ro' apply to the bind
+		opts := "bind"
+		if len(mp.Options) != 0 {
+			opts += "," + mp.Options
+		}
+		args = append(args, []string{"-o", opts}...)
 	} else {
 		args = append(args, []string{"-t", mp.FSType}...)
 		args = append(args, []string{"-o", mp.Options}...)
@@ -75,12 +81,3 @@ func (mp *MountPoint) Mount() error {
 func (mp *MountPoint) Umount() error {
 	return util.ExecCommand(_CMD_UMOUNT, []string{mp.Dest})
 }
-
-func isInListByPrefix(item string, list []string) bool {
-	for _, v := range list {
-		if strings.HasPrefix(item, v) {
-			return true
-		}
-	}
-	return false
-}
@@ -13,7 +13,6 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"sync"
 )
 
@@ -35,9 +34,17 @@ type RecordsInfo struct {
 	RollbackVersion string       `json:"RollbackVersion"`
 	RepoMount       string       `json:"Repo_Mount_Point"`
 	AferRun         string       `json:"AfterRun"`
-
-	TimeOut     uint   `json:"GrubTimeout"`
-	GrubDefault string `json:"GrubDefault"`
+	Automatic       bool         `json:"Automatic"`
+	RollbackPaths   []string     `json:"RollbackPaths"`
+	UndoVersion     string       `json:"UndoVersion"`
+	NoUndo          bool         `json:"NoUndo,omitempty"`
+	Mismatches      []string     `json:"Mismatches"`
+	EtcMerged       []string     `json:"EtcMerged"`
+	EtcConflicts    []string     `json:"EtcConflicts"`
+
+	// the grub settings replaced by the old releases, which set the rollback entry as the grub default
+	LegacyTimeOut     uint   `json:"GrubTimeout,omitempty"`
+	LegacyGrubDefault string `json:"GrubDefault,omitempty"`
 
 	filename string
 	locker   sync.RWMutex
@@ -72,7 +79,6 @@ func LoadRecords(rootfs, recordsfile, repoMount string, needcreated bool) *Recor
 	info := new(RecordsInfo)
 	path := filepath.Join(rootfs, recordsfile)
 	info.CurrentState = _UNKNOW_STATE
-	info.TimeOut = 2
 	info.RepoMount = repoMount
 	defer func() {
 		info.filename = path
@@ -163,29 +169,17 @@ func (info *RecordsInfo) IsOper() bool {
 	return info.CurrentState == _ROLLBACK_AFTEROPER
 }
 
-func (info *RecordsInfo) SetRollbackInfo(version, newdefault, oldhead string, newtime uint) {
+func (info *RecordsInfo) SetRollbackInfo(version string) {
 	info.RollbackVersion = version
 	info.save()
-	if newtime == 0 {
-		info.TimeOut = 2
-	} else {
-		info.TimeOut = newtime
-	}
-	if len(newdefault) == 0 {
-		info.GrubDefault = "0"
-	} else if strings.Contains(newdefault, oldhead) {
-		//do nothing
-	} else {
-		info.GrubDefault = newdefault
-	}
-	logger.Infof("Success to save old grub info, old time %v, old grub default %v", info.TimeOut, info.GrubDefault)
 }
 
+// Reset starts the records of the rollback to the version, the pre-rollback version left by the last one is stale
 func (info *RecordsInfo) Reset(version string) {
 	if len(info.RollbackVersion) == 0 {
 		info.RollbackVersion = version
-		info.TimeOut = 2
-		info.GrubDefault = "0"
+		info.UndoVersion = ""
+		info.NoUndo = false
 		info.save()
 	}
 }
@@ -240,6 +234,63 @@ func (info *RecordsInfo) Remove() {
 	logger.Debugf("remove records rollback state file: %s", info.filename)
 }
 
+// SetAutomatic notes the rollback was selected by grub after repeated failed boots
+func (info *RecordsInfo) SetAutomatic() {
+	info.Automatic = true
+	info.save()
+}
+
+func (info *RecordsInfo) IsAutomatic() bool {
+	return info.Automatic
+}
+
+// SetRollbackPaths saves the paths selected to roll back, empty is the whole subscribe list
+func (info *RecordsInfo) SetRollbackPaths(paths []string) {
+	info.RollbackPaths = paths
+	info.save()
+}
+
+// Paths returns the paths selected to roll back the version
+func (info *RecordsInfo) Paths(version string) []string {
+	if info.RollbackVersion != version {
+		return nil
+	}
+	return info.RollbackPaths
+}
+
+// SetUndoVersion saves the version committed before the rollback replaces the system
+func (info *RecordsInfo) SetUndoVersion(version string) {
+	info.UndoVersion = version
+	info.save()
+}
+
+// SetNoUndo saves that the system replaced by the rollback isn't kept, the rollback can't be undone
+func (info *RecordsInfo) SetNoUndo() {
+	info.NoUndo = true
+	info.save()
+}
+
+// Undo returns the version committed before rolling back to the version
+func (info *RecordsInfo) Undo(version string) string {
+	if info.RollbackVersion != version {
+		return ""
+	}
+	return info.UndoVersion
+}
+
+// SetMismatches saves the restored paths differing from the version, reported with the result
+func (info *RecordsInfo) SetMismatches(list []string) {
+	info.Mismatches = list
+	info.save()
+}
+
+// SetEtcMerge saves the paths of /etc the local changes merged to and the conflicts, reported with the result
+func (info *RecordsInfo) SetEtcMerge(merged, conflicts []string) {
+	info.EtcMerged = merged
+	info.EtcConflicts = conflicts
+	info.save()
+}
+
 func (info *RecordsInfo) SetAfterRun(cmd string) {
 	info.AferRun = cmd
 	info.save()
@@ -260,11 +311,18 @@ func (info *RecordsInfo) SaveResult(root string) (err error) {
 		}
 	}()
 	bt := []byte(strconv.Itoa(int(info.CurrentState)) + "," + info.AferRun)
+	if info.Automatic {
+		bt = append(bt, []byte(","+resultAutomatic)...)
+	}
 	if _, err = file.Write(bt); err != nil {
 		return err
 	}
 	if err = file.Sync(); err != nil {
 		return err
 	}
-	return
+	err = saveRecordLines(filepath.Join(root, SelfRecordMismatchPath), info.Mismatches)
+	if err != nil {
+		return err
+	}
+	return saveRecordLines(filepath.Join(root, SelfRecordConflictPath), info.EtcConflicts)
 }
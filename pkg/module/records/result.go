@@ -15,9 +15,19 @@ import (
 
 const (
 	SelfRecordResultPath = "/etc/deepin-upgrade-manager/result.records"
+	// the restored paths differing from the version, a line per path
+	SelfRecordMismatchPath = "/etc/deepin-upgrade-manager/mismatch.records"
+	// the conflicts of the local changes of /etc merged, a line per path
+	SelfRecordConflictPath = "/etc/deepin-upgrade-manager/conflict.records"
+
+	resultAutomatic = "automatic"
+
+	// the after run commands of the repos are joined in the result
+	AfterRunSep = ";"
 )
 
-func ReadResult() (res int, cmd string, err error) {
+// ReadResult reads the rollback result, 'state,afterrun[,automatic]'
+func ReadResult() (res int, cmd string, auto bool, err error) {
 	res = -1
 	if !util.IsExists(SelfRecordResultPath) {
 		err = errors.New("file isn't exist")
@@ -46,9 +56,12 @@ func ReadResult() (res int, cmd string, err error) {
 	if err != nil {
 		return 
 	}
-	if len(line) == 2 {
+	if len(line) >= 2 {
 		cmd = line[1]
 	}
+	if len(line) == 3 {
+		auto = line[2] == resultAutomatic
+	}
 	if RecoredState(result) == _ROLLBACK_SUCCESSED {
 		res = 1
 	}
@@ -58,6 +71,41 @@ func ReadResult() (res int, cmd string, err error) {
 	return 
 }
 
+// ReadMismatches reads the restored paths differing from the version, empty if all matched
+func ReadMismatches() []string {
+	return readRecordLines(SelfRecordMismatchPath)
+}
+
+// ReadConflicts reads the conflicts of the local changes of /etc merged, empty if none
+func ReadConflicts() []string {
+	return readRecordLines(SelfRecordConflictPath)
+}
+
+func readRecordLines(filename string) []string {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	var list []string
+	for _, v := range strings.Split(string(content), "\n") {
+		if len(v) != 0 {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func saveRecordLines(filename string, list []string) error {
+	if len(list) == 0 {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(filename, []byte(strings.Join(list, "\n")+"\n"), 0644)
+}
+
 func RemoveResult() bool {
 	if util.IsExists(SelfRecordResultPath) {
 		os.RemoveAll(SelfRecordResultPath)
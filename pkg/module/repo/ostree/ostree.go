@@ -6,13 +6,16 @@ package ostree
 
 import (
 	"deepin-upgrade-manager/pkg/module/repo/branch"
+	"deepin-upgrade-manager/pkg/module/repo/tree"
 	"deepin-upgrade-manager/pkg/module/util"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -112,6 +115,18 @@ func (repo *OSTree) Snapshot(branchName, dstDir string) error {
 	return err
 }
 
+// Checkout checks out the subpath of the version to the dstDir, keeps the mode, ownership and xattrs,
+// the dstDir is the subpath itself if it is a directory, otherwise the dstDir contains the file.
+// The files are copied rather than hardlinked to the objects, they are moved out and may be modified
+func (repo *OSTree) Checkout(branchName, subpath, dstDir string) error {
+	if !repo.Exist(branchName) {
+		return fmt.Errorf("not found the branchName: %s", branchName)
+	}
+	_, err := doAction([]string{"checkout", "--repo=" + repo.repoDir, "--force-copy",
+		"--subpath=" + subpath, branchName, dstDir})
+	return err
+}
+
 func (repo *OSTree) Commit(branchName, subject, dataDir string) error {
 	if !branch.IsValid(branchName) {
 		return fmt.Errorf("invalid branch name: %s", branchName)
@@ -136,14 +151,54 @@ func (repo *OSTree) Diff(baseBranch, targetBranch, dstFile string) error {
 }
 
 func (repo *OSTree) Cat(branchName, filepath, dstFile string) error {
-	data, err := doAction([]string{"cat", "--repo=" + repo.repoDir, branchName,
-		filepath})
+	data, err := repo.Read(branchName, filepath)
 	if err != nil {
 		return err
 	}
 	return ioutil.WriteFile(dstFile, data, 0600)
 }
 
+func (repo *OSTree) Read(branchName, filepath string) ([]byte, error) {
+	return doAction([]string{"cat", "--repo=" + repo.repoDir, branchName,
+		filepath})
+}
+
+// Ls lists the files of the path in the version, if not recursive only the path itself is listed
+func (repo *OSTree) Ls(branchName, filepath string, recursive bool) (tree.FileInfoList, error) {
+	args := []string{"ls", "--repo=" + repo.repoDir, "-C"}
+	if recursive {
+		args = append(args, "-R")
+	} else {
+		args = append(args, "-d")
+	}
+	args = append(args, branchName, filepath)
+	out, err := doAction(args)
+	if err != nil {
+		return nil, err
+	}
+	var list tree.FileInfoList
+	lines := strings.Split(string(out), "\n")
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		info, err := parseLsLine(line)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+// OpenObject opens the content of the file object, the file objects of the bare repos are the files themselves
+func (repo *OSTree) OpenObject(checksum string) (io.ReadCloser, error) {
+	if len(checksum) < 3 {
+		return nil, fmt.Errorf("invalid checksum: %q", checksum)
+	}
+	return os.Open(filepath.Join(repo.repoDir, "objects", checksum[:2], checksum[2:]+".file"))
+}
+
 func (repo *OSTree) Previous(targetBranch string) (string, error) {
 	list, err := repo.listRefs()
 	if err != nil {
@@ -278,6 +333,51 @@ func (repo *OSTree) CommitTime(branchName string) (string, error) {
 	return "", nil
 }
 
+// Checksum returns the commit checksum of the version, it changes whenever the version is committed again
+func (repo *OSTree) Checksum(branchName string) (string, error) {
+	out, err := doAction([]string{"rev-parse", "--repo=" + repo.repoDir, branchName})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ex: '-00644 0 0 102 <checksum> /etc/fstab'
+// ex: 'd00755 0 0 0 <contents checksum> <meta checksum> /etc'
+// ex: 'l00777 0 0 0 <checksum> /etc/mtab -> ../proc/self/mounts'
+func parseLsLine(line string) (*tree.FileInfo, error) {
+	items := strings.Fields(line)
+	if len(items) < 6 || len(items[0]) < 2 {
+		return nil, fmt.Errorf("invalid ls line: %s", line)
+	}
+	var info tree.FileInfo
+	info.Type = items[0][0]
+	mode, err := strconv.ParseUint(items[0][1:], 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ls mode: %s", line)
+	}
+	info.Mode = os.FileMode(mode)
+	uid, _ := strconv.ParseUint(items[1], 10, 32)
+	gid, _ := strconv.ParseUint(items[2], 10, 32)
+	info.Uid = uint32(uid)
+	info.Gid = uint32(gid)
+	info.Size, _ = strconv.ParseInt(items[3], 10, 64)
+	info.Checksum = items[4]
+	rest := items[5:]
+	if info.IsDir() && len(rest) > 1 {
+		rest = rest[1:]
+	}
+	name := strings.Join(rest, " ")
+	if info.IsSymlink() {
+		if idx := strings.Index(name, " -> "); idx >= 0 {
+			info.Target = name[idx+4:]
+			name = name[:idx]
+		}
+	}
+	info.Path = name
+	return &info, nil
+}
+
 func doAction(args []string) ([]byte, error) {
 	out, err := util.ExecCommandWithOut("ostree", args)
 	if err != nil {
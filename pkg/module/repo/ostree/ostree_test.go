@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package ostree
+
+import (
+	"deepin-upgrade-manager/pkg/module/repo/tree"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseLsLine(t *testing.T) {
+	var cases = []struct {
+		line string
+		want *tree.FileInfo
+	}{
+		{
+			line: "d00755 0 0      0 446a0ef11b7cc167f3b603e585c7eeeeb675faa412d5ec73f62988eb0b6c5488 " +
+				"ad49a0f4e3bc165361b6d17e8a865d479b373ee67d89ac6f0ce871f27da1be6d /usr",
+			want: &tree.FileInfo{Path: "/usr", Type: tree.TYPE_DIR, Mode: 0755,
+				Checksum: "446a0ef11b7cc167f3b603e585c7eeeeb675faa412d5ec73f62988eb0b6c5488"},
+		},
+		{
+			line: "-00640 0 42   1398 0f3c5fdbb6ee2a3a4b7b7bba8b84dd4b7dd8d6d0a6a1f2f5b0a2a3a9d5e1c7b0 /etc/shadow",
+			want: &tree.FileInfo{Path: "/etc/shadow", Type: tree.TYPE_FILE, Mode: 0640, Gid: 42, Size: 1398,
+				Checksum: "0f3c5fdbb6ee2a3a4b7b7bba8b84dd4b7dd8d6d0a6a1f2f5b0a2a3a9d5e1c7b0"},
+		},
+		{
+			line: "-00644 1000 1000     12 9a8e7c3d2b1f0e4a5d6c7b8a9f0e1d2c3b4a5f6e7d8c9b0a1f2e3d4c5b6a7f8e /home/a b.txt",
+			want: &tree.FileInfo{Path: "/home/a b.txt", Type: tree.TYPE_FILE, Mode: 0644, Uid: 1000, Gid: 1000, Size: 12,
+				Checksum: "9a8e7c3d2b1f0e4a5d6c7b8a9f0e1d2c3b4a5f6e7d8c9b0a1f2e3d4c5b6a7f8e"},
+		},
+		{
+			line: "l00777 0 0      4 c2b4d6f8a0e1c3b5d7f9a1e3c5b7d9f0a2e4c6b8d0f1a3e5c7b9d1f2a4e6c8b0 /usr/bin/sh -> dash",
+			want: &tree.FileInfo{Path: "/usr/bin/sh", Type: tree.TYPE_SYMLINK, Mode: os.FileMode(0777), Size: 4,
+				Checksum: "c2b4d6f8a0e1c3b5d7f9a1e3c5b7d9f0a2e4c6b8d0f1a3e5c7b9d1f2a4e6c8b0", Target: "dash"},
+		},
+	}
+	for _, c := range cases {
+		got, err := parseLsLine(c.line)
+		if err != nil {
+			t.Errorf("Except nil, but got error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Except %+v, but got %+v", c.want, got)
+		}
+	}
+
+	for _, line := range []string{
+		"d00755 0 0 0 /usr",
+		"x 0 0 0 446a0ef1 /usr",
+		"-0064z 0 0 12 9a8e7c3d /etc/hosts",
+	} {
+		if _, err := parseLsLine(line); err == nil {
+			t.Errorf("Except error of %q, but got nil", line)
+		}
+	}
+}
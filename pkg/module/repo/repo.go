@@ -7,7 +7,9 @@ package repo
 import (
 	"deepin-upgrade-manager/pkg/module/repo/branch"
 	"deepin-upgrade-manager/pkg/module/repo/ostree"
+	"deepin-upgrade-manager/pkg/module/repo/tree"
 	"fmt"
+	"io"
 )
 
 type Repository interface {
@@ -18,13 +20,18 @@ type Repository interface {
 	List() (branch.BranchList, error)
 	ListByName(branchName string, offset, limit int) (branch.BranchList, int, error)
 	Snapshot(branchName, dstDir string) error
+	Checkout(branchName, subpath, dstDir string) error
 	Commit(branchName, subject, dataDir string) error
 	Diff(baseBranch, targetBranch, dstFile string) error
 	Cat(branchName, filepath, dstFile string) error
+	Read(branchName, filepath string) ([]byte, error)
+	Ls(branchName, filepath string, recursive bool) (tree.FileInfoList, error)
+	OpenObject(checksum string) (io.ReadCloser, error)
 	Previous(targetName string) (string, error)
 	Delete(version string) error
 	Subject(branchName string) (string, error)
 	CommitTime(branchName string) (string, error)
+	Checksum(branchName string) (string, error)
 }
 
 const (
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package tree
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	TYPE_FILE    = '-'
+	TYPE_DIR     = 'd'
+	TYPE_SYMLINK = 'l'
+)
+
+// FileInfo is a file entry stored in a repo version
+type FileInfo struct {
+	Path     string
+	Type     byte
+	Mode     os.FileMode
+	Uid      uint32
+	Gid      uint32
+	Size     int64
+	Checksum string
+	Target   string
+}
+
+type FileInfoList []*FileInfo
+
+func (info *FileInfo) IsDir() bool {
+	return info.Type == TYPE_DIR
+}
+
+func (info *FileInfo) IsRegular() bool {
+	return info.Type == TYPE_FILE
+}
+
+func (info *FileInfo) IsSymlink() bool {
+	return info.Type == TYPE_SYMLINK
+}
+
+func (list FileInfoList) Match(path string) *FileInfo {
+	for _, v := range list {
+		if v.Path == path {
+			return v
+		}
+	}
+	return nil
+}
+
+func (list FileInfoList) Query(dir string) FileInfoList {
+	var infos FileInfoList
+	for _, v := range list {
+		if v.Path == dir || strings.HasPrefix(v.Path, strings.TrimSuffix(dir, "/")+"/") {
+			infos = append(infos, v)
+		}
+	}
+	return infos
+}
+
+func (list FileInfoList) Map() map[string]*FileInfo {
+	mp := make(map[string]*FileInfo, len(list))
+	for _, v := range list {
+		mp[v.Path] = v
+	}
+	return mp
+}
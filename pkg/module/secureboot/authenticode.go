@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package secureboot
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"sort"
+)
+
+// AuthenticodeHash returns the sha256 of the PE image as dbx lists it, the checksum, the certificate
+// table entry and the certificate table itself are excluded
+func AuthenticodeHash(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	invalid := errors.New("invalid PE image: " + filename)
+	if len(data) < 0x40 {
+		return nil, invalid
+	}
+	peOff := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	if peOff+24 > len(data) || string(data[peOff:peOff+4]) != "PE\x00\x00" {
+		return nil, invalid
+	}
+	sectionCount := int(binary.LittleEndian.Uint16(data[peOff+6:]))
+	optSize := int(binary.LittleEndian.Uint16(data[peOff+20:]))
+	optOff := peOff + 24
+	if optOff+optSize > len(data) || optSize < 2 {
+		return nil, invalid
+	}
+	var dirOff int
+	switch binary.LittleEndian.Uint16(data[optOff:]) {
+	case 0x10b:
+		dirOff = optOff + 96
+	case 0x20b:
+		dirOff = optOff + 112
+	default:
+		return nil, invalid
+	}
+	checksumOff := optOff + 64
+	// the 5th data directory
+	certEntryOff := dirOff + 4*8
+	if certEntryOff+8 > optOff+optSize {
+		return nil, invalid
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[optOff+60:]))
+	certOff := int(binary.LittleEndian.Uint32(data[certEntryOff:]))
+	certSize := int(binary.LittleEndian.Uint32(data[certEntryOff+4:]))
+	sectionOff := optOff + optSize
+	if headerSize > len(data) || headerSize < certEntryOff+8 || sectionOff+sectionCount*40 > len(data) {
+		return nil, invalid
+	}
+
+	h := sha256.New()
+	h.Write(data[:checksumOff])
+	h.Write(data[checksumOff+4 : certEntryOff])
+	h.Write(data[certEntryOff+8 : headerSize])
+	type section struct {
+		offset, size int
+	}
+	var sections []section
+	for i := 0; i < sectionCount; i++ {
+		off := sectionOff + i*40
+		size := int(binary.LittleEndian.Uint32(data[off+16:]))
+		if size == 0 {
+			continue
+		}
+		sections = append(sections, section{offset: int(binary.LittleEndian.Uint32(data[off+20:])), size: size})
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].offset < sections[j].offset
+	})
+	hashed := headerSize
+	for _, v := range sections {
+		if v.offset+v.size > len(data) {
+			return nil, invalid
+		}
+		h.Write(data[v.offset : v.offset+v.size])
+		hashed += v.size
+	}
+	// the data after the sections, before the certificate table
+	end := len(data)
+	if certSize != 0 && certOff+certSize <= len(data) {
+		end -= certSize
+	}
+	if hashed < end {
+		h.Write(data[hashed:end])
+	}
+	return h.Sum(nil), nil
+}
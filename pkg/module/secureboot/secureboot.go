@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package secureboot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	EFIVarsDir = "/sys/firmware/efi/efivars"
+
+	globalGUID        = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
+	imageSecurityGUID = "d719b2cb-3d3a-4596-a3bc-dad00e67656f"
+	shimLockGUID      = "605dab50-e046-4300-abb6-3dd810dd8b23"
+)
+
+// the validation status of the kernel
+const (
+	StatusVerified  = "verified"
+	StatusUnsigned  = "unsigned"
+	StatusUntrusted = "untrusted" // signed by none of the enrolled certificates
+	StatusRevoked   = "revoked"   // the hash or a signing certificate is in dbx or MokListX
+	StatusUnknown   = "unknown"
+)
+
+// EFI_CERT_X509_GUID a5c059a1-94e4-4aa7-87b5-ab155c2bf072 in memory order
+var certX509GUID = []byte{0xa1, 0x59, 0xc0, 0xa5, 0xe4, 0x94, 0xa7, 0x4a,
+	0x87, 0xb5, 0xab, 0x15, 0x5c, 0x2b, 0xf0, 0x72}
+
+// EFI_CERT_SHA256_GUID c1c41626-504c-4092-aca9-41f936934328 in memory order
+var certSHA256GUID = []byte{0x26, 0x16, 0xc4, 0xc1, 0x4c, 0x50, 0x92, 0x40,
+	0xac, 0xa9, 0x41, 0xf9, 0x36, 0x93, 0x43, 0x28}
+
+// IsFailed returns whether the kernel of the status would be refused
+func IsFailed(status string) bool {
+	return status == StatusUnsigned || status == StatusUntrusted || status == StatusRevoked
+}
+
+// readVar returns the data of the efi variable without the attributes
+func readVar(name, guid string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(EFIVarsDir, name+"-"+guid))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("invalid efi variable %s", name)
+	}
+	return data[4:], nil
+}
+
+// IsEnabled returns whether the kernels are validated on boot, shim doesn't validate if disabled by mokutil
+func IsEnabled() bool {
+	data, err := readVar("SecureBoot", globalGUID)
+	if err != nil || len(data) == 0 || data[0] != 1 {
+		return false
+	}
+	data, err = readVar("MokSBStateRT", shimLockGUID)
+	if err == nil && len(data) != 0 && data[0] == 1 {
+		return false
+	}
+	return true
+}
+
+// SignatureList is the x509 certificates and the sha256 hashes of the images in the EFI_SIGNATURE_LIST array
+type SignatureList struct {
+	Certs  [][]byte
+	Hashes [][]byte
+}
+
+// ParseSignatureLists returns the signatures in the EFI_SIGNATURE_LIST array, the other types are skipped
+func ParseSignatureLists(data []byte) (*SignatureList, error) {
+	var list SignatureList
+	for len(data) != 0 {
+		// SignatureType, SignatureListSize, SignatureHeaderSize, SignatureSize
+		if len(data) < 28 {
+			return nil, errors.New("truncated signature list")
+		}
+		listSize := binary.LittleEndian.Uint32(data[16:20])
+		headerSize := binary.LittleEndian.Uint32(data[20:24])
+		sigSize := binary.LittleEndian.Uint32(data[24:28])
+		if uint64(listSize) < 28+uint64(headerSize) || uint64(listSize) > uint64(len(data)) || sigSize <= 16 {
+			return nil, errors.New("invalid signature list")
+		}
+		var sigList *[][]byte
+		switch {
+		case bytes.Equal(data[:16], certX509GUID):
+			sigList = &list.Certs
+		case bytes.Equal(data[:16], certSHA256GUID) && sigSize == 16+sha256.Size:
+			sigList = &list.Hashes
+		}
+		if sigList != nil {
+			// each EFI_SIGNATURE_DATA is the owner guid and the signature
+			for sigs := data[28+headerSize : listSize]; uint32(len(sigs)) >= sigSize; sigs = sigs[sigSize:] {
+				*sigList = append(*sigList, sigs[16:sigSize])
+			}
+		}
+		data = data[listSize:]
+	}
+	return &list, nil
+}
+
+// loadSignatures returns the signatures of the efi variables, the variables unable to read are skipped
+func loadSignatures(vars []struct{ name, guid string }) *SignatureList {
+	var list SignatureList
+	for _, v := range vars {
+		data, err := readVar(v.name, v.guid)
+		if err != nil {
+			continue
+		}
+		sigs, err := ParseSignatureLists(data)
+		if err != nil {
+			continue
+		}
+		list.Certs = append(list.Certs, sigs.Certs...)
+		list.Hashes = append(list.Hashes, sigs.Hashes...)
+	}
+	return &list
+}
+
+// EnrolledCerts returns the certificates of db and the machine owner keys, shim mirrors its
+// vendor certificate into MokListRT
+func EnrolledCerts() [][]byte {
+	return loadSignatures([]struct{ name, guid string }{
+		{"db", imageSecurityGUID},
+		{"MokListRT", shimLockGUID},
+	}).Certs
+}
+
+// DeniedSignatures returns the signatures of dbx and the machine owner keys revoked,
+// shim mirrors its vendor dbx into MokListXRT
+func DeniedSignatures() *SignatureList {
+	return loadSignatures([]struct{ name, guid string }{
+		{"dbx", imageSecurityGUID},
+		{"MokListXRT", shimLockGUID},
+	})
+}
+
+// Verifier checks the signatures of the kernels against the enrolled certificates by sbverify,
+// the kernels whose hash or signing certificate is denied are revoked
+type Verifier struct {
+	dir             string
+	certFiles       []string
+	deniedCertFiles []string
+	deniedHashes    [][]byte
+}
+
+func NewVerifier() (*Verifier, error) {
+	_, err := exec.LookPath("sbverify")
+	if err != nil {
+		return nil, err
+	}
+	certs := EnrolledCerts()
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates enrolled")
+	}
+	dir, err := ioutil.TempDir("", "secureboot-")
+	if err != nil {
+		return nil, err
+	}
+	denied := DeniedSignatures()
+	v := &Verifier{dir: dir, deniedHashes: denied.Hashes}
+	v.certFiles, err = writeCertFiles(dir, "cert", certs)
+	if err == nil {
+		v.deniedCertFiles, err = writeCertFiles(dir, "denied", denied.Certs)
+	}
+	if err != nil {
+		v.Close()
+		return nil, err
+	}
+	return v, nil
+}
+
+func writeCertFiles(dir, prefix string, certs [][]byte) ([]string, error) {
+	var files []string
+	for i, cert := range certs {
+		filename := filepath.Join(dir, fmt.Sprintf("%s-%d.pem", prefix, i))
+		err := ioutil.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, filename)
+	}
+	return files, nil
+}
+
+// Verify returns the status of the kernel
+func (v *Verifier) Verify(kernel string) string {
+	out, err := exec.Command("sbverify", "--list", kernel).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), "No signature table") {
+			return StatusUnsigned
+		}
+		return StatusUnknown
+	}
+	if len(v.deniedHashes) != 0 {
+		hash, err := AuthenticodeHash(kernel)
+		if err != nil {
+			return StatusUnknown
+		}
+		for _, denied := range v.deniedHashes {
+			if bytes.Equal(hash, denied) {
+				return StatusRevoked
+			}
+		}
+	}
+	for _, cert := range v.deniedCertFiles {
+		if exec.Command("sbverify", "--cert", cert, kernel).Run() == nil {
+			return StatusRevoked
+		}
+	}
+	for _, cert := range v.certFiles {
+		if exec.Command("sbverify", "--cert", cert, kernel).Run() == nil {
+			return StatusVerified
+		}
+	}
+	return StatusUntrusted
+}
+
+func (v *Verifier) Close() {
+	_ = os.RemoveAll(v.dir)
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package secureboot
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"io/ioutil"
+	"testing"
+)
+
+// testdata/dbx.esl holds an x509 list of one certificate, a sha256 list of two hashes and an rsa2048 list
+func TestParseSignatureLists(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/dbx.esl")
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	list, err := ParseSignatureLists(data)
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	if len(list.Certs) != 1 {
+		t.Fatalf("Except 1 certificate, but got %d", len(list.Certs))
+	}
+	cert, err := x509.ParseCertificate(list.Certs[0])
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	if cert.Subject.CommonName != "Deepin Test Revoked Key" {
+		t.Errorf("Except Deepin Test Revoked Key, but got %s", cert.Subject.CommonName)
+	}
+	if len(list.Hashes) != 2 {
+		t.Fatalf("Except 2 hashes, but got %d", len(list.Hashes))
+	}
+	for i, v := range []string{"revoked kernel 1", "revoked kernel 2"} {
+		if sum := sha256.Sum256([]byte(v)); string(list.Hashes[i]) != string(sum[:]) {
+			t.Errorf("Except %x, but got %x", sum, list.Hashes[i])
+		}
+	}
+
+	for _, v := range [][]byte{data[:20], data[:len(data)-1]} {
+		if _, err = ParseSignatureLists(v); err == nil {
+			t.Error("Except error of the truncated list, but got nil")
+		}
+	}
+	list, err = ParseSignatureLists(nil)
+	if err != nil || len(list.Certs) != 0 || len(list.Hashes) != 0 {
+		t.Errorf("Except empty list, but got %v, %v", list, err)
+	}
+}
@@ -0,0 +1,215 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package util
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// from /usr/include/linux/fs.h
+	_RENAME_EXCHANGE = 1 << 1
+	_AT_FDCWD        = -100
+)
+
+// the syscall package lacks renameat2 on some architectures
+var _SYS_RENAMEAT2 = map[string]uintptr{
+	"386":      353,
+	"amd64":    316,
+	"arm":      382,
+	"arm64":    276,
+	"loong64":  276,
+	"mips":     4351,
+	"mipsle":   4351,
+	"mips64":   5311,
+	"mips64le": 5311,
+	"ppc64":    357,
+	"ppc64le":  357,
+	"riscv64":  276,
+	"s390x":    347,
+}
+
+// ErrExchangeUnsupported is returned if the kernel or the filesystem can't exchange the paths
+var ErrExchangeUnsupported = errors.New("the exchange of paths is unsupported")
+
+// Exchange atomically exchanges the two paths by renameat2 RENAME_EXCHANGE, both must exist
+func Exchange(path1, path2 string) error {
+	trap, ok := _SYS_RENAMEAT2[runtime.GOARCH]
+	if !ok {
+		return ErrExchangeUnsupported
+	}
+	p1, err := syscall.BytePtrFromString(path1)
+	if err != nil {
+		return err
+	}
+	p2, err := syscall.BytePtrFromString(path2)
+	if err != nil {
+		return err
+	}
+	fd := _AT_FDCWD
+	_, _, errno := syscall.Syscall6(trap, uintptr(fd), uintptr(unsafe.Pointer(p1)),
+		uintptr(fd), uintptr(unsafe.Pointer(p2)), _RENAME_EXCHANGE, 0)
+	switch errno {
+	case 0:
+		return nil
+	case syscall.ENOSYS, syscall.EINVAL:
+		return ErrExchangeUnsupported
+	}
+	return &os.LinkError{Op: "renameat2", Old: path1, New: path2, Err: errno}
+}
+
+// DirSwap is a directory replaced by the prepared dir in one exchange. The inodes of the directories
+// are recorded before swapping, so an interrupted swap can be completed or reversed by where they are
+type DirSwap struct {
+	Dir      string `json:"dir"`
+	Version  string `json:"version"`
+	OldInode uint64 `json:"old_inode"`
+	NewInode uint64 `json:"new_inode"`
+}
+
+// NewDirSwap returns the swap of the dir and its prepared dir of the version, the dir
+// can't be swapped if it's a mount point, the prepared dir is moved to a sibling first
+func NewDirSwap(dir, version string) (*DirSwap, error) {
+	swap := &DirSwap{Dir: dir, Version: version}
+	var err error
+	swap.OldInode, err = inode(dir)
+	if err != nil {
+		return nil, err
+	}
+	swap.NewInode, err = inode(swap.preparedDir())
+	if err != nil {
+		return nil, err
+	}
+	parentDev, err := device(filepath.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+	dev, err := device(dir)
+	if err != nil {
+		return nil, err
+	}
+	if dir == filepath.Dir(dir) || dev != parentDev {
+		return nil, ErrExchangeUnsupported
+	}
+	return swap, nil
+}
+
+// the dir of the version prepared by HandlerDirPrepare
+func (swap *DirSwap) preparedDir() string {
+	return filepath.Join(swap.Dir, "."+swap.Version)
+}
+
+// the dir replaced, removed after the rollback
+func (swap *DirSwap) oldDir() string {
+	return filepath.Join(swap.Dir, ".old"+swap.Version)
+}
+
+// the sibling of the dir, a dir can't be exchanged with its sub dir
+func (swap *DirSwap) stagedDir() string {
+	return filepath.Join(filepath.Dir(swap.Dir), "."+filepath.Base(swap.Dir)+"."+swap.Version)
+}
+
+// Complete replaces the dir by the prepared dir, the dir replaced is moved to oldDir. Every
+// step is skipped if done, the dir always exists
+func (swap *DirSwap) Complete() error {
+	err := swap.move(swap.preparedDir(), swap.stagedDir(), swap.NewInode)
+	if err != nil {
+		return err
+	}
+	if swap.is(swap.Dir, swap.OldInode) && swap.is(swap.stagedDir(), swap.NewInode) {
+		err = Exchange(swap.stagedDir(), swap.Dir)
+		if err != nil {
+			return err
+		}
+		syncDir(filepath.Dir(swap.Dir))
+		logger.Info("swap the dir:", swap.Dir)
+	}
+	err = swap.move(swap.stagedDir(), swap.oldDir(), swap.OldInode)
+	if err != nil {
+		return err
+	}
+	if !swap.is(swap.Dir, swap.NewInode) {
+		return fmt.Errorf("failed swap %s, the dir is unknown", swap.Dir)
+	}
+	return nil
+}
+
+// Reverse restores the dir replaced, the prepared dir is moved back. Every step is skipped if done
+func (swap *DirSwap) Reverse() error {
+	err := swap.move(swap.oldDir(), swap.stagedDir(), swap.OldInode)
+	if err != nil {
+		return err
+	}
+	if swap.is(swap.Dir, swap.NewInode) && swap.is(swap.stagedDir(), swap.OldInode) {
+		err = Exchange(swap.stagedDir(), swap.Dir)
+		if err != nil {
+			return err
+		}
+		syncDir(filepath.Dir(swap.Dir))
+		logger.Info("restore the dir:", swap.Dir)
+	}
+	err = swap.move(swap.stagedDir(), swap.preparedDir(), swap.NewInode)
+	if err != nil {
+		return err
+	}
+	if !swap.is(swap.Dir, swap.OldInode) {
+		return fmt.Errorf("failed restore %s, the dir is unknown", swap.Dir)
+	}
+	return nil
+}
+
+// move renames the src to the dst if the src is the dir of the inode
+func (swap *DirSwap) move(src, dst string, ino uint64) error {
+	if !swap.is(src, ino) {
+		return nil
+	}
+	err := os.Rename(src, dst)
+	if err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(src))
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+func (swap *DirSwap) is(path string, ino uint64) bool {
+	i, err := inode(path)
+	return err == nil && i == ino
+}
+
+func inode(path string) (uint64, error) {
+	var st syscall.Stat_t
+	err := syscall.Lstat(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+func device(path string) (uint64, error) {
+	var st syscall.Stat_t
+	err := syscall.Lstat(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Dev), nil
+}
+
+// syncDir flushes the entries of the dir, the renames are durable
+func syncDir(dir string) {
+	f, err := os.Open(filepath.Clean(dir))
+	if err != nil {
+		return
+	}
+	_ = f.Sync()
+	_ = f.Close()
+}
@@ -317,7 +317,81 @@ func RemoveAttr(src string) (err error) {
 	return
 }
 
+// KeptAttrs are the inode flags set by chattr that are kept with the versions
+const KeptAttrs int32 = attr.FS_IMMUTABLE_FL | attr.FS_APPEND_FL | attr.FS_NODUMP_FL |
+	attr.FS_NOATIME_FL | attr.FS_SYNC_FL | attr.FS_DIRSYNC_FL
+
+// LockAttrs are the inode flags preventing the file from being moved or removed
+const LockAttrs int32 = attr.FS_IMMUTABLE_FL | attr.FS_APPEND_FL
+
+func openAttrFile(src string) (*os.File, error) {
+	return os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+}
+
+// GetFileAttr returns the inode flags of the regular file or dir
+func GetFileAttr(src string) (attrs int32, err error) {
+	fd, err := openAttrFile(src)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := fd.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	return attr.GetAttr(fd)
+}
+
+// AddFileAttr sets the inode flags on the regular file or dir, the flags set are kept
+func AddFileAttr(src string, attrs int32) (err error) {
+	fd, err := openAttrFile(src)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := fd.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	cur, err := attr.GetAttr(fd)
+	if err != nil {
+		return
+	}
+	if cur&attrs == attrs {
+		return
+	}
+	return attr.SetAttr(fd, cur|attrs)
+}
+
+// UnsetFileAttr clears the inode flags of the regular file or dir, returns the flags cleared
+func UnsetFileAttr(src string, attrs int32) (unset int32, err error) {
+	fd, err := openAttrFile(src)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if closeErr := fd.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	cur, err := attr.GetAttr(fd)
+	if err != nil {
+		return
+	}
+	unset = cur & attrs
+	if unset == 0 {
+		return
+	}
+	err = attr.UnsetAttr(fd, unset)
+	return
+}
+
 func RemoveDirAttr(orig string) error {
+	// the entries of an immutable dir can't be removed
+	_, err := UnsetFileAttr(orig, LockAttrs)
+	if err != nil {
+		logger.Debugf("failed unset the attr of %s, err: %v", orig, err)
+	}
 	fiList, err := ioutil.ReadDir(orig)
 	if err != nil {
 		return err
@@ -903,6 +977,8 @@ func HandlerDirPrepare(src, dst, version, rootdir string, filter []string) (stri
 	if IsExists(dir) {
 		os.RemoveAll(dir)
 	}
+	// left by the rollback discarded before replacing
+	os.RemoveAll(filepath.Join(dst, string("/.old")+version))
 	err := Mkdir(dst, dir)
 	logger.Debugf("start preparing the dir, src:%s, dir:%s, dst:%s, version:%s", src, dir, dst, version)
 	if err != nil {
@@ -954,6 +1030,45 @@ func HandlerDirRollback(src, dst, version, rootdir string, filter []string) (str
 	return handlerDirReplace(dst, newDir, dir, filter)
 }
 
+// @title    HandlerDirMoveOut
+// @description   the first half of HandlerDirRollback, moves the files out, can be run again if interrupted
+// @param     src         		string         		"snapshot dir, ex:/persitent/osroot/v23/2020/etc"
+// @param     dst         		string         		"dir to be rolled back, ex:/etc"
+// @param     version         	string         		"snapshot version, ex:v23.0.0.1"
+// @param     filter		    *[]string      		"list of files to filter"
+// @return    dir				string   			"generated temporary directory"
+func HandlerDirMoveOut(src, dst, version, rootdir string, filter []string) (string, error) {
+	dst = filepath.Join(rootdir, dst)
+	newDir := filepath.Join(dst, string(".")+version)
+	dir := filepath.Join(dst, string("/.old")+version)
+	err := Mkdir(dst, dir)
+	if err != nil {
+		return "", err
+	}
+	return dir, MoveDirSubFile(dst, dir, newDir, filter)
+}
+
+// @title    HandlerDirMoveIn
+// @description   the second half of HandlerDirRollback, moves the repo files in, can be run again if interrupted
+// @param     src         		string         		"snapshot dir, ex:/persitent/osroot/v23/2020/etc"
+// @param     dst         		string         		"dir to be rolled back, ex:/etc"
+// @param     version         	string         		"snapshot version, ex:v23.0.0.1"
+// @param     filter		    *[]string      		"list of files to filter"
+// @return    dir				string   			"generated temporary directory"
+func HandlerDirMoveIn(src, dst, version, rootdir string, filter []string) (string, error) {
+	dst = filepath.Join(rootdir, dst)
+	newDir := filepath.Join(dst, string(".")+version)
+	dir := filepath.Join(dst, string("/.old")+version)
+	if IsExists(newDir) {
+		err := SubMoveOut(newDir, dst)
+		if err != nil {
+			logger.Warningf("failed move sub dir, orig:%s, newDir:%s", dst, newDir)
+			return dir, err
+		}
+	}
+	return dir, nil
+}
+
 // @title    HandlerDirRecover
 // @description   file replace on rollback
 // @param     src         		string         		"snapshot dir, ex:/persitent/osroot/v23/2020/etc"
@@ -1026,6 +1141,11 @@ func GetOSInfo(target, keyname string) (string, error) {
 	} else {
 		infoPath = OSInfoPath
 	}
+	return GetOSInfoFromFile(infoPath, keyname)
+}
+
+// GetOSInfoFromFile returns the value of the key in the os-version file
+func GetOSInfoFromFile(infoPath, keyname string) (string, error) {
 	fh, err := os.Open(infoPath)
 	if err != nil {
 		return "", err
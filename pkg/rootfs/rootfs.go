@@ -8,9 +8,11 @@ import (
 	config "deepin-upgrade-manager/pkg/config/upgrader"
 	"deepin-upgrade-manager/pkg/logger"
 	"deepin-upgrade-manager/pkg/module/mountpoint"
+	"deepin-upgrade-manager/pkg/module/util"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -19,6 +21,7 @@ type Rootfs struct {
 
 	preBindList  mountpoint.MountPointList
 	repoBindList mountpoint.MountPointList
+	mounted      mountpoint.MountPointList
 
 	rootMP  string
 	baseDir string
@@ -44,10 +47,12 @@ func NewRootfs(conf *config.Config, rootMP, baseDir string) (*Rootfs, error) {
 	return &info, nil
 }
 
+// Make mounts the predefined binds, then the version dirs over them. Clean must be called even if failed
 func (fs *Rootfs) Make() error {
 	return fs.mountBindList()
 }
 
+// Clean umounts what is mounted, the root dir is removed only if all are umounted
 func (fs *Rootfs) Clean() error {
 	err := fs.umountBindList()
 	if err != nil {
@@ -57,26 +62,21 @@ func (fs *Rootfs) Clean() error {
 }
 
 func (fs *Rootfs) mountBindList() error {
-	preList, err := fs.preBindList.Mount()
-	if err != nil {
-		return preList.Umount()
-	}
-	repoList, err := fs.repoBindList.Mount()
-	if err != nil {
-		if e := preList.Umount(); e != nil {
-			logger.Warning("umount predefined list because repo list:", e)
-		}
-		return repoList.Umount()
-	}
-	return nil
+	var list mountpoint.MountPointList
+	list = append(list, fs.preBindList...)
+	list = append(list, fs.repoBindList...)
+	mounted, err := list.Mount()
+	fs.mounted = append(fs.mounted, mounted...)
+	return err
 }
 
 func (fs *Rootfs) umountBindList() error {
-	err := fs.repoBindList.Umount()
+	err := fs.mounted.Umount()
 	if err != nil {
 		return err
 	}
-	return fs.preBindList.Umount()
+	fs.mounted = nil
+	return nil
 }
 
 func (fs *Rootfs) symlinkDirList() error {
@@ -96,35 +96,43 @@ func (fs *Rootfs) symlinkDirList() error {
 	return nil
 }
 
+// getPredefinedBindList binds the dirs of the current system read-only, the command in the version must not
+// change them, ex: the dpkg database in /var. The dirs subscribed are replaced by the version
 func (fs *Rootfs) getPredefinedBindList() mountpoint.MountPointList {
 	mpList := mountpoint.MountPointList{
 		&mountpoint.MountPoint{
-			Src:  "/usr",
-			Bind: true,
+			Src:     "/usr",
+			Options: "ro",
+			Bind:    true,
 		},
 		&mountpoint.MountPoint{
-			Src:  "/var",
-			Bind: true,
+			Src:     "/var",
+			Options: "ro",
+			Bind:    true,
 		},
 		&mountpoint.MountPoint{
-			Src:  "/opt",
-			Bind: true,
+			Src:     "/opt",
+			Options: "ro",
+			Bind:    true,
 		},
 		&mountpoint.MountPoint{
-			Src:  "/root",
-			Bind: true,
+			Src:     "/root",
+			Options: "ro",
+			Bind:    true,
 		},
 		&mountpoint.MountPoint{
-			Src:  "/home",
-			Bind: true,
+			Src:     "/home",
+			Options: "ro",
+			Bind:    true,
 		},
 		&mountpoint.MountPoint{
-			Src:  "/tmp",
-			Bind: true,
+			Src:    "/tmp",
+			FSType: "tmpfs",
 		},
 		&mountpoint.MountPoint{
-			Src:  "/run",
-			Bind: true,
+			Src:     "/run",
+			Options: "ro",
+			Bind:    true,
 		},
 		&mountpoint.MountPoint{
 			Src:  "/dev",
@@ -144,44 +152,53 @@ func (fs *Rootfs) getPredefinedBindList() mountpoint.MountPointList {
 		},
 	}
 	for _, mp := range mpList {
-		mp.Src = filepath.Join(fs.rootMP, mp.Src)
 		mp.Dest = filepath.Join(fs.RootDir, mp.Src)
+		mp.Src = filepath.Join(fs.rootMP, mp.Src)
 	}
 
 	return mpList
 }
 
+// getRepoBindList binds the dirs of the version read-only, the checkout may share the files with the repo
 func (fs *Rootfs) getRepoBindList() mountpoint.MountPointList {
 	var mpList mountpoint.MountPointList
 	for _, repoConfig := range fs.conf.RepoList {
 		for _, v := range repoConfig.SubscribeList {
+			src := filepath.Join(fs.rootMP, repoConfig.SnapshotDir, fs.baseDir, v)
+			if !util.IsDir(src) {
+				logger.Debugf("%s isn't a dir of the version, skip binding", src)
+				continue
+			}
 			mpList = append(mpList, &mountpoint.MountPoint{
-				Src:  filepath.Join(fs.rootMP, repoConfig.SnapshotDir, fs.baseDir, v),
-				Dest: filepath.Join(fs.RootDir, v),
-				Bind: true,
+				Src:     src,
+				Dest:    filepath.Join(fs.RootDir, v),
+				Options: "ro",
+				Bind:    true,
 			})
 		}
 	}
+	// the parent dirs first
+	sort.Slice(mpList, func(i, j int) bool {
+		return mpList[i].Dest < mpList[j].Dest
+	})
 	return mpList
 }
 
+// getSymlinkDirList returns the dirs merged into /usr, they are symlinks under the root
 func getSymlinkDirList(dir string) []string {
 	fiList, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil
 	}
 
-	list := []string{
-		"boot",
-		"bin",
-		"sbin",
-	}
+	var list []string
 	for _, fi := range fiList {
-		if fi.IsDir() || fi.Mode().IsRegular() {
+		if fi.Mode()&os.ModeSymlink == 0 {
 			continue
 		}
-		if strings.HasPrefix(fi.Name(), "lib") {
-			list = append(list, fi.Name())
+		name := fi.Name()
+		if name == "boot" || name == "bin" || name == "sbin" || strings.HasPrefix(name, "lib") {
+			list = append(list, name)
 		}
 	}
 	return list
@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// captureAttrs saves the inode flags set by chattr on the subscribed files as the flags of the version,
+// the repo keeps no inode flags. The version not committed from the system has no flags of its files
+func (c *Upgrader) captureAttrs(version string, useSysData bool) {
+	if !useSysData {
+		return
+	}
+	attrs := make(config.FileAttrs)
+	for _, repoConf := range c.conf.RepoList {
+		filterList := nonEmptyPaths(repoConf.FilterList)
+		for _, v := range repoConf.SubscribeList {
+			_ = filepath.Walk(filepath.Join(c.rootMP, v), func(path string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				rel := util.TrimRootdir(c.rootMP, path)
+				if isPathCovered(rel, filterList) {
+					if fi.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				// only the regular files and dirs have inode flags, the devices and fifos aren't opened
+				if !fi.Mode().IsRegular() && !fi.IsDir() {
+					return nil
+				}
+				flags, err := util.GetFileAttr(path)
+				// the filesystem keeps no inode flags, ex: tmpfs
+				if fi.IsDir() && (errors.Is(err, syscall.ENOTTY) || errors.Is(err, syscall.EOPNOTSUPP)) {
+					return filepath.SkipDir
+				}
+				if err != nil || flags&util.KeptAttrs == 0 {
+					return nil
+				}
+				attrs[rel] = flags & util.KeptAttrs
+				return nil
+			})
+		}
+	}
+	logger.Debugf("the files with inode flags of %s: %v", version, attrs)
+	err := c.conf.SetVersionAttrs(version, c.rootMP, attrs)
+	if err != nil {
+		logger.Warning("failed save the inode flags, err:", err)
+	}
+}
+
+// restoreAttrs sets the inode flags of the version on the rolled back files, the filtered files keep their own
+func (c *Upgrader) restoreAttrs(version string) {
+	attrs := c.conf.LoadVersionAttrs(version, c.rootMP)
+	for path, flags := range attrs {
+		for _, repoConf := range c.conf.RepoList {
+			subscribeList := selectRollbackPaths(repoConf.SubscribeList, c.rollbackPaths)
+			if !isPathCovered(path, subscribeList) || isPathCovered(path, nonEmptyPaths(repoConf.FilterList)) {
+				continue
+			}
+			err := util.AddFileAttr(filepath.Join(c.rootMP, path), flags)
+			if err != nil {
+				logger.Warningf("failed restore the inode flags of %s, err: %v", path, err)
+			}
+			break
+		}
+	}
+}
+
+// lockedEntry is the file whose immutable or append-only flags are cleared to be moved
+type lockedEntry struct {
+	path  string
+	inode uint64
+	flags int32
+}
+
+// unlockEntries clears the immutable and append-only flags of the dir and its entries, they can't be moved
+// or removed otherwise. The flags cleared are returned to set back on the entries kept in place
+func unlockEntries(dir string) []*lockedEntry {
+	var list []*lockedEntry
+	paths := []string{dir}
+	fiList, err := ioutil.ReadDir(dir)
+	if err == nil {
+		for _, fi := range fiList {
+			if fi.Mode().IsRegular() || fi.IsDir() {
+				paths = append(paths, filepath.Join(dir, fi.Name()))
+			}
+		}
+	}
+	for _, path := range paths {
+		inode, err := fileInode(path)
+		if err != nil {
+			continue
+		}
+		flags, err := util.UnsetFileAttr(path, util.LockAttrs)
+		if err != nil || flags == 0 {
+			continue
+		}
+		logger.Debugf("clear the inode flags %#x of %s", flags, path)
+		list = append(list, &lockedEntry{path: path, inode: inode, flags: flags})
+	}
+	return list
+}
+
+// relockEntries sets the flags cleared back on the entries still in place, the replaced ones are gone
+func relockEntries(list []*lockedEntry) {
+	for _, v := range list {
+		inode, err := fileInode(v.path)
+		if err != nil || inode != v.inode {
+			continue
+		}
+		err = util.AddFileAttr(v.path, v.flags)
+		if err != nil {
+			logger.Warningf("failed set back the inode flags of %s, err: %v", v.path, err)
+		}
+	}
+}
+
+func fileInode(path string) (uint64, error) {
+	var st syscall.Stat_t
+	err := syscall.Lstat(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+func nonEmptyPaths(list []string) []string {
+	var paths []string
+	for _, v := range list {
+		if len(v) != 0 {
+			paths = append(paths, v)
+		}
+	}
+	return paths
+}
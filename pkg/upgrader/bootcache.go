@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	bootkitconfig "deepin-upgrade-manager/pkg/config/bootkit"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/bootkitinfo"
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// the commit checksums the boot files of the version are extracted from
+	bootChecksumFile = ".checksum"
+	// the os-version of the version, the titles of the rollback entries read it
+	bootOSInfoFile = ".os-version"
+)
+
+func isBootFileName(name string) bool {
+	return isKernelName(name) || strings.HasPrefix(name, "initrd.img-")
+}
+
+func (c *Upgrader) bootSnapshotDir(version string) string {
+	return filepath.Join(c.rootMP, bootkitinfo.BOOT_SNAPSHOT_DIR, version)
+}
+
+// versionChecksum returns the commit checksums of the version in all repos
+func (c *Upgrader) versionChecksum(version string) (string, error) {
+	var sums []string
+	for _, v := range c.versionRepos(version) {
+		sum, err := c.repoSet[v.Repo].Checksum(version)
+		if err != nil {
+			return "", err
+		}
+		sums = append(sums, sum)
+	}
+	return strings.Join(sums, "\n") + "\n", nil
+}
+
+// EnableBoot extracts the kernels, initrds and os-version of the version into /boot/snapshot without
+// checking out the version, the extracted files are reused until the version is committed again
+func (c *Upgrader) EnableBoot(version string) (stateType, error) {
+	exitCode := _STATE_TY_SUCCESS
+	checksum, err := c.versionChecksum(version)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_NO_REPO
+		return exitCode, err
+	}
+	dstDir := c.bootSnapshotDir(version)
+	data, err := ioutil.ReadFile(filepath.Join(dstDir, bootChecksumFile))
+	if err == nil && string(data) == checksum {
+		logger.Debugf("the boot files of %s are up to date", version)
+		return exitCode, nil
+	}
+	tmpDir := filepath.Join(filepath.Dir(dstDir), "."+version+"-"+util.MakeRandomString(util.MinRandomLen))
+	err = os.MkdirAll(tmpDir, 0700)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_NO_REPO
+		return exitCode, err
+	}
+	defer os.RemoveAll(tmpDir)
+	found, err := c.extractBootFiles(version, tmpDir)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_NO_REPO
+		return exitCode, err
+	}
+	_ = os.RemoveAll(dstDir)
+	// the version without kernels has no rollback entries
+	if !found {
+		logger.Warningf("no kernel found in %s", version)
+		return exitCode, nil
+	}
+	err = ioutil.WriteFile(filepath.Join(tmpDir, bootChecksumFile), []byte(checksum), 0600)
+	if err == nil {
+		err = os.Rename(tmpDir, dstDir)
+	}
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_NO_REPO
+		return exitCode, err
+	}
+	return exitCode, nil
+}
+
+// extractBootFiles extracts the boot files of the version into the dir, the files same as the
+// system's are hardlinked. Returns whether any kernel is found
+func (c *Upgrader) extractBootFiles(version, dir string) (bool, error) {
+	found := false
+	var releases []string
+	for _, v := range c.versionRepos(version) {
+		handler := c.repoSet[v.Repo]
+		if !util.IsExists(filepath.Join(dir, bootOSInfoFile)) {
+			content, err := handler.Read(version, util.OSInfoPath)
+			if err == nil && len(content) != 0 {
+				err = ioutil.WriteFile(filepath.Join(dir, bootOSInfoFile), content, 0600)
+				if err != nil {
+					return false, err
+				}
+			}
+		}
+		if found {
+			continue
+		}
+		// the repo doesn't commit /boot
+		infos, err := handler.Ls(version, "/boot", true)
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			name := filepath.Base(info.Path)
+			if filepath.Dir(info.Path) != "/boot" || !info.IsRegular() || !isBootFileName(name) {
+				continue
+			}
+			err = c.extractBootFile(v.Repo, version, info.Path, dir)
+			if err != nil {
+				return false, err
+			}
+			if isKernelName(name) {
+				found = true
+				releases = append(releases, strings.SplitN(name, "-", 2)[1])
+			}
+		}
+	}
+	if !found {
+		return found, nil
+	}
+	return found, c.extractDTBDirs(version, releases, dir)
+}
+
+// extractDTBDirs extracts the devicetree dirs of the kernels into the dir, the rollback entries
+// of U-Boot load the devicetree of the version from it instead of the dir of the system
+func (c *Upgrader) extractDTBDirs(version string, releases []string, dir string) error {
+	for _, release := range releases {
+		path := bootkitconfig.KernelDTBDirPrefix + release
+		dstDir := filepath.Join(dir, bootkitconfig.SnapshotDTBDir, filepath.Base(path))
+		for _, v := range c.versionRepos(version) {
+			// the kernel installs no devicetree, ex: x86
+			infos, err := c.repoSet[v.Repo].Ls(version, path, false)
+			if err != nil || len(infos) == 0 || !infos[0].IsDir() {
+				continue
+			}
+			workDir := filepath.Join(dir, ".extract")
+			srcDir, err := c.checkoutPath(v.Repo, version, path, workDir)
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(dstDir), 0755)
+			}
+			if err == nil {
+				err = os.Rename(srcDir, dstDir)
+			}
+			_ = os.RemoveAll(workDir)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (c *Upgrader) extractBootFile(repoDir, version, path, dir string) error {
+	workDir := filepath.Join(dir, ".extract")
+	defer os.RemoveAll(workDir)
+	file, err := c.checkoutPath(repoDir, version, path, workDir)
+	if err != nil {
+		return err
+	}
+	dstFile := filepath.Join(dir, filepath.Base(path))
+	localFile := filepath.Join(c.rootMP, path)
+	isSame, err := util.IsFileSame(localFile, file)
+	if isSame && err == nil {
+		// create file hard link
+		return util.CopyFile(localFile, dstFile, true)
+	}
+	if !util.IsExists(file) {
+		return errors.New("failed extract " + path)
+	}
+	return os.Rename(file, dstFile)
+}
+
+// cleanupBootSnapshot removes the boot files of the versions not in the list
+func (c *Upgrader) cleanupBootSnapshot(list []string) {
+	bootSnapDir := filepath.Join(c.rootMP, bootkitinfo.BOOT_SNAPSHOT_DIR)
+	fiList, err := ioutil.ReadDir(bootSnapDir)
+	if err != nil {
+		return
+	}
+	for _, fi := range fiList {
+		if util.IsItemInList(fi.Name(), list) {
+			continue
+		}
+		logger.Debug("delete kernel snapshot directory:", fi.Name())
+		_ = os.RemoveAll(filepath.Join(bootSnapDir, fi.Name()))
+	}
+}
+
+// versionOSInfo returns the value of the key in the os-version of the version, the system's if not extracted
+func (c *Upgrader) versionOSInfo(version, key string) (string, error) {
+	infoPath := filepath.Join(c.bootSnapshotDir(version), bootOSInfoFile)
+	if !util.IsExists(infoPath) {
+		infoPath = filepath.Join(c.rootMP, util.OSInfoPath)
+	}
+	return util.GetOSInfoFromFile(infoPath, key)
+}
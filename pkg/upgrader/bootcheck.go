@@ -0,0 +1,276 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/bootkit"
+	bootkitconfig "deepin-upgrade-manager/pkg/config/bootkit"
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/bootkitinfo"
+	"deepin-upgrade-manager/pkg/module/chroot"
+	"deepin-upgrade-manager/pkg/module/grub"
+	"deepin-upgrade-manager/pkg/module/repo/tree"
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MarkGoodServiceName = "deepin-upgrade-manager-mark-good.service"
+	// exists once the current boot is marked as good, cleared on reboot
+	BootGoodRunPath = "/run/deepin-upgrade-manager/boot-good"
+
+	markGoodCheckInterval = 5 * time.Second
+	markGoodTimeout       = 10 * time.Minute
+)
+
+// SetAutoRollback notes the rollback was selected by grub after repeated failed boots
+func (c *Upgrader) SetAutoRollback(auto bool) {
+	c.autoRollback = auto
+}
+
+// ArmBootCounter saves the boot tries to grubenv, grub rolls back to the version
+// after the tries are used up without 'mark-good' clearing the counter. The boots
+// of the entries are counted by systemd-boot itself
+func (c *Upgrader) ArmBootCounter(version string) error {
+	bootCheck := c.conf.BootCheckConfig()
+	if bootCheck.MaxBootTries <= 0 {
+		return c.DisarmBootCounter()
+	}
+	// nobody would clear the counter, each good boot would be counted as failure
+	err := exec.Command("systemctl", "is-enabled", "--quiet", MarkGoodServiceName).Run()
+	if err != nil {
+		logger.Warningf("%s is not enabled, skip arming the boot counter", MarkGoodServiceName)
+		return nil
+	}
+	tries := bootCheck.MaxBootTries
+	if bootkitinfo.Bootloader() == bootkitconfig.BootloaderSystemdBoot {
+		logger.Infof("arm the boot counter of the entries, tries: %d, rollback version: %s", tries, version)
+		return bootkit.ArmBLSCounter(filepath.Join(c.rootMP, bootkitinfo.ESPPath()), c.rootMP, version, tries)
+	}
+	if tries > grub.MaxBootTries {
+		tries = grub.MaxBootTries
+	}
+	env, err := grub.LoadGrubEnv(c.rootMP)
+	if err != nil {
+		return err
+	}
+	env.Set(grub.BootTriesEnv, strconv.Itoa(tries))
+	env.Set(grub.RollbackVersionEnv, version)
+	logger.Infof("arm the boot counter, tries: %d, rollback version: %s", tries, version)
+	return env.Save()
+}
+
+func (c *Upgrader) DisarmBootCounter() error {
+	if bootkitinfo.Bootloader() == bootkitconfig.BootloaderSystemdBoot {
+		// the esp isn't mounted in initramfs, disarmed by 'mark-good' after booting
+		espDir := filepath.Join(c.rootMP, bootkitinfo.ESPPath())
+		if len(bootkit.BLSArmedVersion(espDir)) == 0 {
+			return nil
+		}
+		logger.Info("disarm the boot counter of the entries")
+		return bootkit.DisarmBLSCounter(espDir, c.rootMP)
+	}
+	env, err := grub.LoadGrubEnv(c.rootMP)
+	if err != nil {
+		return err
+	}
+	if len(env.Get(grub.BootTriesEnv)) == 0 && len(env.Get(grub.RollbackVersionEnv)) == 0 {
+		return nil
+	}
+	env.Unset(grub.BootTriesEnv, grub.RollbackVersionEnv)
+	logger.Info("disarm the boot counter")
+	return env.Save()
+}
+
+func (c *Upgrader) isBootCounterArmed(version string) bool {
+	var armed string
+	if bootkitinfo.Bootloader() == bootkitconfig.BootloaderSystemdBoot {
+		armed = bootkit.BLSArmedVersion(filepath.Join(c.rootMP, bootkitinfo.ESPPath()))
+	} else {
+		env, err := grub.LoadGrubEnv(c.rootMP)
+		if err != nil {
+			return false
+		}
+		armed = env.Get(grub.RollbackVersionEnv)
+	}
+	return len(armed) != 0 && (len(version) == 0 || armed == version)
+}
+
+// MarkGood records the active version booted successfully and clears the boot counter
+func (c *Upgrader) MarkGood() (int, error) {
+	exitCode := _STATE_TY_SUCCESS
+	version := c.conf.ActiveVersion
+	// the boot of the version tried says nothing about the active version
+	if len(TrialVersion()) != 0 {
+		logger.Info("skip marking the trial boot of", TrialVersion())
+		return int(exitCode), nil
+	}
+	err := c.checkBoot(c.conf.BootCheckConfig().GoodTarget, c.conf.BootCheckConfig().HealthCheck)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_BOOT_CHECK
+		return int(exitCode), err
+	}
+	// the boot info is of the entries of the version only if booting as them, the counter is cleared anyway
+	if len(version) != 0 && c.IsExistVersion(version) {
+		info := currentBootInfo()
+		if c.isSameBoot(version, info) {
+			err = c.conf.SetVersionBoot(version, c.rootMP, info)
+			if err != nil {
+				logger.Warning("failed save the boot info, err:", err)
+			}
+		} else {
+			logger.Infof("the boot of %s differs from the current boot, leave it unconfirmed", version)
+		}
+	}
+	err = os.MkdirAll(filepath.Dir(BootGoodRunPath), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(BootGoodRunPath, []byte(version), 0644)
+	}
+	if err != nil {
+		logger.Warning("failed save the boot state, err:", err)
+	}
+	if c.isBootCounterArmed("") {
+		err = c.DisarmBootCounter()
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_BOOT_CHECK
+			return int(exitCode), err
+		}
+	}
+	logger.Infof("the current boot of %s is marked as good", version)
+	return int(exitCode), nil
+}
+
+// inheritBootGood marks the version committed in a good boot as good, the version is the snapshot
+// of the system which has booted successfully. A version booting another kernel, initrd or kernel
+// arguments has never booted, it is left unconfirmed
+func (c *Upgrader) inheritBootGood(version string) {
+	if !util.IsExists(BootGoodRunPath) || chroot.IsEnv() {
+		return
+	}
+	info := currentBootInfo()
+	if !c.isSameBoot(version, info) {
+		logger.Infof("the boot of %s differs from the current boot, leave it unconfirmed", version)
+		return
+	}
+	err := c.conf.SetVersionBoot(version, c.rootMP, info)
+	if err != nil {
+		logger.Warning("failed save the boot info, err:", err)
+	}
+}
+
+// isSameBoot returns whether the rollback entries of the version boot as the current boot: the newest
+// kernel of the version is running, its initrd isn't regenerated since booting and no kernel argument differs
+func (c *Upgrader) isSameBoot(version string, boot *config.BootInfo) bool {
+	var kernels []string
+	var initrd *tree.FileInfo
+	for _, v := range c.versionRepos(version) {
+		infos, err := c.repoSet[v.Repo].Ls(version, "/boot", true)
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			name := filepath.Base(info.Path)
+			if filepath.Dir(info.Path) != "/boot" || !info.IsRegular() {
+				continue
+			}
+			if isKernelName(name) {
+				kernels = append(kernels, name)
+			} else if name == "initrd.img-"+boot.Kernel {
+				initrd = info
+			}
+		}
+		if len(kernels) != 0 {
+			break
+		}
+	}
+	if len(kernels) == 0 || initrd == nil || len(boot.Kernel) == 0 {
+		return false
+	}
+	var infoList bootkitinfo.BootInfoList
+	if kernel := infoList.VmlinuxName(kernels); strings.SplitN(kernel, "-", 2)[1] != boot.Kernel {
+		return false
+	}
+	fi, err := os.Stat(filepath.Join(c.rootMP, "/boot", filepath.Base(initrd.Path)))
+	if err != nil || fi.Size() != initrd.Size {
+		return false
+	}
+	bootTime := time.Unix(boot.Time, 0).Add(-time.Duration(boot.Uptime * float64(time.Second)))
+	if fi.ModTime().After(bootTime) {
+		return false
+	}
+	add, remove := c.cmdlineOverrides(version, bootCmdline())
+	return len(add) == 0 && len(remove) == 0
+}
+
+// GoodVersion returns the newest version known to boot successfully, prefer the version given
+func (c *Upgrader) GoodVersion(prefer string) string {
+	if len(prefer) != 0 && c.conf.IsVersionGood(prefer, c.rootMP) {
+		return prefer
+	}
+	list, _, err := c.ListVersion()
+	if err != nil {
+		return ""
+	}
+	for _, v := range list {
+		if c.conf.IsVersionGood(v, c.rootMP) {
+			return v
+		}
+	}
+	return ""
+}
+
+func (c *Upgrader) BootInfo(version string) *config.BootInfo {
+	return c.conf.LoadVersionBoot(version, c.rootMP)
+}
+
+func currentBootInfo() *config.BootInfo {
+	info := &config.BootInfo{
+		Good: true,
+		Time: time.Now().Unix(),
+	}
+	kernel, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err == nil {
+		info.Kernel = strings.TrimSpace(string(kernel))
+	}
+	uptime, err := ioutil.ReadFile("/proc/uptime")
+	if err == nil && len(strings.Fields(string(uptime))) != 0 {
+		info.Uptime, _ = strconv.ParseFloat(strings.Fields(string(uptime))[0], 64)
+	}
+	return info
+}
+
+func (c *Upgrader) checkBoot(target, healthCheck string) error {
+	if len(healthCheck) != 0 {
+		context := strings.Fields(healthCheck)
+		logger.Infof("run the health check: %s", healthCheck)
+		out, err := exec.Command(context[0], context[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("health check failed: %v, %s", err, string(out))
+		}
+		return nil
+	}
+	if len(target) == 0 {
+		return errors.New("neither good target nor health check is configured")
+	}
+	logger.Infof("wait for the %s to be reached", target)
+	deadline := time.Now().Add(markGoodTimeout)
+	for time.Now().Before(deadline) {
+		err := exec.Command("systemctl", "is-active", "--quiet", target).Run()
+		if err == nil {
+			return nil
+		}
+		time.Sleep(markGoodCheckInterval)
+	}
+	return fmt.Errorf("the %s is not reached in %v", target, markGoodTimeout)
+}
@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/chroot"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// the arguments of the boot itself, the rollback entries set them
+var cmdlineIgnored = []string{"BOOT_IMAGE=", "initrd=", "root=", "back_version=", "back_scheme=",
+	"backup_uuid=", "back_auto=", "back_try="}
+
+// the arguments choosing what runs as init or dropping to a shell, only root sets them
+var cmdlineInitArgs = []string{"init", "rdinit", "systemd.unit", "rd.systemd.unit", "rd.break", "rd.shell",
+	"rd.emergency", "emergency", "rescue", "single"}
+
+// bootCmdline returns the kernel arguments the system booted with
+func bootCmdline() []string {
+	content, err := ioutil.ReadFile("/proc/cmdline")
+	if err != nil {
+		return nil
+	}
+	var args []string
+	for _, v := range strings.Fields(string(content)) {
+		isIgnored := v == "ro" || v == "rw"
+		for _, prefix := range cmdlineIgnored {
+			if strings.HasPrefix(v, prefix) {
+				isIgnored = true
+				break
+			}
+		}
+		if !isIgnored {
+			args = append(args, v)
+		}
+	}
+	return args
+}
+
+// captureCmdline saves the kernel arguments of the running system as the arguments of the version
+func (c *Upgrader) captureCmdline(version string) {
+	// the arguments of initramfs or the host aren't the version's
+	if len(c.rootMP) != 1 || chroot.IsEnv() {
+		return
+	}
+	info := c.conf.LoadVersionCmdline(version, c.rootMP)
+	info.Captured = bootCmdline()
+	err := c.conf.SetVersionCmdline(version, c.rootMP, info)
+	if err != nil {
+		logger.Warning("failed save the kernel arguments, err:", err)
+	}
+}
+
+// Cmdline returns the kernel arguments of the version
+func (c *Upgrader) Cmdline(version string) *config.CmdlineInfo {
+	return c.conf.LoadVersionCmdline(version, c.rootMP)
+}
+
+// SetCmdline replaces the arguments added to or removed from the rollback entries of the version,
+// an argument without value removes the argument whatever its value
+func (c *Upgrader) SetCmdline(version string, add, remove []string) (int, error) {
+	exitCode := _STATE_TY_SUCCESS
+	var err error
+	var info *config.CmdlineInfo
+	if len(version) == 0 || !c.IsExistVersion(version) {
+		exitCode = _STATE_TY_FAILED_NO_VERSION
+		err = errors.New("version does not exist")
+		goto failure
+	}
+	info = c.conf.LoadVersionCmdline(version, c.rootMP)
+	info.Add, info.Remove = splitArgs(add), splitArgs(remove)
+	err = c.conf.SetVersionCmdline(version, c.rootMP, info)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_UPDATE_GRUB
+		goto failure
+	}
+	logger.Infof("the kernel arguments of %s, add: %v, remove: %v", version, info.Add, info.Remove)
+	exitCode, err = c.UpdateGrub()
+	if err != nil {
+		goto failure
+	}
+	return int(exitCode), nil
+failure:
+	return int(exitCode), err
+}
+
+// cmdlineOverrides returns the arguments the rollback entries of the version add to and remove from
+// the arguments of the current system. The version boots with the arguments captured when committed,
+// then the arguments edited apply
+func (c *Upgrader) cmdlineOverrides(version string, current []string) ([]string, []string) {
+	info := c.conf.LoadVersionCmdline(version, c.rootMP)
+	var add, remove []string
+	if len(info.Captured) != 0 {
+		add = argsDiff(info.Captured, current)
+		remove = argsDiff(current, info.Captured)
+	}
+	add = append(argsDiff(add, info.Remove), info.Add...)
+	remove = append(argsDiff(remove, info.Add), info.Remove...)
+	return add, remove
+}
+
+// argsDiff returns the arguments of a not in b
+func argsDiff(a, b []string) []string {
+	var list []string
+	for _, v := range a {
+		isExist := false
+		for _, arg := range b {
+			if v == arg {
+				isExist = true
+				break
+			}
+		}
+		if !isExist {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func splitArgs(list []string) []string {
+	var args []string
+	for _, v := range list {
+		args = append(args, strings.Fields(v)...)
+	}
+	return args
+}
+
+// HasInitArgs reports whether the arguments choose what runs as init or drop to a shell
+func HasInitArgs(args []string) bool {
+	for _, v := range splitArgs(args) {
+		key := strings.SplitN(v, "=", 2)[0]
+		for _, arg := range cmdlineInitArgs {
+			if key == arg {
+				return true
+			}
+		}
+	}
+	return false
+}
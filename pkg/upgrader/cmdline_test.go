@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import "testing"
+
+func TestHasInitArgs(t *testing.T) {
+	var cases = []struct {
+		args []string
+		want bool
+	}{
+		{args: nil, want: false},
+		{args: []string{"nomodeset", "quiet splash"}, want: false},
+		{args: []string{"initcall_debug", "rdinitfoo=1"}, want: false},
+		{args: []string{"init=/bin/sh"}, want: true},
+		{args: []string{"quiet rdinit=/bin/sh"}, want: true},
+		{args: []string{"systemd.unit=emergency.target"}, want: true},
+		{args: []string{"single"}, want: true},
+	}
+	for _, v := range cases {
+		if got := HasInitArgs(v.args); got != v.want {
+			t.Errorf("%v: except %v, but got %v", v.args, v.want, got)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/dpkg/md5sums"
+	"deepin-upgrade-manager/pkg/module/records"
+	"deepin-upgrade-manager/pkg/module/repo"
+	"deepin-upgrade-manager/pkg/module/repo/tree"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DriftAdded    = "added"
+	DriftModified = "modified"
+	DriftDeleted  = "deleted"
+)
+
+// DriftEntry is the path changed since the version, the files of the packages are checked against the dpkg md5sums
+type DriftEntry struct {
+	Path        string `json:"path"`
+	Change      string `json:"change"`
+	IsDir       bool   `json:"is_dir,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+	Package     string `json:"package,omitempty"`
+	OutsideDpkg bool   `json:"outside_dpkg"`
+	Missing     bool   `json:"missing,omitempty"`
+}
+
+type DriftReport struct {
+	Version  string        `json:"version"`
+	Added    int           `json:"added"`
+	Modified int           `json:"modified"`
+	Deleted  int           `json:"deleted"`
+	Entries  []*DriftEntry `json:"entries"`
+}
+
+func (report DriftReport) ToJson() string {
+	b, _ := json.MarshalIndent(&report, "", "  ")
+	return string(b)
+}
+
+// String returns the report like 'git status --short', the files changed outside dpkg are marked with '!'
+// and the files of the packages missing with '?', ex: 'M! /usr/bin/ls (coreutils)'
+func (report DriftReport) String() string {
+	var buf bytes.Buffer
+	for _, v := range report.Entries {
+		mark := ' '
+		if v.OutsideDpkg {
+			mark = '!'
+		} else if v.Missing {
+			mark = '?'
+		}
+		path := v.Path
+		if v.IsDir {
+			path += "/"
+		}
+		fmt.Fprintf(&buf, "%c%c %s", strings.ToUpper(v.Change)[0], mark, path)
+		if len(v.Package) != 0 {
+			fmt.Fprintf(&buf, " (%s)", v.Package)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// Drift reports the subscribed paths changed on the live system since the active version, the filtered
+// paths are skipped. The regular files of the same metadata changed after the commit are compared by content
+func (c *Upgrader) Drift() (*DriftReport, int, error) {
+	version := c.conf.ActiveVersion
+	if len(version) == 0 || !c.IsExistVersion(version) {
+		return nil, int(_STATE_TY_FAILED_NO_VERSION), errors.New("active version does not exist")
+	}
+	report := &DriftReport{Version: version}
+	for _, repoConf := range c.versionRepos(version) {
+		report.Entries = append(report.Entries, c.driftEntries(repoConf, version)...)
+	}
+	sort.Slice(report.Entries, func(i, j int) bool {
+		return report.Entries[i].Path < report.Entries[j].Path
+	})
+	sums, err := md5sums.Load(filepath.Join(c.rootMP, md5sums.InfoDir))
+	if err != nil {
+		logger.Warning("failed load the dpkg md5sums, err:", err)
+	}
+	for _, v := range report.Entries {
+		switch v.Change {
+		case DriftAdded:
+			report.Added++
+		case DriftModified:
+			report.Modified++
+		case DriftDeleted:
+			report.Deleted++
+		}
+		if !v.IsDir {
+			c.checkDpkgSum(v, sums)
+		}
+	}
+	return report, int(_STATE_TY_SUCCESS), nil
+}
+
+func (c *Upgrader) driftEntries(repoConf *config.RepoConfig, version string) []*DriftEntry {
+	handler := c.repoSet[repoConf.Repo]
+	skipList := []string{SelfRecordStatePath, records.SelfRecordResultPath, records.SelfRecordMismatchPath,
+		records.SelfRecordConflictPath, c.conf.CacheDir, repoConf.Repo, repoConf.ConfigDir, repoConf.StageDir, repoConf.SnapshotDir}
+	filterList := nonEmptyPaths(repoConf.FilterList)
+	filterList = append(filterList, c.getFilterList(c.fsInfo, filterList, repoConf.SubscribeList)...)
+	skipList = append(nonEmptyPaths(skipList), filterList...)
+	isSkipped := func(path string) bool {
+		name := filepath.Base(path)
+		return name == "."+version || name == ".old"+version || isPathCovered(path, skipList)
+	}
+	compare := compareContent(handler, version, c.rootMP)
+	var list []*DriftEntry
+	for _, v := range c.diffLocalPaths(repoConf, version, repoConf.SubscribeList, isSkipped, compare) {
+		entry := &DriftEntry{Path: v.path, IsDir: v.isDir, Reason: v.reason}
+		switch v.kind {
+		case changeAdded:
+			entry.Change = DriftAdded
+		case changeDeleted:
+			entry.Change = DriftDeleted
+		default:
+			entry.Change = DriftModified
+		}
+		list = append(list, entry)
+	}
+	return list
+}
+
+// compareContent returns the compare of the local files with the version, the regular files of the same metadata
+// changed after the commit are compared by content with the objects of the checksums listed
+func compareContent(handler repo.Repository, version, rootDir string) func(string, os.FileInfo, *tree.FileInfo) string {
+	var commitTime time.Time
+	if s, err := handler.CommitTime(version); err == nil {
+		commitTime, _ = time.ParseInLocation("2006-01-02 15:04:05", s, time.Local)
+	}
+	return func(path string, fi os.FileInfo, info *tree.FileInfo) string {
+		reason := compareFileInfo(path, fi, info)
+		if len(reason) != 0 || !info.IsRegular() || !isChangedSince(fi, commitTime) {
+			return reason
+		}
+		object, err := handler.OpenObject(info.Checksum)
+		if err != nil {
+			logger.Warningf("failed open %s of %s, err: %v", path, version, err)
+			return ""
+		}
+		expected, err := readerSum(object)
+		_ = object.Close()
+		if err != nil {
+			logger.Warningf("failed read %s of %s, err: %v", path, version, err)
+			return ""
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err.Error()
+		}
+		sum, err := readerSum(file)
+		_ = file.Close()
+		if err != nil {
+			return err.Error()
+		}
+		if sum != expected {
+			return "content differs"
+		}
+		return ""
+	}
+}
+
+// readerSum returns the sha256 of the content read
+func readerSum(r io.Reader) (string, error) {
+	h := sha256.New()
+	_, err := io.Copy(h, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkDpkgSum marks the file changed outside dpkg if it's a file of a package and differs from the md5sums,
+// the conffiles are not in the md5sums
+func (c *Upgrader) checkDpkgSum(entry *DriftEntry, sums md5sums.FileSumMap) {
+	sum := sums.Match(entry.Path)
+	if sum == nil {
+		return
+	}
+	entry.Package = sum.Package
+	// removed rather than changed outside dpkg
+	if entry.Change == DriftDeleted {
+		entry.Missing = true
+		return
+	}
+	filename := filepath.Join(c.rootMP, entry.Path)
+	fi, err := os.Lstat(filename)
+	if err != nil {
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		entry.OutsideDpkg = true
+		return
+	}
+	md5, err := md5sums.FileMD5(filename)
+	if err != nil {
+		logger.Warningf("failed get the md5 of %s, err: %v", filename, err)
+		return
+	}
+	entry.OutsideDpkg = md5 != sum.MD5
+}
+
+// isChangedSince returns whether the inode changed after the time, the content may differ with the same size
+func isChangedSince(fi os.FileInfo, t time.Time) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || t.IsZero() {
+		return true
+	}
+	return time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec)).After(t)
+}
@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/chroot"
+	"deepin-upgrade-manager/pkg/module/dirinfo"
+	"deepin-upgrade-manager/pkg/module/fstabinfo"
+	"deepin-upgrade-manager/pkg/module/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	DryRunCommit   = "commit"
+	DryRunRollback = "rollback"
+)
+
+type CommitPlan struct {
+	Repo          string   `json:"repo"`
+	SubscribeList []string `json:"subscribe_list"`
+	FilterList    []string `json:"filter_list"`
+	CacheDir      string   `json:"cache_dir"`
+	NeedSize      int64    `json:"need_size"`
+	FreeSize      uint64   `json:"free_size"`
+	IsEnough      bool     `json:"is_enough"`
+}
+
+type MountPlan struct {
+	Src    string `json:"src"`
+	Dest   string `json:"dest"`
+	FSType string `json:"fs_type"`
+	Bind   bool   `json:"bind"`
+}
+
+type RollbackPlan struct {
+	Repo           string       `json:"repo"`
+	ReplaceDirs    []string     `json:"replace_dirs"`
+	ReplaceFiles   []string     `json:"replace_files"`
+	RemoveList     []string     `json:"remove_list"`
+	FilterMounts   []string     `json:"filter_mounts"`
+	UmountList     []*MountPlan `json:"umount_list"`
+	MountList      []*MountPlan `json:"mount_list"`
+	PreserveDirs   []string     `json:"preserve_dirs"`
+	PreserveFiles  []string     `json:"preserve_files"`
+	CurrentKernels []string     `json:"current_kernels"`
+	TargetKernels  []string     `json:"target_kernels"`
+	KernelChanged  bool         `json:"kernel_changed"`
+}
+
+type DryRunReport struct {
+	Action   string          `json:"action"`
+	Version  string          `json:"version"`
+	Commit   []*CommitPlan   `json:"commit,omitempty"`
+	Rollback []*RollbackPlan `json:"rollback,omitempty"`
+}
+
+func (report DryRunReport) ToJson() string {
+	b, _ := json.MarshalIndent(&report, "", "  ")
+	return string(b)
+}
+
+// DryRun reports what commit or rollback would do, nothing is written
+func (c *Upgrader) DryRun(action, version string) (*DryRunReport, int, error) {
+	switch action {
+	case DryRunCommit:
+		return c.commitDryRun()
+	case DryRunRollback:
+		return c.rollbackDryRun(version)
+	}
+	return nil, int(_STATE_TY_FAILED_INVALID_ARGS), fmt.Errorf("unknown dry run action %q, only commit and rollback are supported", action)
+}
+
+func (c *Upgrader) commitDryRun() (*DryRunReport, int, error) {
+	report := &DryRunReport{Action: DryRunCommit}
+	// the same data as LoadReadyData loads before commit
+	for _, repoConf := range c.conf.PreviewData(c.conf.DataPath()) {
+		var usrDir string
+		if chroot.IsEnv() {
+			usrDir = "/usr"
+		} else {
+			usrDir = c.getMostSpaceDir(c.rootMP, repoConf.SubscribeList)
+		}
+		var extraSize int64
+		for _, v := range repoConf.SubscribeList {
+			if repoConf.RepoMountPoint == v {
+				extraSize += dirinfo.GetDirSize(repoConf.Repo)
+				extraSize += dirinfo.GetDirSize(repoConf.SnapshotDir)
+				extraSize += dirinfo.GetDirSize(repoConf.StageDir)
+				break
+			}
+		}
+		needSize, free, err := c.dirSpaceSize(usrDir, c.rootMP, repoConf.SubscribeList, 0-extraSize, true)
+		if err != nil {
+			return report, int(_STATE_TY_FAILED_NO_SPACE), err
+		}
+		filterList := append([]string(nil), repoConf.FilterList...)
+		filterList = append(filterList, c.getFilterList(c.fsInfo, filterList, repoConf.SubscribeList)...)
+		report.Commit = append(report.Commit, &CommitPlan{
+			Repo:          repoConf.Repo,
+			SubscribeList: repoConf.SubscribeList,
+			FilterList:    util.RemoveSameItemInSlice(filterList),
+			CacheDir:      filepath.Join(usrDir, ".osrepo-cache"),
+			NeedSize:      needSize,
+			FreeSize:      free,
+			IsEnough:      needSize <= int64(free),
+		})
+	}
+	return report, int(_STATE_TY_SUCCESS), nil
+}
+
+func (c *Upgrader) rollbackDryRun(version string) (*DryRunReport, int, error) {
+	report := &DryRunReport{Action: DryRunRollback, Version: version}
+	if len(version) == 0 || !c.IsExistVersion(version) {
+		return report, int(_STATE_TY_FAILED_NO_VERSION), errors.New("version does not exist")
+	}
+	// the same data as LoadVersionData loads before rollback
+	for i, repoConf := range c.conf.PreviewData(c.conf.VersionDataPath(version, c.rootMP)) {
+		// the repo added after the version doesn't hold it
+		if i != 0 && !c.repoSet[repoConf.Repo].Exist(version) {
+			continue
+		}
+		plan, err := c.repoRollbackDryRun(repoConf, version)
+		if err != nil {
+			return report, int(_STATE_TY_FAILED_OSTREE_ROLLBACK), err
+		}
+		report.Rollback = append(report.Rollback, plan)
+	}
+	return report, int(_STATE_TY_SUCCESS), nil
+}
+
+func (c *Upgrader) repoRollbackDryRun(repoConf *config.RepoConfig, version string) (*RollbackPlan, error) {
+	handler := c.repoSet[repoConf.Repo]
+	plan := &RollbackPlan{Repo: repoConf.Repo}
+
+	// the rollback loads the fstab of the snapshot
+	fsInfo := c.fsInfo
+	content, err := handler.Read(version, "/etc/fstab")
+	if err == nil && len(content) != 0 {
+		fsInfo, err = fstabinfo.Parse(content, c.rootMP)
+		if err != nil {
+			return plan, err
+		}
+	}
+	fixups, err := c.planLoaclMount(fsInfo)
+	if err != nil {
+		return plan, err
+	}
+	for _, fixup := range fixups {
+		if fixup.stale != nil {
+			plan.UmountList = append(plan.UmountList, &MountPlan{
+				Src:    fixup.stale.Src,
+				Dest:   fixup.stale.Dest,
+				FSType: fixup.stale.FSType,
+			})
+		}
+		plan.MountList = append(plan.MountList, &MountPlan{
+			Src:    fixup.target.Src,
+			Dest:   fixup.target.Dest,
+			FSType: fixup.target.FSType,
+			Bind:   fixup.target.Bind,
+		})
+	}
+
+	filterList := append([]string(nil), repoConf.FilterList...)
+	filterList = append(filterList, c.getFilterList(fsInfo, filterList, repoConf.SubscribeList)...)
+	filterList = util.RemoveSameItemInSlice(filterList)
+	plan.FilterMounts = c.getFilterPartMountedList(fsInfo, filterList, repoConf.SubscribeList)
+
+	subscribeList := selectRollbackPaths(repoConf.SubscribeList, c.rollbackPaths)
+	var rootList []string
+	for _, v := range subscribeList {
+		rootList = append(rootList, filepath.Join(c.rootMP, v))
+	}
+	for _, v := range subscribeList {
+		dir := filepath.Join(c.rootMP, v)
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			real = dir
+		}
+		if util.IsRootSame(rootList, real) {
+			continue
+		}
+		infos, err := handler.Ls(version, v, false)
+		if err != nil || len(infos) == 0 {
+			plan.RemoveList = append(plan.RemoveList, v)
+			continue
+		}
+		if util.IsDir(real) || (!util.IsExists(real) && infos[0].IsDir()) {
+			plan.ReplaceDirs = append(plan.ReplaceDirs, v)
+			filterDirs, filterFiles := util.HandlerFilterList(c.rootMP, real, filterList)
+			plan.PreserveDirs = append(plan.PreserveDirs, filterDirs...)
+			plan.PreserveFiles = append(plan.PreserveFiles, filterFiles...)
+		} else {
+			plan.ReplaceFiles = append(plan.ReplaceFiles, v)
+		}
+	}
+
+	plan.CurrentKernels = listKernels(filepath.Join(c.rootMP, "/boot"))
+	bootInfos, err := handler.Ls(version, "/boot", true)
+	if err != nil {
+		logger.Warning("failed list the boot dir of the version, err:", err)
+	}
+	for _, v := range bootInfos {
+		if filepath.Dir(v.Path) != "/boot" || v.IsDir() {
+			continue
+		}
+		if isKernelName(filepath.Base(v.Path)) {
+			plan.TargetKernels = append(plan.TargetKernels, filepath.Base(v.Path))
+		}
+	}
+	sort.Strings(plan.TargetKernels)
+	plan.KernelChanged = strings.Join(plan.CurrentKernels, " ") != strings.Join(plan.TargetKernels, " ")
+	return plan, nil
+}
+
+func isKernelName(name string) bool {
+	return strings.HasPrefix(name, "vmlinuz-") ||
+		strings.HasPrefix(name, "kernel-") ||
+		strings.HasPrefix(name, "vmlinux-")
+}
+
+func listKernels(bootDir string) []string {
+	var list []string
+	fiList, err := ioutil.ReadDir(bootDir)
+	if err != nil {
+		return list
+	}
+	for _, fi := range fiList {
+		if fi.IsDir() {
+			continue
+		}
+		if isKernelName(fi.Name()) {
+			list = append(list, fi.Name())
+		}
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/bootkit"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// the contents of the EFI System Partition in the version, out of the subscribe list
+	ESPDataDir = "/.esp"
+
+	espTmpPrefix = ".deepin-rollback-"
+)
+
+// espMountPoint returns where the EFI System Partition is mounted in the system, empty if there is none
+func (c *Upgrader) espMountPoint() string {
+	if info := c.mountInfos.ESP(c.rootMP); info != nil {
+		return util.TrimRootdir(c.rootMP, info.MountPoint)
+	}
+	if info := c.fsInfo.ESP(); info != nil {
+		return info.DestPoint
+	}
+	return ""
+}
+
+// copyESPData copies the contents of the EFI System Partition to the data dir of the version,
+// the mount point alone would be committed if the partition isn't mounted
+func (c *Upgrader) copyESPData(dataDir string) error {
+	esp := c.espMountPoint()
+	if len(esp) == 0 || c.mountInfos.ESP(c.rootMP) == nil {
+		return errors.New("the EFI System Partition isn't mounted")
+	}
+	srcDir := filepath.Join(c.rootMP, esp)
+	logger.Infof("commit the EFI System Partition %s", srcDir)
+	// the kernels deepin-boot-kit copied belong to the versions, not the system
+	return copyTreeNoAttr(srcDir, filepath.Join(dataDir, ESPDataDir),
+		[]string{filepath.Join(srcDir, bootkit.BLSKernelDir)})
+}
+
+// espRestore is the top level entries of the EFI System Partition replaced by the version,
+// the old entries are kept in the temporary dir until the rollback is done
+type espRestore struct {
+	dir    string
+	tmpDir string
+	// the entries renamed into place, the old ones of them saved
+	entries []string
+	saved   map[string]bool
+}
+
+// restoreESP replaces the contents of the EFI System Partition by the version's. FAT has no hardlinks
+// or ownership, the contents are copied to a temporary dir on the partition, then the top level entries
+// are renamed into place one by one, the entries absent in the version are kept. The entries renamed are
+// renamed back on failure
+func (c *Upgrader) restoreESP(version string) (*espRestore, error) {
+	srcDir := filepath.Join(c.rootMP, c.conf.RepoList[0].SnapshotDir, version, ESPDataDir)
+	if !util.IsDir(srcDir) {
+		logger.Infof("the EFI System Partition isn't committed in %s", version)
+		return nil, nil
+	}
+	esp := c.espMountPoint()
+	if len(esp) == 0 || c.mountInfos.ESP(c.rootMP) == nil {
+		return nil, errors.New("the EFI System Partition isn't mounted")
+	}
+	dstDir := filepath.Join(c.rootMP, esp)
+	restore := &espRestore{
+		dir:    dstDir,
+		tmpDir: filepath.Join(dstDir, espTmpPrefix+util.MakeRandomString(util.MinRandomLen)),
+		saved:  make(map[string]bool),
+	}
+	err := copyTreeNoAttr(srcDir, restore.tmpDir, nil)
+	if err != nil {
+		_ = os.RemoveAll(restore.tmpDir)
+		return nil, err
+	}
+	syscall.Sync()
+	fiList, err := ioutil.ReadDir(restore.tmpDir)
+	if err != nil {
+		_ = os.RemoveAll(restore.tmpDir)
+		return nil, err
+	}
+	for _, fi := range fiList {
+		dstPath := filepath.Join(dstDir, fi.Name())
+		if util.IsExists(dstPath) {
+			err = os.Rename(dstPath, filepath.Join(restore.tmpDir, fi.Name()+".old"))
+			if err != nil {
+				break
+			}
+			restore.saved[fi.Name()] = true
+		}
+		err = os.Rename(filepath.Join(restore.tmpDir, fi.Name()), dstPath)
+		if err != nil {
+			if restore.saved[fi.Name()] {
+				_ = os.Rename(filepath.Join(restore.tmpDir, fi.Name()+".old"), dstPath)
+			}
+			break
+		}
+		restore.entries = append(restore.entries, fi.Name())
+		logger.Info("restore the EFI System Partition entry:", dstPath)
+	}
+	if err != nil {
+		if err1 := restore.revert(); err1 != nil {
+			logger.Error("failed revert the EFI System Partition, err:", err1)
+		}
+		return nil, err
+	}
+	syscall.Sync()
+	return restore, nil
+}
+
+// revert renames the old entries back in the reverse order, the entries of the version are removed
+func (r *espRestore) revert() error {
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		name := r.entries[i]
+		dstPath := filepath.Join(r.dir, name)
+		err := os.RemoveAll(dstPath)
+		if err == nil && r.saved[name] {
+			err = os.Rename(filepath.Join(r.tmpDir, name+".old"), dstPath)
+		}
+		if err != nil {
+			return err
+		}
+		logger.Info("revert the EFI System Partition entry:", dstPath)
+	}
+	r.entries = nil
+	syscall.Sync()
+	return os.RemoveAll(r.tmpDir)
+}
+
+// finish removes the old entries
+func (r *espRestore) finish() {
+	err := os.RemoveAll(r.tmpDir)
+	if err != nil {
+		logger.Warning("failed remove the old EFI System Partition entries, err:", err)
+	}
+}
+
+// copyTreeNoAttr copies the dir without the ownership and modes, which FAT doesn't keep
+func copyTreeNoAttr(src, dst string, filterDirs []string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// the leftovers of an interrupted restoring
+		if util.IsItemInList(path, filterDirs) || (fi.IsDir() && strings.HasPrefix(fi.Name(), espTmpPrefix)) {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(target, 0755)
+		case fi.Mode().IsRegular():
+			return bootkit.CopyFileNoAttr(path, target)
+		}
+		logger.Debug("ignore the special file:", path)
+		return nil
+	})
+}
@@ -0,0 +1,316 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"bytes"
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/dpkg/md5sums"
+	"deepin-upgrade-manager/pkg/module/dpkg/status"
+	"deepin-upgrade-manager/pkg/module/records"
+	"deepin-upgrade-manager/pkg/module/repo/tree"
+	"deepin-upgrade-manager/pkg/module/util"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	EtcDir = "/etc"
+	// the local file saved next to the file of the version kept
+	EtcMergeLocalSuffix = ".merge-local"
+	// the file of the version saved next to the local file kept, followed by the version
+	EtcMergeVersionSuffix = ".merge-"
+)
+
+// preparedEtcDir returns /etc in the prepared dir of the subscribed dir, empty if the dir doesn't hold /etc
+func preparedEtcDir(rootDir, dir, newDir string) string {
+	rel := filepath.Join("/", strings.TrimPrefix(dir, rootDir))
+	if !isSubPath(EtcDir, rel) {
+		return ""
+	}
+	return filepath.Join(newDir, strings.TrimPrefix(EtcDir, rel))
+}
+
+// mergeEtc merges the local changes of /etc since the active version into the prepared /etc of the version.
+// The changes to the paths the version leaves as the active version are kept. On the conflicts the package
+// files go to the version and the others to the local, the losing side is saved next to the file
+func (c *Upgrader) mergeEtc(repoConf *config.RepoConfig, snapDir, version, preparedDir string) {
+	base := c.conf.ActiveVersion
+	if base == version {
+		logger.Infof("rollback to the active version, the local changes of %s are discarded", EtcDir)
+		c.recordsInfo.SetEtcMerge(nil, nil)
+		return
+	}
+	if len(base) == 0 || !c.IsExistVersion(base) {
+		logger.Warningf("the active version %q does not exist, unable to merge %s", base, EtcDir)
+		return
+	}
+	handler := c.repoSet[repoConf.Repo]
+	baseInfos, err := handler.Ls(base, EtcDir, true)
+	if err != nil {
+		logger.Warningf("failed list %s of %s, err: %v", EtcDir, base, err)
+		return
+	}
+	targetInfos, err := handler.Ls(version, EtcDir, true)
+	if err != nil {
+		logger.Warningf("failed list %s of %s, err: %v", EtcDir, version, err)
+		return
+	}
+	baseMap, targetMap := baseInfos.Map(), targetInfos.Map()
+	skipList := append([]string{SelfRecordStatePath, records.SelfRecordResultPath, records.SelfRecordMismatchPath,
+		records.SelfRecordConflictPath}, nonEmptyPaths(repoConf.FilterList)...)
+	isSkipped := func(path string) bool {
+		name := filepath.Base(path)
+		return name == "."+version || name == ".old"+version || isPathCovered(path, skipList)
+	}
+	packageFiles := c.loadPackageFiles(snapDir)
+	changes := c.diffLocalPaths(repoConf, base, []string{EtcDir}, isSkipped, compareContent(handler, base, c.rootMP))
+	merged, conflicts := mergeEtcChanges(changes, c.rootMP, preparedDir, version, baseMap, targetMap, packageFiles)
+	logger.Infof("merged %d local changes of %s, %d conflicts", len(merged), EtcDir, len(conflicts))
+	c.recordsInfo.SetEtcMerge(merged, conflicts)
+}
+
+// mergeEtcChanges applies the local changes of /etc to the prepared /etc of the version, returns the paths
+// merged and the conflicts. The paths the version leaves as the base get the local changes
+func mergeEtcChanges(changes []*pathChange, rootDir, preparedDir, version string,
+	baseMap, targetMap map[string]*tree.FileInfo, packageFiles map[string]bool) ([]string, []string) {
+	var merged, conflicts []string
+	var err error
+	for _, v := range changes {
+		local := filepath.Join(rootDir, v.path)
+		dst := filepath.Join(preparedDir, strings.TrimPrefix(v.path, EtcDir))
+		// the mode or owner of the dir changed, its entries are compared apart
+		entryOnly := v.kind == changeModified && v.isDir && isDirEntry(baseMap[v.path]) && isDirEntry(targetMap[v.path])
+		if !isVersionChanged(v.path, baseMap, targetMap, entryOnly) {
+			err = applyLocalChange(local, dst, v.kind, entryOnly)
+			if err != nil {
+				logger.Warningf("failed merge the local %s, err: %v", v.path, err)
+				continue
+			}
+			merged = append(merged, v.path)
+			continue
+		}
+		if isSameLocal(local, dst, v.kind, entryOnly) {
+			continue
+		}
+		var kept, lost, saved string
+		if isPackageFile(v.path, packageFiles) {
+			kept, lost = "version", "local"
+			saved, err = keepVersionChange(local, dst, v.kind, entryOnly)
+		} else {
+			kept, lost = "local", "version"
+			saved, err = keepLocalChange(local, dst, version, v.kind, entryOnly)
+			if err == nil {
+				merged = append(merged, v.path)
+			}
+		}
+		if err != nil {
+			logger.Warningf("failed merge the local %s, err: %v", v.path, err)
+			continue
+		}
+		conflict := fmt.Sprintf("%s: the %s kept", v.path, kept)
+		if len(saved) != 0 {
+			merged = append(merged, v.path+saved)
+			conflict += fmt.Sprintf(", the %s saved as %s", lost, v.path+saved)
+		}
+		logger.Info("conflict of", conflict)
+		conflicts = append(conflicts, conflict)
+	}
+	return merged, conflicts
+}
+
+// isVersionChanged returns whether the version differs from the base on the path, only the entry if entryOnly
+func isVersionChanged(path string, baseMap, targetMap map[string]*tree.FileInfo, entryOnly bool) bool {
+	if entryOnly {
+		return !isSameEntry(baseMap[path], targetMap[path])
+	}
+	for _, mp := range []map[string]*tree.FileInfo{baseMap, targetMap} {
+		for p := range mp {
+			if isSubPath(p, path) && !isSameEntry(baseMap[p], targetMap[p]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isDirEntry(info *tree.FileInfo) bool {
+	return info != nil && info.IsDir()
+}
+
+// isSameEntry compares the files of the versions in the same repo, the dirs by mode and owner
+func isSameEntry(a, b *tree.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || a.Mode != b.Mode || a.Uid != b.Uid || a.Gid != b.Gid {
+		return false
+	}
+	return a.IsDir() || a.Checksum == b.Checksum
+}
+
+// isSameLocal returns whether the prepared file of the version is the same as the local change
+func isSameLocal(local, dst string, kind byte, entryOnly bool) bool {
+	dfi, err := os.Lstat(dst)
+	if kind == changeDeleted {
+		return os.IsNotExist(err)
+	}
+	if err != nil {
+		return false
+	}
+	lfi, err := os.Lstat(local)
+	if err != nil || lfi.Mode() != dfi.Mode() {
+		return false
+	}
+	ls, ok1 := lfi.Sys().(*syscall.Stat_t)
+	ds, ok2 := dfi.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 || ls.Uid != ds.Uid || ls.Gid != ds.Gid {
+		return false
+	}
+	switch {
+	case lfi.IsDir():
+		return entryOnly
+	case lfi.Mode()&os.ModeSymlink != 0:
+		lt, _ := os.Readlink(local)
+		dt, _ := os.Readlink(dst)
+		return lt == dt
+	case lfi.Mode().IsRegular():
+		if lfi.Size() != dfi.Size() {
+			return false
+		}
+		lc, err := ioutil.ReadFile(local)
+		if err != nil {
+			return false
+		}
+		dc, err := ioutil.ReadFile(dst)
+		return err == nil && bytes.Equal(lc, dc)
+	}
+	return false
+}
+
+// applyLocalChange replaces the prepared file by the local, the prepared files are copied from the snapshot
+// or hardlinked to the equal live files, they are removed rather than written in place
+func applyLocalChange(local, dst string, kind byte, entryOnly bool) error {
+	if entryOnly {
+		return copyEntryMeta(local, dst)
+	}
+	err := os.RemoveAll(dst)
+	if err != nil {
+		return err
+	}
+	if kind == changeDeleted {
+		return nil
+	}
+	return copyEntry(local, dst)
+}
+
+// keepVersionChange saves the local file next to the file of the version, returns the suffix saved as
+func keepVersionChange(local, dst string, kind byte, entryOnly bool) (string, error) {
+	if kind == changeDeleted || entryOnly {
+		return "", nil
+	}
+	return EtcMergeLocalSuffix, copyEntry(local, dst+EtcMergeLocalSuffix)
+}
+
+// keepLocalChange saves the file of the version next to the local file, returns the suffix saved as
+func keepLocalChange(local, dst, version string, kind byte, entryOnly bool) (string, error) {
+	if entryOnly {
+		return "", copyEntryMeta(local, dst)
+	}
+	var saved string
+	if _, err := os.Lstat(dst); err == nil {
+		saved = EtcMergeVersionSuffix + version
+		err = os.Rename(dst, dst+saved)
+		if err != nil {
+			return "", err
+		}
+	}
+	if kind == changeDeleted {
+		return saved, nil
+	}
+	return saved, copyEntry(local, dst)
+}
+
+// copyEntry hardlinks the local file or dir to the prepared dir, the local is replaced with the rollback
+func copyEntry(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.IsDir():
+		return util.CopyDir(src, dst, nil, nil, true)
+	case fi.Mode()&os.ModeSymlink != 0:
+		return util.Symlink(src, dst)
+	case fi.Mode().IsRegular():
+		return util.CopyFile(src, dst, true)
+	}
+	return fmt.Errorf("unsupported file type: %s", src)
+}
+
+func copyEntryMeta(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to get raw stat for: %s", src)
+	}
+	err = os.Lchown(dst, int(st.Uid), int(st.Gid))
+	if err != nil {
+		return err
+	}
+	return syscall.Chmod(dst, st.Mode&07777)
+}
+
+// loadPackageFiles returns the files of /etc owned by the packages of the version, the conffiles and the files
+// in the md5sums. The dpkg database of the version is used if checked out, the current one otherwise
+func (c *Upgrader) loadPackageFiles(snapDir string) map[string]bool {
+	dpkgRoot := filepath.Join(c.rootMP, snapDir)
+	if !util.IsExists(filepath.Join(dpkgRoot, status.StatusFile)) {
+		dpkgRoot = c.rootMP
+	}
+	files := make(map[string]bool)
+	list, err := status.GetStatusList(filepath.Join(dpkgRoot, status.StatusFile))
+	if err != nil {
+		logger.Warning("failed load the dpkg status, err:", err)
+	}
+	for _, info := range list {
+		for _, v := range info.Conffiles() {
+			if isSubPath(v, EtcDir) {
+				files[v] = true
+			}
+		}
+	}
+	sums, err := md5sums.Load(filepath.Join(dpkgRoot, md5sums.InfoDir))
+	if err != nil {
+		logger.Warning("failed load the dpkg md5sums, err:", err)
+	}
+	for v := range sums {
+		if isSubPath(v, EtcDir) {
+			files[v] = true
+		}
+	}
+	return files
+}
+
+// isPackageFile returns whether the path is a file of the packages or a dir holding one
+func isPackageFile(path string, files map[string]bool) bool {
+	if files[path] {
+		return true
+	}
+	for v := range files {
+		if isSubPath(v, path) {
+			return true
+		}
+	}
+	return false
+}
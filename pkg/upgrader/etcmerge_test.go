@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/module/repo/tree"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeEtcChanges(t *testing.T) {
+	const (
+		path    = "/etc/hosts"
+		version = "v23.0.0.20230101"
+	)
+	var cases = []struct {
+		name      string
+		changes   []*pathChange
+		local     string
+		prepared  string
+		base      string
+		target    string
+		isPackage bool
+		// empty if the file doesn't exist
+		wantFile      string
+		wantSaved     map[string]string
+		wantMerged    []string
+		wantConflicts []string
+	}{
+		{
+			name:       "local changed",
+			changes:    []*pathChange{{path: path, kind: changeModified}},
+			local:      "local",
+			prepared:   "base",
+			base:       "a",
+			target:     "a",
+			wantFile:   "local",
+			wantMerged: []string{path},
+		},
+		{
+			name:     "version changed",
+			local:    "base",
+			prepared: "version",
+			base:     "a",
+			target:   "b",
+			wantFile: "version",
+		},
+		{
+			name:          "package file changed by both",
+			changes:       []*pathChange{{path: path, kind: changeModified}},
+			local:         "local",
+			prepared:      "version",
+			base:          "a",
+			target:        "b",
+			isPackage:     true,
+			wantFile:      "version",
+			wantSaved:     map[string]string{EtcMergeLocalSuffix: "local"},
+			wantMerged:    []string{path + EtcMergeLocalSuffix},
+			wantConflicts: []string{path + ": the version kept, the local saved as " + path + EtcMergeLocalSuffix},
+		},
+		{
+			name:          "other file changed by both",
+			changes:       []*pathChange{{path: path, kind: changeModified}},
+			local:         "local",
+			prepared:      "version",
+			base:          "a",
+			target:        "b",
+			wantFile:      "local",
+			wantSaved:     map[string]string{EtcMergeVersionSuffix + version: "version"},
+			wantMerged:    []string{path, path + EtcMergeVersionSuffix + version},
+			wantConflicts: []string{path + ": the local kept, the version saved as " + path + EtcMergeVersionSuffix + version},
+		},
+		{
+			name:       "local deleted",
+			changes:    []*pathChange{{path: path, kind: changeDeleted}},
+			prepared:   "base",
+			base:       "a",
+			target:     "a",
+			wantMerged: []string{path},
+		},
+		{
+			name:          "package file deleted and changed by the version",
+			changes:       []*pathChange{{path: path, kind: changeDeleted}},
+			prepared:      "version",
+			base:          "a",
+			target:        "b",
+			isPackage:     true,
+			wantFile:      "version",
+			wantConflicts: []string{path + ": the version kept"},
+		},
+	}
+	for _, c := range cases {
+		rootDir := t.TempDir()
+		preparedDir := t.TempDir()
+		err := os.MkdirAll(filepath.Join(rootDir, EtcDir), 0755)
+		if err == nil && len(c.local) != 0 {
+			err = ioutil.WriteFile(filepath.Join(rootDir, path), []byte(c.local), 0644)
+		}
+		if err == nil && len(c.prepared) != 0 {
+			err = ioutil.WriteFile(filepath.Join(preparedDir, filepath.Base(path)), []byte(c.prepared), 0644)
+		}
+		if err != nil {
+			t.Fatal("Except nil, but got error:", err)
+		}
+		baseMap := map[string]*tree.FileInfo{path: {Path: path, Type: tree.TYPE_FILE, Mode: 0644, Checksum: c.base}}
+		targetMap := map[string]*tree.FileInfo{path: {Path: path, Type: tree.TYPE_FILE, Mode: 0644, Checksum: c.target}}
+		packageFiles := make(map[string]bool)
+		if c.isPackage {
+			packageFiles[path] = true
+		}
+
+		merged, conflicts := mergeEtcChanges(c.changes, rootDir, preparedDir, version, baseMap, targetMap, packageFiles)
+		if strings.Join(merged, " ") != strings.Join(c.wantMerged, " ") {
+			t.Errorf("%s: except merged %v, but got %v", c.name, c.wantMerged, merged)
+		}
+		if strings.Join(conflicts, "\n") != strings.Join(c.wantConflicts, "\n") {
+			t.Errorf("%s: except conflicts %v, but got %v", c.name, c.wantConflicts, conflicts)
+		}
+		content, err := ioutil.ReadFile(filepath.Join(preparedDir, filepath.Base(path)))
+		if len(c.wantFile) == 0 && !os.IsNotExist(err) {
+			t.Errorf("%s: except the file removed, but got %q, %v", c.name, string(content), err)
+		} else if len(c.wantFile) != 0 && string(content) != c.wantFile {
+			t.Errorf("%s: except %q, but got %q, %v", c.name, c.wantFile, string(content), err)
+		}
+		for suffix, want := range c.wantSaved {
+			content, err = ioutil.ReadFile(filepath.Join(preparedDir, filepath.Base(path)+suffix))
+			if string(content) != want {
+				t.Errorf("%s: except saved %q, but got %q, %v", c.name, want, string(content), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/mountinfo"
+	"deepin-upgrade-manager/pkg/module/mountpoint"
+	"deepin-upgrade-manager/pkg/module/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// the mounts are gone with a reboot, so are the records
+	VersionMountsPath = "/run/deepin-upgrade-manager/mounts.json"
+	// the target of a version mounted without target
+	DefaultVersionMountDir = "/run/deepin-upgrade-manager/versions"
+)
+
+// VersionMount is a version mounted read-only at the target, the checkouts of the repos are bound in order
+type VersionMount struct {
+	Version string                    `json:"version"`
+	Target  string                    `json:"target"`
+	Time    int64                     `json:"time"`
+	Mounts  mountpoint.MountPointList `json:"mounts"`
+}
+
+// loadVersionMounts returns the versions mounted, the records no longer mounted are dropped
+func (c *Upgrader) loadVersionMounts() []*VersionMount {
+	var list []*VersionMount
+	content, err := ioutil.ReadFile(filepath.Join(c.rootMP, VersionMountsPath))
+	if err != nil {
+		return list
+	}
+	err = json.Unmarshal(content, &list)
+	if err != nil {
+		logger.Warning("failed load the version mounts, err:", err)
+		return nil
+	}
+	infos, err := mountinfo.Load(SelfMountPath)
+	if err != nil {
+		return list
+	}
+	var mounted []*VersionMount
+	for _, v := range list {
+		if len(v.Mounts) != 0 && infos.Match(v.Mounts[0].Dest) != nil {
+			mounted = append(mounted, v)
+		}
+	}
+	return mounted
+}
+
+func (c *Upgrader) saveVersionMounts(list []*VersionMount) error {
+	filename := filepath.Join(c.rootMP, VersionMountsPath)
+	if len(list) == 0 {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(filename), 0750)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	tmpFile := filename + "-" + util.MakeRandomString(util.MinRandomLen)
+	err = ioutil.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = util.Move(filename, tmpFile, true)
+	return err
+}
+
+// MountedVersions returns the versions mounted, their checkouts are in use
+func (c *Upgrader) MountedVersions() []string {
+	var list []string
+	for _, v := range c.loadVersionMounts() {
+		if !util.IsItemInList(v.Version, list) {
+			list = append(list, v.Version)
+		}
+	}
+	return list
+}
+
+// MountVersion mounts the checkouts of the version read-only at the target, the checkouts share the files
+// with the repo. The target is under DefaultVersionMountDir if empty. Returns the target mounted
+func (c *Upgrader) MountVersion(version, target string) (string, int, error) {
+	exitCode := _STATE_TY_SUCCESS
+	var err error
+	var list []*VersionMount
+	var mounts, mounted mountpoint.MountPointList
+	if len(c.rootMP) != 1 {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		err = errors.New("unable to mount in initramfs")
+		goto failure
+	}
+	if len(version) == 0 || !c.IsExistVersion(version) {
+		exitCode = _STATE_TY_FAILED_NO_VERSION
+		err = errors.New("version does not exist")
+		goto failure
+	}
+	if len(target) == 0 {
+		target = filepath.Join(DefaultVersionMountDir, version)
+	}
+	if !filepath.IsAbs(target) {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		err = fmt.Errorf("%s is not an absolute path", target)
+		goto failure
+	}
+	target = filepath.Clean(target)
+	list = c.loadVersionMounts()
+	for _, v := range list {
+		if v.Target != target {
+			continue
+		}
+		if v.Version == version {
+			logger.Infof("%s is already mounted at %s", version, target)
+			return target, int(exitCode), nil
+		}
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		err = fmt.Errorf("%s is mounted at %s", v.Version, target)
+		goto failure
+	}
+	if c.mountInfos.Match(target) != nil {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		err = fmt.Errorf("%s is a mount point", target)
+		goto failure
+	}
+	// the files of the target would be hidden, only a new or empty dir is mounted at
+	if fiList, e := ioutil.ReadDir(target); (e != nil && !os.IsNotExist(e)) || len(fiList) != 0 {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		err = fmt.Errorf("%s is not an empty dir", target)
+		goto failure
+	}
+	for i, v := range c.versionRepos(version) {
+		err = c.repoSnapShot(v, version)
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_OSTREE_ROLLBACK
+			goto failure
+		}
+		dataDir := filepath.Join(c.rootMP, v.SnapshotDir, version)
+		// the first repo is the tree, the subscribed dirs of the others are bound over it
+		if i == 0 {
+			mounts = append(mounts, &mountpoint.MountPoint{Src: dataDir, Dest: target, Bind: true, Options: "ro"})
+			continue
+		}
+		for _, dir := range v.SubscribeList {
+			src := filepath.Join(dataDir, dir)
+			dest := filepath.Join(target, dir)
+			if !util.IsDir(src) || !util.IsDir(filepath.Join(c.rootMP, c.conf.RepoList[0].SnapshotDir, version, dir)) {
+				logger.Warningf("unable to mount %s at %s", src, dest)
+				continue
+			}
+			mounts = append(mounts, &mountpoint.MountPoint{Src: src, Dest: dest, Bind: true, Options: "ro"})
+		}
+	}
+	mounted, err = mounts.Mount()
+	if err != nil {
+		if e := mounted.Umount(); e != nil {
+			logger.Warning("failed umount the version, err:", e)
+		}
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		goto failure
+	}
+	list = append(list, &VersionMount{
+		Version: version,
+		Target:  target,
+		Time:    time.Now().Unix(),
+		Mounts:  mounted,
+	})
+	err = c.saveVersionMounts(list)
+	if err != nil {
+		logger.Warning("failed save the version mounts, err:", err)
+	}
+	logger.Infof("mount %s at %s", version, target)
+	return target, int(exitCode), nil
+failure:
+	return "", int(exitCode), err
+}
+
+// UmountVersion umounts the version mounted at the target, all mounts of the version if the target is empty,
+// and all versions mounted if both are empty
+func (c *Upgrader) UmountVersion(version, target string) (int, error) {
+	if len(target) != 0 {
+		target = filepath.Clean(target)
+	}
+	var kept []*VersionMount
+	var err error
+	list := c.loadVersionMounts()
+	for _, v := range list {
+		if (len(version) != 0 && v.Version != version) || (len(target) != 0 && v.Target != target) {
+			kept = append(kept, v)
+			continue
+		}
+		e := v.Mounts.Umount()
+		if e != nil {
+			logger.Warningf("failed umount %s at %s, err: %v", v.Version, v.Target, e)
+			kept = append(kept, v)
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		logger.Infof("umount %s at %s", v.Version, v.Target)
+		// the default targets are made by the mount
+		if filepath.Dir(v.Target) == filepath.Join(c.rootMP, DefaultVersionMountDir) {
+			_ = os.Remove(v.Target)
+		}
+	}
+	if e := c.saveVersionMounts(kept); e != nil {
+		logger.Warning("failed save the version mounts, err:", e)
+	}
+	if err != nil {
+		return int(_STATE_TY_FAILED_HANDLING_MOUNTS), err
+	}
+	if len(kept) == len(list) && (len(version) != 0 || len(target) != 0) {
+		return int(_STATE_TY_FAILED_HANDLING_MOUNTS), errors.New("the version is not mounted")
+	}
+	return int(_STATE_TY_SUCCESS), nil
+}
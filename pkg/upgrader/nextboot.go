@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/bootkit"
+	bootkitconfig "deepin-upgrade-manager/pkg/config/bootkit"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/bootkitinfo"
+	"deepin-upgrade-manager/pkg/module/grub"
+	"deepin-upgrade-manager/pkg/module/langselector"
+	"deepin-upgrade-manager/pkg/module/util"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// rollbackEntry returns the stable id of the rollback entry generated by deepin-boot-kit
+func rollbackEntry(version string) string {
+	return bootkit.RollbackSubmenuID + ">" + bootkit.RollbackMenuIDPrefix + version
+}
+
+// setNextBoot boots the rollback entry of the version once as grub-reboot does,
+// grub clears it before booting, the following boots use the default entry again
+func setNextBoot(bootRoot, version string) error {
+	if bootkitinfo.Bootloader() == bootkitconfig.BootloaderSystemdBoot {
+		logger.Infof("boot %s once on the next boot", bootkit.RollbackMenuIDPrefix+version)
+		return bootkit.SetBLSOneShot(bootkit.RollbackMenuIDPrefix + version + ".conf")
+	}
+	env, err := grub.LoadGrubEnv(bootRoot)
+	if err != nil {
+		return err
+	}
+	env.Set(grub.NextEntryEnv, rollbackEntry(version))
+	logger.Infof("boot %s once on the next boot", rollbackEntry(version))
+	return env.Save()
+}
+
+// clearNextBoot cancels the rollback entry booted once, the entries set by others are kept
+func clearNextBoot(bootRoot string) error {
+	if bootkitinfo.Bootloader() == bootkitconfig.BootloaderSystemdBoot {
+		id, err := bootkit.ReadLoaderVar(bootkit.LoaderOneShotVar)
+		if err != nil || !strings.HasPrefix(id, bootkit.RollbackMenuIDPrefix) {
+			return nil
+		}
+		logger.Info("cancel the rollback entry booted once")
+		return bootkit.SetBLSOneShot("")
+	}
+	env, err := grub.LoadGrubEnv(bootRoot)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(env.Get(grub.NextEntryEnv), bootkit.RollbackSubmenuID+">") {
+		return nil
+	}
+	env.Unset(grub.NextEntryEnv)
+	logger.Info("cancel the rollback entry booted once")
+	return env.Save()
+}
+
+// the rollback submenu titled by the old releases, translated when the boot menu was generated
+const legacyRollbackMenu = "System Recovery"
+
+// MigrateGrubDefault resets the grub default and timeout set by the old releases, which booted the rollback
+// entry by GRUB_DEFAULT and GRUB_TIMEOUT=0 until the rollback restored them. An interrupted rollback left
+// every boot going to the rollback entry. The settings saved in the rollback records are restored if any
+func (c *Upgrader) MigrateGrubDefault() error {
+	if bootkitinfo.Bootloader() != bootkitconfig.BootloaderGrub {
+		return nil
+	}
+	def, err := grub.ReadGrubParam(c.rootMP, grub.GrubDefaultParam)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	title, _ := util.GetBootKitText(legacyRollbackMenu, langselector.LocalLangEnv())
+	isRollbackEntry := func(entry string) bool {
+		return strings.HasPrefix(entry, legacyRollbackMenu+">") || strings.HasPrefix(entry, title+">")
+	}
+	if !isRollbackEntry(def) {
+		return nil
+	}
+	params := map[string]string{grub.GrubDefaultParam: "0"}
+	timeout, _ := grub.ReadGrubParam(c.rootMP, grub.GrubTimeoutParam)
+	if timeout == "0" {
+		params[grub.GrubTimeoutParam] = "2"
+	}
+	if err = c.LoadRollbackRecords(false); err == nil {
+		if v := strings.Trim(c.recordsInfo.LegacyGrubDefault, `"`); len(v) != 0 && !isRollbackEntry(v) {
+			params[grub.GrubDefaultParam] = v
+		}
+		if _, ok := params[grub.GrubTimeoutParam]; ok && c.recordsInfo.LegacyTimeOut != 0 {
+			params[grub.GrubTimeoutParam] = strconv.Itoa(int(c.recordsInfo.LegacyTimeOut))
+		}
+	}
+	logger.Infof("reset the grub default %q set by the old release to %v", def, params)
+	err = grub.SetGrubParams(c.rootMP, params)
+	if err != nil {
+		return err
+	}
+	_, err = c.UpdateGrub()
+	return err
+}
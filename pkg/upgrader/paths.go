@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// the paths must be rolled back together, otherwise the system is inconsistent,
+// ex: the packages in /usr don't match the dpkg database
+var consistentPaths = map[string][]string{
+	"/usr":          {"/var/lib/dpkg"},
+	"/var/lib/dpkg": {"/usr"},
+}
+
+func isSubPath(path, dir string) bool {
+	return path == dir || dir == "/" || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func isPathCovered(path string, list []string) bool {
+	for _, v := range list {
+		if isSubPath(path, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRollbackPaths only rolls back the paths instead of the whole subscribe list,
+// the paths must be within the subscribe list, empty is the whole subscribe list
+func (c *Upgrader) SetRollbackPaths(paths []string, force bool) error {
+	c.rollbackPaths = nil
+	if len(paths) == 0 {
+		return nil
+	}
+	var subscribeList []string
+	for _, v := range c.conf.RepoList {
+		subscribeList = append(subscribeList, v.SubscribeList...)
+	}
+	var list []string
+	for _, v := range paths {
+		if len(v) == 0 {
+			continue
+		}
+		if !filepath.IsAbs(v) {
+			return fmt.Errorf("%s is not an absolute path", v)
+		}
+		v = filepath.Clean(v)
+		if !isPathCovered(v, subscribeList) {
+			return fmt.Errorf("%s is not within the subscribe list", v)
+		}
+		list = append(list, v)
+	}
+	// the sub path of another selected path is rolled back with it
+	var selected []string
+	for i, v := range list {
+		isCovered := false
+		for j, dir := range list {
+			if i != j && isSubPath(v, dir) && (v != dir || j < i) {
+				isCovered = true
+				break
+			}
+		}
+		if !isCovered {
+			selected = append(selected, v)
+		}
+	}
+	for _, v := range selected {
+		for dir, requires := range consistentPaths {
+			if !isSubPath(v, dir) && !isSubPath(dir, v) {
+				continue
+			}
+			for _, require := range requires {
+				if !isPathCovered(require, subscribeList) || isPathCovered(require, selected) {
+					continue
+				}
+				if !force {
+					return fmt.Errorf("%s must be rolled back with %s, force to ignore", v, require)
+				}
+				logger.Warningf("force to roll back %s without %s, the system may be inconsistent", v, require)
+			}
+		}
+	}
+	logger.Info("only roll back the paths:", selected)
+	c.rollbackPaths = selected
+	return nil
+}
+
+// selectRollbackPaths returns the selected paths within the subscribe list of a repo
+func selectRollbackPaths(subscribeList, paths []string) []string {
+	if len(paths) == 0 {
+		return subscribeList
+	}
+	var list []string
+	for _, v := range paths {
+		if isPathCovered(v, subscribeList) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
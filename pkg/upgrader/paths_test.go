@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"strings"
+	"testing"
+)
+
+func TestSetRollbackPaths(t *testing.T) {
+	c := &Upgrader{conf: &config.Config{RepoList: config.RepoListConfig{
+		{SubscribeList: []string{"/usr", "/etc", "/var/lib/dpkg", "/boot"}},
+		{SubscribeList: []string{"/opt"}},
+	}}}
+	var cases = []struct {
+		name    string
+		paths   []string
+		force   bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty is the whole subscribe list", paths: nil, want: nil},
+		{name: "within the subscribe list", paths: []string{"/etc/apt", "/opt"}, want: []string{"/etc/apt", "/opt"}},
+		{name: "cleaned", paths: []string{"/etc/apt/../default/", ""}, want: []string{"/etc/default"}},
+		{name: "outside the subscribe list", paths: []string{"/home"}, wantErr: true},
+		{name: "prefix of a subscribed dir", paths: []string{"/etcetera"}, wantErr: true},
+		{name: "relative", paths: []string{"etc/apt"}, wantErr: true},
+		{name: "duplicates", paths: []string{"/etc/apt", "/etc/apt/", "/etc/apt"}, want: []string{"/etc/apt"}},
+		{name: "sub path of another", paths: []string{"/etc/apt/sources.list", "/etc"}, want: []string{"/etc"}},
+		{name: "without the consistent path", paths: []string{"/usr"}, wantErr: true},
+		{name: "forced without the consistent path", paths: []string{"/usr/bin"}, force: true, want: []string{"/usr/bin"}},
+		{name: "with the consistent path", paths: []string{"/usr", "/var/lib/dpkg"}, want: []string{"/usr", "/var/lib/dpkg"}},
+	}
+	for _, v := range cases {
+		c.rollbackPaths = []string{"/stale"}
+		err := c.SetRollbackPaths(v.paths, v.force)
+		if v.wantErr {
+			if err == nil {
+				t.Errorf("%s: except error, but got nil", v.name)
+			}
+			if len(c.rollbackPaths) != 0 {
+				t.Errorf("%s: except no paths selected, but got %v", v.name, c.rollbackPaths)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: except nil, but got error: %v", v.name, err)
+			continue
+		}
+		if strings.Join(c.rollbackPaths, " ") != strings.Join(v.want, " ") {
+			t.Errorf("%s: except %v, but got %v", v.name, v.want, c.rollbackPaths)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/generator"
+	"deepin-upgrade-manager/pkg/module/mountinfo"
+	"deepin-upgrade-manager/pkg/module/mountpoint"
+	"deepin-upgrade-manager/pkg/module/repo"
+	"deepin-upgrade-manager/pkg/module/repo/branch"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// repoHandler opens the repo under the root dir. The first repo holds the config, the rollback journal and
+// the data of the versions, a version is committed to all the repos with the same name. A repo added later
+// doesn't track the versions before its first version, the version exists if all the repos tracking it hold it
+func (c *Upgrader) repoHandler(repoConf *config.RepoConfig) (repo.Repository, error) {
+	return repo.NewRepo(repo.REPO_TY_OSTREE, filepath.Join(c.rootMP, repoConf.Repo))
+}
+
+// isVersionTracked returns whether the repo holding the versions tracks the version, the versions are
+// the newest first
+func isVersionTracked(version string, versions []string) bool {
+	if len(versions) == 0 {
+		return false
+	}
+	first := versions[len(versions)-1]
+	return !branch.IsValid(first) || !branch.IsValid(version) || !generator.Less(first, version)
+}
+
+// versionRepos returns the repos holding the version, the first repo always holds the versions listed
+func (c *Upgrader) versionRepos(version string) config.RepoListConfig {
+	var list config.RepoListConfig
+	for i, v := range c.conf.RepoList {
+		if i == 0 || c.repoSet[v.Repo].Exist(version) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// lastVersion returns the newest version in the repos, a repo may hold a version the others don't
+func (c *Upgrader) lastVersion() (string, error) {
+	var last string
+	for i, v := range c.conf.RepoList {
+		handler, err := c.repoHandler(v)
+		if err != nil {
+			return "", err
+		}
+		name, err := handler.Last()
+		if err != nil {
+			return "", err
+		}
+		if i == 0 || (branch.IsValid(name) && branch.IsValid(last) && generator.Less(name, last)) {
+			last = name
+		}
+	}
+	return last, nil
+}
+
+// commitRepos commits the version to all the repos, the version is deleted from the repos
+// committed if any fails, with the data of the version
+func (c *Upgrader) commitRepos(version, subject string, useSysData bool,
+	evHandler func(op, state int32, target, desc string)) error {
+	for i, v := range c.conf.RepoList {
+		err := c.repoCommit(v, version, subject, useSysData, evHandler)
+		if err == nil {
+			continue
+		}
+		logger.Warningf("failed commit %s to %s, delete it from the repos, err: %v", version, v.Repo, err)
+		for _, repoConf := range c.conf.RepoList[:i+1] {
+			handler := c.repoSet[repoConf.Repo]
+			if !handler.Exist(version) {
+				continue
+			}
+			e := handler.Delete(version)
+			if e != nil {
+				logger.Warningf("failed delete %s from %s, err: %v", version, repoConf.Repo, e)
+			}
+		}
+		e := c.conf.RemoveVersionConfig(version, c.rootMP)
+		if e != nil {
+			logger.Warningf("failed remove the data of %s, err: %v", version, e)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkDeleteVersion checks the version can be deleted from all the repos holding it
+func (c *Upgrader) checkDeleteVersion(version string) (stateType, error) {
+	var isFound bool
+	for _, v := range c.conf.RepoList {
+		handler, err := c.repoHandler(v)
+		if err != nil {
+			return _STATE_TY_FAILED_NO_REPO, err
+		}
+		if !handler.Exist(version) {
+			continue
+		}
+		isFound = true
+		first, err := handler.First()
+		if err != nil {
+			return _STATE_TY_FAILED_NO_REPO, err
+		}
+		if first == version {
+			return _STATE_TY_FAILED_VERSION_DELETE, fmt.Errorf("the first version of %s does not allow deletion", v.Repo)
+		}
+	}
+	if !isFound {
+		return _STATE_TY_FAILED_NO_VERSION, errors.New("version does not exist")
+	}
+	return _STATE_TY_SUCCESS, nil
+}
+
+// deleteVersion deletes the version and its snapshot from the repos holding it one by one, checked by
+// checkDeleteVersion before. If a repo fails, the repos after it still hold the version, deleting it again
+// finishes the deletion
+func (c *Upgrader) deleteVersion(version string) error {
+	for _, v := range c.conf.RepoList {
+		handler, err := c.repoHandler(v)
+		if err != nil {
+			return err
+		}
+		if handler.Exist(version) {
+			err = handler.Delete(version)
+			if err != nil {
+				return err
+			}
+		}
+		snapshotDir := filepath.Join(c.rootMP, v.SnapshotDir, version)
+		logger.Debug("delete tmp snapshot directory:", snapshotDir)
+		_ = os.RemoveAll(snapshotDir)
+	}
+	return nil
+}
+
+// mountRepos mounts the partitions of the other repos by the fstab in initramfs,
+// only the partition of the first repo is mounted before the rollback
+func (c *Upgrader) mountRepos() (mountpoint.MountPointList, error) {
+	var list mountpoint.MountPointList
+	for _, v := range c.conf.RepoList[1:] {
+		dest := filepath.Join(c.rootMP, v.RepoMountPoint)
+		info := c.fsInfo.MatchDestPoint(v.RepoMountPoint)
+		if v.RepoMountPoint == "/" || len(info.SrcPoint) == 0 || c.mountInfos.Match(dest) != nil {
+			continue
+		}
+		mp := &mountpoint.MountPoint{
+			Src:     info.SrcPoint,
+			Dest:    dest,
+			FSType:  info.FSType,
+			Options: info.Options,
+			Bind:    info.Bind,
+		}
+		logger.Infof("the partition of %s is not mounted and needs to be mounted", v.Repo)
+		err := mp.Mount()
+		if err != nil {
+			return list, err
+		}
+		list = append(list, mp)
+		mountInfos, err := mountinfo.Load(SelfMountPath)
+		if err != nil {
+			return list, err
+		}
+		c.mountInfos = mountInfos
+	}
+	return list, nil
+}
+
+// RepoUUIDs returns the uuids of the partitions of the repos, the first is the partition of the first repo
+func (c *Upgrader) RepoUUIDs() ([]string, error) {
+	_, uuid, err := c.RepoMountpointAndUUID()
+	if err != nil {
+		return nil, err
+	}
+	list := []string{uuid}
+	for _, v := range c.conf.RepoList[1:] {
+		if v.RepoMountPoint == c.conf.RepoList[0].RepoMountPoint {
+			list = append(list, uuid)
+			continue
+		}
+		list = append(list, c.fsInfo.MatchDestPoint(v.RepoMountPoint).DiskUUID)
+	}
+	return list, nil
+}
@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// the dir the files restored out of their paths go to, only root has access
+const DefaultRestoreDir = "/var/lib/deepin-upgrade-manager/restore"
+
+// RestoreFile restores the file or directory of the version to the dest without rebooting,
+// the dest is the path itself if empty or else under DefaultRestoreDir, the current dest is kept as the returned backup
+func (c *Upgrader) RestoreFile(version, path, dest string) (string, int, error) {
+	exitCode := _STATE_TY_SUCCESS
+	var backup string
+	var err error
+	var handler, tmpDir, restored string
+	if len(version) == 0 || !c.IsExistVersion(version) {
+		exitCode = _STATE_TY_FAILED_NO_VERSION
+		err = errors.New("version does not exist")
+		goto failure
+	}
+	if !filepath.IsAbs(path) {
+		exitCode = _STATE_TY_FAILED_RESTORE_FILE
+		err = fmt.Errorf("%s is not an absolute path", path)
+		goto failure
+	}
+	path = filepath.Clean(path)
+	if len(dest) == 0 {
+		dest = path
+	}
+	if !filepath.IsAbs(dest) {
+		exitCode = _STATE_TY_FAILED_RESTORE_FILE
+		err = fmt.Errorf("%s is not an absolute path", dest)
+		goto failure
+	}
+	dest = filepath.Clean(dest)
+	if dest != path && !strings.HasPrefix(dest, DefaultRestoreDir+"/") {
+		exitCode = _STATE_TY_FAILED_RESTORE_FILE
+		err = fmt.Errorf("%s is neither %s nor under %s", dest, path, DefaultRestoreDir)
+		goto failure
+	}
+	if dest != path {
+		err = c.prepareRestoreDir()
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_RESTORE_FILE
+			goto failure
+		}
+	}
+	dest = filepath.Join(c.rootMP, dest)
+	for _, v := range c.versionRepos(version) {
+		if isPathCovered(path, v.SubscribeList) {
+			handler = v.Repo
+			break
+		}
+	}
+	if len(handler) == 0 {
+		exitCode = _STATE_TY_FAILED_RESTORE_FILE
+		err = fmt.Errorf("%s is not within the subscribe list", path)
+		goto failure
+	}
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RESTORE_FILE
+		goto failure
+	}
+	// checkout in the private cache dir, the restored file is moved into place
+	err = os.MkdirAll(filepath.Join(c.rootMP, c.conf.CacheDir), 0750)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RESTORE_FILE
+		goto failure
+	}
+	tmpDir = filepath.Join(c.rootMP, c.conf.CacheDir, "restore-"+util.MakeRandomString(util.MinRandomLen))
+	defer os.RemoveAll(tmpDir)
+	restored, err = c.checkoutPath(handler, version, path, tmpDir)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RESTORE_FILE
+		goto failure
+	}
+	backup, err = moveRestored(restored, dest)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RESTORE_FILE
+		goto failure
+	}
+	if len(backup) != 0 {
+		logger.Infof("the current %s is saved as %s", dest, backup)
+	}
+	logger.Infof("restore %s of %s to %s", path, version, dest)
+	return backup, int(exitCode), nil
+failure:
+	return "", int(exitCode), err
+}
+
+// checkoutPath checks out the path of the version under the dir, returns the checked out path
+func (c *Upgrader) checkoutPath(repoDir, version, path, dir string) (string, error) {
+	handler := c.repoSet[repoDir]
+	infos, err := handler.Ls(version, path, false)
+	if err != nil || len(infos) == 0 {
+		return "", fmt.Errorf("%s does not exist in %s", path, version)
+	}
+	err = os.Mkdir(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, "data")
+	err = handler.Checkout(version, path, dst)
+	if err != nil {
+		return "", err
+	}
+	// the file is checked out into the dst directory
+	if !infos[0].IsDir() && util.IsDir(dst) {
+		return filepath.Join(dst, filepath.Base(path)), nil
+	}
+	return dst, nil
+}
+
+// prepareRestoreDir creates DefaultRestoreDir, which must be a dir of root's only
+func (c *Upgrader) prepareRestoreDir() error {
+	dir := filepath.Join(c.rootMP, DefaultRestoreDir)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("failed to get raw stat for: %s", dir)
+	}
+	if !fi.IsDir() || st.Uid != 0 || fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s is not a private dir of root", dir)
+	}
+	return nil
+}
+
+// moveRestored replaces the dest with the restored, the current dest is kept as the returned backup.
+// The restored is copied if on another filesystem
+func moveRestored(restored, dest string) (string, error) {
+	backup, err := util.Move(dest, restored, false)
+	if !errors.Is(err, syscall.EXDEV) {
+		return backup, err
+	}
+	if _, err = os.Lstat(dest); err == nil {
+		backup = dest + "-bak-" + util.MakeRandomString(util.MinRandomLen)
+		err = os.Rename(dest, backup)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = util.ExecCommand("cp", []string{"-a", "-T", restored, dest})
+	if err != nil {
+		_ = os.RemoveAll(dest)
+		if len(backup) != 0 {
+			_ = os.Rename(backup, dest)
+		}
+		return "", err
+	}
+	return backup, nil
+}
@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/util"
+	"deepin-upgrade-manager/pkg/rootfs"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// the pid of the run is saved in the cache dir, named by the version
+const runPidSuffix = ".pid"
+
+// RunCommand runs the command in the rootfs of the version, the dirs of the version are bound read-only over the
+// current system, which is bound read-only too except the empty /tmp. The mounts are made in a private mount
+// namespace, they are gone with the process even if killed. Returns the exit code of the command once it's run
+func (c *Upgrader) RunCommand(version string, args []string) (int, error) {
+	exitCode := _STATE_TY_SUCCESS
+	var err error
+	var fs *rootfs.Rootfs
+	var cmd *exec.Cmd
+	var sigCh chan os.Signal
+	if len(c.rootMP) != 1 {
+		exitCode = _STATE_TY_FAILED_RUN_COMMAND
+		err = errors.New("unable to run in initramfs")
+		goto failure
+	}
+	if len(version) == 0 || !c.IsExistVersion(version) {
+		exitCode = _STATE_TY_FAILED_NO_VERSION
+		err = errors.New("version does not exist")
+		goto failure
+	}
+	if len(args) == 0 {
+		exitCode = _STATE_TY_FAILED_RUN_COMMAND
+		err = errors.New("no command to run")
+		goto failure
+	}
+	err = c.removeStaleRootfs(version)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RUN_COMMAND
+		goto failure
+	}
+	// the checkout is in use until the command exits
+	err = os.MkdirAll(filepath.Dir(c.runPidFile(version)), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(c.runPidFile(version), []byte(strconv.Itoa(os.Getpid())), 0644)
+	}
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RUN_COMMAND
+		goto failure
+	}
+	defer os.Remove(c.runPidFile(version))
+	for _, v := range c.versionRepos(version) {
+		err = c.repoSnapShot(v, version)
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_RUN_COMMAND
+			goto failure
+		}
+	}
+
+	// the namespace belongs to the thread, the thread is never unlocked and exits with the goroutine
+	runtime.LockOSThread()
+	err = syscall.Unshare(syscall.CLONE_NEWNS)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		goto failure
+	}
+	err = syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, "")
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		goto failure
+	}
+	fs, err = rootfs.NewRootfs(c.conf, c.rootMP, version)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RUN_COMMAND
+		goto failure
+	}
+	defer func() {
+		err := fs.Clean()
+		if err != nil {
+			logger.Warningf("failed clean %s, err: %v", fs.RootDir, err)
+		}
+	}()
+	err = fs.Make()
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		goto failure
+	}
+
+	cmd, err = rootfsCommand(fs.RootDir, args)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RUN_COMMAND
+		goto failure
+	}
+	// the mounts are cleaned after the command exits, the signals are passed to it
+	sigCh = make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(sigCh)
+	logger.Infof("run %v in %s", args, version)
+	err = cmd.Start()
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RUN_COMMAND
+		goto failure
+	}
+	go func() {
+		for sig := range sigCh {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_RUN_COMMAND
+		goto failure
+	}
+	return 0, nil
+failure:
+	return int(exitCode), err
+}
+
+// runPidFile returns the file saving the pid of the run of the version
+func (c *Upgrader) runPidFile(version string) string {
+	return filepath.Join(c.rootMP, c.conf.CacheDir, version+runPidSuffix)
+}
+
+// runningVersions returns the versions run by the processes alive, their checkouts are in use
+func (c *Upgrader) runningVersions() []string {
+	var list []string
+	fiList, err := ioutil.ReadDir(filepath.Join(c.rootMP, c.conf.CacheDir))
+	if err != nil {
+		return list
+	}
+	for _, fi := range fiList {
+		version := strings.TrimSuffix(fi.Name(), runPidSuffix)
+		if version != fi.Name() && c.isRunAlive(version) {
+			list = append(list, version)
+		}
+	}
+	return list
+}
+
+func (c *Upgrader) isRunAlive(version string) bool {
+	data, err := ioutil.ReadFile(c.runPidFile(version))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err == nil && pid > 0 && util.IsExists(filepath.Join("/proc", strconv.Itoa(pid)))
+}
+
+// removeStaleRootfs removes the rootfs of the version left by the run killed, nothing is mounted under it
+// out of the namespace of the run
+func (c *Upgrader) removeStaleRootfs(version string) error {
+	if c.isRunAlive(version) {
+		return fmt.Errorf("%s is running a command", version)
+	}
+	dir := filepath.Join(c.rootMP, c.conf.CacheDir, version)
+	if !util.IsExists(dir) {
+		return nil
+	}
+	for _, v := range c.mountInfos {
+		if isSubPath(v.MountPoint, dir) {
+			return fmt.Errorf("%s is mounted under %s", v.MountPoint, dir)
+		}
+	}
+	logger.Debug("remove the stale rootfs:", dir)
+	return os.RemoveAll(dir)
+}
+
+// rootfsCommand returns the command chrooted to the rootDir, the program is looked up in the rootDir.
+// The command is built without exec.Command, which looks up the program in the current system
+func rootfsCommand(rootDir string, args []string) (*exec.Cmd, error) {
+	path, err := lookPathIn(rootDir, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return &exec.Cmd{
+		Path:        path,
+		Args:        args,
+		Dir:         "/",
+		Stdin:       os.Stdin,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{Chroot: rootDir},
+	}, nil
+}
+
+// lookPathIn searches the command in the PATH of the root dir, returns the path under the root dir
+func lookPathIn(rootDir, file string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		path := filepath.Join(dir, file)
+		fi, err := os.Stat(filepath.Join(rootDir, path))
+		if err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in the version", file)
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// the program exists in the rootfs only, not in the current system
+func TestRootfsCommand(t *testing.T) {
+	const name = "deepin-upgrade-manager-rootfs-only"
+	rootDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(rootDir, "usr/bin"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(rootDir, "usr/bin", name), []byte("#!/bin/sh\nexit 7\n"), 0755)
+	}
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", "/usr/bin:/bin")
+	t.Cleanup(func() {
+		os.Setenv("PATH", oldPath)
+	})
+	if _, err = exec.LookPath(name); err == nil {
+		t.Skip(name, "exists in the current system")
+	}
+
+	cmd, err := rootfsCommand(rootDir, []string{name, "arg"})
+	if err != nil {
+		t.Fatal("Except nil, but got error:", err)
+	}
+	if cmd.Path != "/usr/bin/"+name {
+		t.Errorf("Except /usr/bin/%s, but got %s", name, cmd.Path)
+	}
+	if cmd.SysProcAttr == nil || cmd.SysProcAttr.Chroot != rootDir {
+		t.Errorf("Except chroot to %s, but got %v", rootDir, cmd.SysProcAttr)
+	}
+	// chroot needs root, run the program by its path under the rootfs instead
+	cmd.Path = filepath.Join(rootDir, cmd.Path)
+	cmd.SysProcAttr = nil
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, nil, nil
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 7 {
+		t.Errorf("Except exit code 7, but got %v", err)
+	}
+
+	_, err = rootfsCommand(rootDir, []string{"deepin-upgrade-manager-nonexistent"})
+	if err == nil {
+		t.Error("Except error of the program not in the rootfs, but got nil")
+	}
+}
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/bootkitinfo"
+	"deepin-upgrade-manager/pkg/module/secureboot"
+	"deepin-upgrade-manager/pkg/module/util"
+	"path/filepath"
+)
+
+// verifySecureBoot marks the versions whose kernel would be refused by the firmware or shim
+func (c *Upgrader) verifySecureBoot(listInfo *bootkitinfo.BootInfoList) {
+	if !secureboot.IsEnabled() {
+		return
+	}
+	verifier, err := secureboot.NewVerifier()
+	if err != nil {
+		logger.Warning("failed verify the snapshot kernels, err:", err)
+		for _, v := range listInfo.VersionList {
+			v.SecureBoot = secureboot.StatusUnknown
+		}
+		return
+	}
+	defer verifier.Close()
+	for _, v := range listInfo.VersionList {
+		kernel := filepath.Join(c.rootMP, bootkitinfo.BOOT_SNAPSHOT_DIR, v.Version, filepath.Base(v.Kernel))
+		// grub loads the signed kernel if exists
+		if util.IsExists(kernel + ".efi.signed") {
+			kernel += ".efi.signed"
+		}
+		v.SecureBoot = verifier.Verify(kernel)
+		if secureboot.IsFailed(v.SecureBoot) {
+			logger.Warningf("the kernel of %s is %s, can't boot with secure boot", v.Version, v.SecureBoot)
+		}
+	}
+}
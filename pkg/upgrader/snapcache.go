@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/dirinfo"
+	"deepin-upgrade-manager/pkg/module/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const mib = 1024 * 1024
+
+// repoSnapShot checks out the version into the snapshot dir, the checkout is reused while the commit is unchanged
+func (c *Upgrader) repoSnapShot(repoConf *config.RepoConfig, version string) error {
+	handler := c.repoSet[repoConf.Repo]
+	dataDir := filepath.Join(c.rootMP, repoConf.SnapshotDir, version)
+	checksum, err := handler.Checksum(version)
+	if err != nil {
+		return err
+	}
+	cache := repoConf.LoadSnapshotCache(c.rootMP)
+	entry, ok := cache[version]
+	if ok && entry.Checksum == checksum && util.IsDir(dataDir) {
+		logger.Debugf("reuse the checkout of %s: %s", version, dataDir)
+		entry.LastUsed = time.Now().Unix()
+	} else {
+		removeAllDir(dataDir)
+		err = handler.Snapshot(version, dataDir)
+		if err != nil {
+			return err
+		}
+		entry = &config.SnapshotEntry{
+			Checksum: checksum,
+			LastUsed: time.Now().Unix(),
+			Size:     dirinfo.GetDirOwnSize(dataDir),
+		}
+		cache[version] = entry
+	}
+	err = repoConf.SetSnapshotCache(c.rootMP, cache)
+	if err != nil {
+		logger.Warning("failed save the snapshot cache, err:", err)
+	}
+	return nil
+}
+
+// forgetSnapshot marks the checkouts of the version consumed, the rollback moves the files out of them
+func (c *Upgrader) forgetSnapshot(version string) {
+	for _, v := range c.conf.RepoList {
+		cache := v.LoadSnapshotCache(c.rootMP)
+		if _, ok := cache[version]; !ok {
+			continue
+		}
+		delete(cache, version)
+		err := v.SetSnapshotCache(c.rootMP, cache)
+		if err != nil {
+			logger.Warning("failed save the snapshot cache, err:", err)
+		}
+	}
+}
+
+// protectedSnapshots returns the versions whose checkouts must be kept: the pending rollback,
+// the version tried, the versions mounted or running a command and the versions in use by the caller
+func (c *Upgrader) protectedSnapshots(inUse []string) []string {
+	list := append([]string{}, inUse...)
+	list = append(list, c.MountedVersions()...)
+	list = append(list, c.runningVersions()...)
+	if err := c.LoadRollbackRecords(false); err == nil && len(c.recordsInfo.RollbackVersion) != 0 {
+		list = append(list, c.recordsInfo.RollbackVersion)
+	}
+	if version := TrialVersion(); len(version) != 0 {
+		list = append(list, version)
+	}
+	return list
+}
+
+// GC removes the checkouts in the snapshot dirs, all of them if force, otherwise the stale ones and the least
+// recently used ones out of the budget. The protected checkouts are kept. Returns the versions removed
+func (c *Upgrader) GC(force bool, inUse []string) ([]string, int64) {
+	var removed []string
+	var freed int64
+	// the rollback in initramfs consumes the checkouts
+	if len(c.rootMP) != 1 {
+		return removed, freed
+	}
+	protected := c.protectedSnapshots(inUse)
+	budget := c.conf.SnapshotCacheConfig()
+	for _, v := range c.conf.RepoList {
+		handler := c.repoSet[v.Repo]
+		snapDir := filepath.Join(c.rootMP, v.SnapshotDir)
+		fiList, err := ioutil.ReadDir(snapDir)
+		if err != nil {
+			continue
+		}
+		cache := v.LoadSnapshotCache(c.rootMP)
+		var kept []string
+		var total int64
+		for _, fi := range fiList {
+			version := fi.Name()
+			if !fi.IsDir() || strings.HasPrefix(version, ".") || util.IsItemInList(version, protected) {
+				continue
+			}
+			entry, ok := cache[version]
+			// the checkouts left by the old releases or consumed by the rollback, and the deleted or recommitted versions
+			checksum, err := handler.Checksum(version)
+			if force || !ok || err != nil || entry.Checksum != checksum {
+				freed += c.evictSnapshot(v, cache, version)
+				removed = append(removed, version)
+				continue
+			}
+			kept = append(kept, version)
+			total += entry.Size
+		}
+		// the least recently used first
+		sort.Slice(kept, func(i, j int) bool {
+			return cache[kept[i]].LastUsed < cache[kept[j]].LastUsed
+		})
+		for _, version := range kept {
+			if total <= budget.MaxSize*mib && isSpaceEnough(snapDir, budget.MinFreeSpace*mib) {
+				break
+			}
+			total -= cache[version].Size
+			freed += c.evictSnapshot(v, cache, version)
+			removed = append(removed, version)
+		}
+		for version := range cache {
+			if !util.IsDir(filepath.Join(snapDir, version)) {
+				delete(cache, version)
+			}
+		}
+		err = v.SetSnapshotCache(c.rootMP, cache)
+		if err != nil {
+			logger.Warning("failed save the snapshot cache, err:", err)
+		}
+	}
+	return removed, freed
+}
+
+func (c *Upgrader) evictSnapshot(repoConf *config.RepoConfig, cache config.SnapshotCache, version string) int64 {
+	dataDir := filepath.Join(c.rootMP, repoConf.SnapshotDir, version)
+	size := dirinfo.GetDirOwnSize(dataDir)
+	logger.Infof("remove the checkout of %s: %s", version, dataDir)
+	removeAllDir(dataDir)
+	delete(cache, version)
+	return size
+}
+
+func removeAllDir(dir string) {
+	err := os.RemoveAll(dir)
+	if err != nil {
+		// When failure to delete extended attributes 'i' delete again
+		util.RemoveDirAttr(dir)
+		err = os.RemoveAll(dir)
+		if err != nil {
+			logger.Warning("failed remove dir, err:", err)
+		}
+	}
+}
+
+func isSpaceEnough(dir string, size int64) bool {
+	free, err := dirinfo.GetPartitionFreeSize(dir)
+	if err != nil {
+		return true
+	}
+	return int64(free) >= size
+}
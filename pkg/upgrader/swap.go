@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/journal"
+	"deepin-upgrade-manager/pkg/module/util"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// the journal of the rollback in the config dir of the first repo, out of the subscribed dirs
+	RollbackJournalPath = "rollback.journal"
+)
+
+func (c *Upgrader) loadJournal(version string) {
+	c.journal = journal.Load(filepath.Join(c.rootMP, c.conf.RepoList[0].ConfigDir, RollbackJournalPath), version)
+}
+
+// rollbackOps are the steps of the rollback on the subscribed dirs of a repo, the mount points
+// of other partitions under a dir are handled with the dir
+type rollbackOps struct {
+	c                 *Upgrader
+	repoConf          *config.RepoConfig
+	snapDir           string
+	version           string
+	filterMountedList []string
+}
+
+func (ops *rollbackOps) handle(dir string,
+	HandlerDir func(src, dst, version, rootDir string, filter []string) (string, error)) error {
+	var rollbackDirList []string
+	return ops.c.handleRepoRollbak(dir, ops.snapDir, ops.version, ops.filterMountedList, &rollbackDirList, HandlerDir)
+}
+
+func (ops *rollbackOps) Prepare(dir string) error {
+	return ops.handle(dir, util.HandlerDirPrepare)
+}
+
+func (ops *rollbackOps) FilterCopy(dir string) error {
+	for _, v := range ops.c.replacedDirs(dir) {
+		newDir := filepath.Join(v, "."+ops.version)
+		if !util.IsDir(newDir) {
+			continue
+		}
+		ops.c.copyFilterFiles(newDir, ops.repoConf.FilterList)
+		// the local changes of /etc are merged over the version, the filtered files are kept as is
+		if ops.c.conf.EtcMerge {
+			if etcDir := preparedEtcDir(ops.c.rootMP, v, newDir); util.IsDir(etcDir) {
+				ops.c.mergeEtc(ops.repoConf, ops.snapDir, ops.version, etcDir)
+			}
+		}
+	}
+	return nil
+}
+
+func (ops *rollbackOps) Swap(dir string) error {
+	// the immutable and append-only entries can't be moved out
+	var locked []*lockedEntry
+	for _, v := range ops.c.replacedDirs(dir) {
+		locked = append(locked, unlockEntries(v)...)
+	}
+	defer relockEntries(locked)
+	return ops.handle(dir, ops.c.swapDirRollback)
+}
+
+func (ops *rollbackOps) Cleanup(dir string) error {
+	for _, v := range ops.c.replacedDirs(dir) {
+		removeAllDir(filepath.Join(v, ".old"+ops.version))
+		removeAllDir(filepath.Join(v, "."+ops.version))
+	}
+	return nil
+}
+
+func (ops *rollbackOps) Discard(dir string) error {
+	for _, v := range ops.c.replacedDirs(dir) {
+		removeAllDir(filepath.Join(v, "."+ops.version))
+	}
+	return nil
+}
+
+// replacedDirs returns the dir and the mount points under it
+func (c *Upgrader) replacedDirs(dir string) []string {
+	list := []string{dir}
+	for _, v := range c.mountInfos {
+		if v.MountPoint != dir && isSubPath(v.MountPoint, dir) && !util.IsItemInList(v.MountPoint, list) {
+			list = append(list, v.MountPoint)
+		}
+	}
+	return list
+}
+
+// bootDirLast moves /boot to the end, the system keeps bootable until the others are replaced
+func bootDirLast(rootDir string, dirs []string) []string {
+	var list []string
+	var bootDir string
+	for _, dir := range dirs {
+		if strings.HasSuffix(filepath.Join(rootDir, "/boot"), dir) {
+			logger.Debugf("the %s needs to be replaced last", dir)
+			bootDir = dir
+			continue
+		}
+		list = append(list, dir)
+	}
+	if len(bootDir) != 0 {
+		list = append(list, bootDir)
+	}
+	return list
+}
+
+// swapDirRollback replaces the dir by the prepared dir in one exchange. The dirs holding mount points
+// or on the filesystems unsupported fall back to moving the entries out, then the prepared entries in
+func (c *Upgrader) swapDirRollback(src, dst, version, rootDir string, filter []string) (string, error) {
+	dir := filepath.Join(rootDir, dst)
+	canExchange := len(filter) == 0 && len(c.replacedDirs(dir)) == 1 && c.mountInfos.Match(dir) == nil
+	err := c.journal.SwapDir(dir, canExchange,
+		func() error {
+			_, err := util.HandlerDirMoveOut(src, dst, version, rootDir, filter)
+			return err
+		},
+		func() error {
+			_, err := util.HandlerDirMoveIn(src, dst, version, rootDir, filter)
+			return err
+		})
+	return filepath.Join(dir, ".old"+version), err
+}
+
+// swapDirRecover restores the dir replaced by swapDirRollback
+func (c *Upgrader) swapDirRecover(src, dst, version, rootDir string, filter []string) (string, error) {
+	dir := filepath.Join(rootDir, dst)
+	swap := c.journal.Swap(dir)
+	if swap == nil {
+		return util.HandlerDirRecover(src, dst, version, rootDir, filter)
+	}
+	err := swap.Reverse()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "."+version), nil
+}
@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	bootkitconfig "deepin-upgrade-manager/pkg/config/bootkit"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/bootkitinfo"
+	"deepin-upgrade-manager/pkg/module/util"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// set up in initramfs, moved to the trial system with /run
+	TrialRunDir = "/run/deepin-upgrade-manager/trial"
+
+	trialVersionFile = "version"
+	trialRootDir     = "root"
+	trialUpperDir    = "upper"
+	trialWorkDir     = "work"
+)
+
+// TrialVersion returns the version the system is trying, empty if it isn't a trial boot
+func TrialVersion() string {
+	data, err := ioutil.ReadFile(filepath.Join(TrialRunDir, trialVersionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// trialMount is a dir of the trial system, an overlay of the lower dir with the changes in memory
+type trialMount struct {
+	lower  string
+	target string
+}
+
+// trialMounts returns the dirs of the trial system, parents first. The root is the current root, the
+// subscribed dirs are replaced by the snapshot, the dirs filtered from the snapshot come from the current root
+func (c *Upgrader) trialMounts(version, rootDir string) []trialMount {
+	list := []trialMount{{lower: rootDir, target: c.rootMP}}
+	for _, v := range c.versionRepos(version) {
+		dataDir := filepath.Join(c.rootMP, v.SnapshotDir, version)
+		for _, dir := range v.SubscribeList {
+			if !util.IsDir(filepath.Join(dataDir, dir)) {
+				continue
+			}
+			list = append(list, trialMount{lower: filepath.Join(dataDir, dir), target: filepath.Join(c.rootMP, dir)})
+		}
+		filterList := append(c.getFilterList(c.fsInfo, v.FilterList, v.SubscribeList), v.FilterList...)
+		for _, dir := range util.RemoveSameItemInSlice(filterList) {
+			if util.IsItemInList(dir, v.SubscribeList) || !isPathCovered(dir, v.SubscribeList) ||
+				!util.IsDir(filepath.Join(rootDir, dir)) {
+				continue
+			}
+			list = append(list, trialMount{lower: filepath.Join(rootDir, dir), target: filepath.Join(c.rootMP, dir)})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return strings.Count(list[i].target, "/") < strings.Count(list[j].target, "/")
+	})
+	return list
+}
+
+// Try boots the version without rolling back, runs in initramfs. Every subscribed dir is replaced by
+// the one of the snapshot, the files only in the current system aren't seen. The changes are kept
+// in memory by the overlays and lost on reboot
+func (c *Upgrader) Try(version string) (int, error) {
+	exitCode := _STATE_TY_SUCCESS
+	var mounts []trialMount
+	var mounted []string
+	var rootDir, upperDir, workDir string
+	var out []byte
+	var err error
+	if len(c.rootMP) == 1 {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		err = errors.New("the version can only be tried in initramfs")
+		goto failure
+	}
+	if len(version) == 0 || !c.IsExistVersion(version) {
+		exitCode = _STATE_TY_FAILED_NO_VERSION
+		err = errors.New("version does not exist")
+		goto failure
+	}
+	// the snapshot dir is consumed by the rollback, checkout again
+	logger.Info("checkout the version to try:", version)
+	err = c.Snapshot(version)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_OSTREE_ROLLBACK
+		goto failure
+	}
+
+	rootDir = filepath.Join(TrialRunDir, trialRootDir)
+	upperDir = filepath.Join(TrialRunDir, trialUpperDir)
+	workDir = filepath.Join(TrialRunDir, trialWorkDir)
+	for _, dir := range []string{rootDir, upperDir, workDir} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+			goto failure
+		}
+	}
+	// the current root is never written
+	out, err = exec.Command("mount", "--bind", c.rootMP, rootDir).CombinedOutput()
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		err = fmt.Errorf("%v: %s", err, string(out))
+		goto failure
+	}
+	out, err = exec.Command("mount", "-o", "remount,bind,ro", rootDir).CombinedOutput()
+	if err != nil {
+		logger.Warningf("failed remount %s read-only, err: %v, %s", rootDir, err, string(out))
+	}
+	mounts = c.trialMounts(version, rootDir)
+	for i, v := range mounts {
+		upper := filepath.Join(upperDir, strconv.Itoa(i))
+		work := filepath.Join(workDir, strconv.Itoa(i))
+		for _, dir := range []string{upper, work, v.target} {
+			err = os.MkdirAll(dir, 0755)
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			out, err = exec.Command("mount", "-t", "overlay", "overlay", "-o",
+				fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", v.lower, upper, work), v.target).CombinedOutput()
+			if err != nil {
+				err = fmt.Errorf("%v: %s", err, string(out))
+			}
+		}
+		if err != nil {
+			for j := len(mounted) - 1; j >= 0; j-- {
+				_ = exec.Command("umount", mounted[j]).Run()
+			}
+			_ = exec.Command("umount", rootDir).Run()
+			exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+			goto failure
+		}
+		mounted = append(mounted, v.target)
+	}
+	err = ioutil.WriteFile(filepath.Join(TrialRunDir, trialVersionFile), []byte(version), 0644)
+	if err != nil {
+		logger.Warning("failed save the trial version, err:", err)
+	}
+	logger.Infof("try the version %s, mounts: %v", version, mounts)
+	return int(exitCode), nil
+failure:
+	return int(exitCode), err
+}
+
+// rollbackAfterTrial boots the rollback entry of the version once, the trial system
+// is in memory, only the real /boot or the efi variables keep the choice
+func (c *Upgrader) rollbackAfterTrial(version string) error {
+	// systemd-boot keeps the choice in the efi variables
+	if bootkitinfo.Bootloader() == bootkitconfig.BootloaderSystemdBoot {
+		logger.Infof("roll back to %s on the next boot", version)
+		return setNextBoot("/", version)
+	}
+	bootRoot := filepath.Join(TrialRunDir, trialRootDir)
+	// the separate boot partition is mounted in the trial system
+	if c.mountInfos.Match("/boot") != nil {
+		bootRoot = "/"
+	}
+	if bootRoot != "/" {
+		// the bind of the real root is read-only
+		out, err := exec.Command("mount", "-o", "remount,bind,rw", bootRoot).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, string(out))
+		}
+		defer func() {
+			_ = exec.Command("mount", "-o", "remount,bind,ro", bootRoot).Run()
+		}()
+	}
+	logger.Infof("roll back to %s on the next boot", version)
+	return setNextBoot(bootRoot, version)
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/repo/branch"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// commitUndo commits the current system as the pre-rollback version before it is replaced by the version
+func (c *Upgrader) commitUndo(backVersion string) (string, error) {
+	if c.conf.LoadVersionUndo(backVersion, c.rootMP) != nil {
+		logger.Infof("%s is a pre-rollback version, no need to commit the current system", backVersion)
+		return "", nil
+	}
+	last, err := c.lastVersion()
+	if err != nil {
+		return "", err
+	}
+	version, err := branch.Increment(last)
+	if err != nil {
+		return "", err
+	}
+	logger.Infof("commit the current system as %s before rolling back to %s", version, backVersion)
+	subject := fmt.Sprintf("Pre-rollback to %s", backVersion)
+	c.conf.SetVersionConfig(version)
+	err = c.commitRepos(version, subject, true, nil)
+	if err != nil {
+		return "", err
+	}
+	c.captureCmdline(version)
+	c.inheritBootGood(version)
+	c.captureAttrs(version, true)
+	err = c.conf.SetVersionUndo(version, c.rootMP, &config.UndoInfo{
+		ReplacedBy: backVersion,
+		Time:       time.Now().Unix(),
+		Paths:      c.rollbackPaths,
+	})
+	if err != nil {
+		return "", err
+	}
+	c.recordsInfo.SetUndoVersion(version)
+	return version, nil
+}
+
+// UndoVersion returns the newest pre-rollback version replaced by the active version
+func (c *Upgrader) UndoVersion() string {
+	list, _, err := c.ListVersion()
+	if err != nil {
+		return ""
+	}
+	for _, v := range list {
+		info := c.conf.LoadVersionUndo(v, c.rootMP)
+		if info != nil && info.ReplacedBy == c.conf.ActiveVersion {
+			return v
+		}
+	}
+	return ""
+}
+
+// RollForward undoes the last rollback, returns to the system committed before it
+func (c *Upgrader) RollForward(evHandler func(op, state int32, target, desc string)) (int, error) {
+	version := c.UndoVersion()
+	if len(version) == 0 {
+		return int(_STATE_TY_FAILED_NO_VERSION), errors.New("no rollback to undo")
+	}
+	// only the paths replaced by the rollback need to return
+	c.rollbackPaths = c.conf.LoadVersionUndo(version, c.rootMP).Paths
+	logger.Infof("roll forward to %s, paths: %v", version, c.rollbackPaths)
+	return c.Rollback(version, evHandler)
+}
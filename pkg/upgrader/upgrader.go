@@ -6,6 +6,7 @@ package upgrader
 
 import (
 	"bufio"
+	bootkitconfig "deepin-upgrade-manager/pkg/config/bootkit"
 	config "deepin-upgrade-manager/pkg/config/upgrader"
 	"deepin-upgrade-manager/pkg/logger"
 	"deepin-upgrade-manager/pkg/module/bootkitinfo"
@@ -13,7 +14,7 @@ import (
 	"deepin-upgrade-manager/pkg/module/dirinfo"
 	"deepin-upgrade-manager/pkg/module/fstabinfo"
 	"deepin-upgrade-manager/pkg/module/generator"
-	"deepin-upgrade-manager/pkg/module/grub"
+	"deepin-upgrade-manager/pkg/module/journal"
 	"deepin-upgrade-manager/pkg/module/langselector"
 	"deepin-upgrade-manager/pkg/module/mountinfo"
 	"deepin-upgrade-manager/pkg/module/mountpoint"
@@ -28,7 +29,6 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -39,7 +39,10 @@ import (
 
 var msgSuccessRollBack = util.Tr("Your system is successfully rolled back to %s.")
 var msgFailRollBack = util.Tr("Rollback failed. The system is reverted to %s.")
-var msgRollBack = util.Tr("System Recovery")
+var msgAutoRollBack = util.Tr("Your system failed to start several times and is automatically rolled back to %s.")
+var msgRollBackMismatch = util.Tr("%d files differ from the version restored, see %s for details.")
+var msgRollBackEtcConflict = util.Tr("%d local changes in /etc conflict with the version restored, see %s for details.")
+var msgUnconfirmed = util.Tr("[unconfirmed]")
 
 type (
 	opType    int32
@@ -68,6 +71,7 @@ const (
 	_OP_TY_ROLLBACK_PREPARING_START opType = iota*10 + 200
 	_OP_TY_ROLLBACK_PREPARING_SET_CONFIG
 	_OP_TY_ROLLBACK_PREPARING_SET_WAITTIME
+	_OP_TY_ROLLBACK_PREPARING_COMMIT_UNDO
 	_OP_TY_ROLLBACK_PREPARING_END opType = 299
 )
 
@@ -97,6 +101,10 @@ const (
 	_STATE_TY_FAILED_NO_VERSION
 	_STATE_TY_FAILED_EXIT_SIGNAL
 	_STATE_TY_FAILED_UPDATE_INITRD
+	_STATE_TY_FAILED_BOOT_CHECK
+	_STATE_TY_FAILED_RESTORE_FILE
+	_STATE_TY_FAILED_RUN_COMMAND
+	_STATE_TY_FAILED_INVALID_ARGS
 	_STATE_TY_RUNING stateType = 1
 )
 
@@ -136,6 +144,14 @@ func (state stateType) String() string {
 		return "version does not exist"
 	case _STATE_TY_FAILED_EXIT_SIGNAL:
 		return "receiving kill signal		"
+	case _STATE_TY_FAILED_BOOT_CHECK:
+		return "boot check failed"
+	case _STATE_TY_FAILED_RESTORE_FILE:
+		return "failed restore file"
+	case _STATE_TY_FAILED_RUN_COMMAND:
+		return "failed run command"
+	case _STATE_TY_FAILED_INVALID_ARGS:
+		return "invalid arguments"
 	}
 	return "unknown"
 }
@@ -160,6 +176,8 @@ func (op opType) String() string {
 		return "start set preparing rollback configuration file"
 	case _OP_TY_ROLLBACK_PREPARING_SET_WAITTIME:
 		return "start set the grub waiting time "
+	case _OP_TY_ROLLBACK_PREPARING_COMMIT_UNDO:
+		return "start to submit the pre-rollback version"
 	case _OP_TY_ROLLBACK_PREPARING_END:
 		return "end preparing rollback"
 	case _OP_TY_DELETE_START:
@@ -179,11 +197,16 @@ type Upgrader struct {
 
 	recordsInfo *records.RecordsInfo
 
+	journal *journal.Journal
+
 	fsInfo fstabinfo.FsInfoList
 
 	repoSet map[string]repo.Repository
 
 	rootMP string
+
+	autoRollback  bool
+	rollbackPaths []string
 }
 
 func NewUpgraderTool() *Upgrader {
@@ -347,7 +370,7 @@ func (c *Upgrader) Commit(newVersion, subject string, useSysData bool,
 	evHandler func(op, state int32, target, desc string)) (excode int, err error) {
 	exitCode := _STATE_TY_SUCCESS
 	var isClean bool
-	var theme string
+	var theme, goodVersion string
 	c.SendingSignal(evHandler, _OP_TY_COMMIT_START, _STATE_TY_RUNING, newVersion, "")
 
 	if len(newVersion) == 0 {
@@ -375,15 +398,16 @@ func (c *Upgrader) Commit(newVersion, subject string, useSysData bool,
 	if err != nil {
 		logger.Warning("failed to set plymouth theme:", err)
 	}
-	for _, v := range c.conf.RepoList {
-		err = c.repoCommit(v, newVersion, subject, useSysData, evHandler)
-		if err != nil {
-			exitCode = _STATE_TY_FAILED_OSTREE_COMMIT
-			goto failure
-		}
+	err = c.commitRepos(newVersion, subject, useSysData, evHandler)
+	if err != nil {
+		exitCode = _STATE_TY_FAILED_OSTREE_COMMIT
+		goto failure
 	}
 
 	c.SaveActiveVersion(newVersion)
+	c.captureCmdline(newVersion)
+	c.inheritBootGood(newVersion)
+	c.captureAttrs(newVersion, useSysData)
 
 	// automatically clear redundant versions
 	if c.IsAutoClean() {
@@ -402,6 +426,17 @@ func (c *Upgrader) Commit(newVersion, subject string, useSysData bool,
 			goto failure
 		}
 	}
+	// the upgrade follows the commit, roll back to the last good version if the system can't boot,
+	// armed after the entries are updated, the rollback entry of the version is copied for systemd-boot
+	goodVersion = c.GoodVersion(newVersion)
+	if len(goodVersion) == 0 {
+		goodVersion = newVersion
+	}
+	err = c.ArmBootCounter(goodVersion)
+	if err != nil {
+		logger.Warning("failed arm the boot counter, err:", err)
+	}
+
 	err = restorePlymouthTheme()
 	if err != nil {
 		logger.Warning("failed to restore plymouth theme:", err)
@@ -437,6 +472,16 @@ func (c *Upgrader) IsExistRepo() bool {
 
 func (c *Upgrader) UpdateGrub() (stateType, error) {
 	exitCode := _STATE_TY_SUCCESS
+	// the entries of the other bootloaders are written by deepin-boot-kit
+	if bootloader := bootkitinfo.Bootloader(); bootloader != bootkitconfig.BootloaderGrub {
+		logger.Infof("start update the %s entries", bootloader)
+		out, err := exec.Command(bootkitinfo.DEEPIN_BOOT_KIT, "--action=update").CombinedOutput()
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_UPDATE_GRUB
+			return exitCode, fmt.Errorf("%v: %s", err, string(out))
+		}
+		return exitCode, nil
+	}
 	logger.Info("start update grub")
 	cmd := exec.Command("update-grub")
 	cmd.Env = append(cmd.Env, langselector.LocalLangEnv()...)
@@ -454,40 +499,18 @@ func (c *Upgrader) UpdateGrub() (stateType, error) {
 	return exitCode, nil
 }
 
-func (c *Upgrader) EnableBoot(version string) (stateType, error) {
-	exitCode := _STATE_TY_SUCCESS
-	err := c.Snapshot(version)
-	if err != nil {
-		exitCode = _STATE_TY_FAILED_NO_REPO
-		return exitCode, err
-	}
-	for _, v := range c.conf.RepoList {
-		dataDir := filepath.Join(c.rootMP, v.SnapshotDir, version)
-		err := c.enableSnapshotBoot(dataDir, version)
-		if err != nil {
-			exitCode = _STATE_TY_FAILED_NO_REPO
-			return exitCode, err
-		}
-	}
-	return exitCode, nil
-}
-
 func (c Upgrader) GrubTitle(version string) string {
 	var title, titleTail string
-	target := filepath.Join(c.rootMP, c.conf.RepoList[0].SnapshotDir, version)
-	if !util.IsExists(target) {
-		c.EnableBoot(version)
-	}
-	systemName, err := util.GetOSInfo(target, "SystemName")
+	c.EnableBoot(version)
+	systemName, err := c.versionOSInfo(version, "SystemName")
 	if err != nil {
 		logger.Warning("failed get system name, err:", err)
 	}
-	MinorVersion, err := util.GetOSInfo(target, "MinorVersion")
+	MinorVersion, err := c.versionOSInfo(version, "MinorVersion")
 	if err != nil {
 		logger.Warning("failed get minor version, err:", err)
 	}
-	handler, _ := repo.NewRepo(repo.REPO_TY_OSTREE,
-		filepath.Join(c.rootMP, c.conf.RepoList[0].Repo))
+	handler, _ := c.repoHandler(c.conf.RepoList[0])
 	content, err := handler.Subject(version)
 	if err == nil {
 		sub, err := config.LoadSubject(content)
@@ -515,6 +538,11 @@ func (c Upgrader) GrubTitle(version string) string {
 	} else {
 		title = systemName + " " + MinorVersion + " " + "(" + titleTail + ")"
 	}
+	// never confirmed bootable by 'mark-good'
+	if !c.conf.IsVersionGood(version, c.rootMP) {
+		mark, _ := util.GetUpgradeText(msgUnconfirmed, langselector.LocalLangEnv())
+		title += " " + mark
+	}
 	return title
 }
 
@@ -524,10 +552,6 @@ func (c *Upgrader) EnableBootList() (string, int, error) {
 	if err != nil {
 		return "", exitCode, err
 	}
-	bootSnapDir := filepath.Join(c.rootMP, "/boot/snapshot")
-	if util.IsExists(bootSnapDir) {
-		os.RemoveAll(bootSnapDir)
-	}
 	var showList []string
 	osVersion, err := util.GetOSInfo("", "MajorVersion")
 	if nil != err {
@@ -549,23 +573,31 @@ func (c *Upgrader) EnableBootList() (string, int, error) {
 		}
 	}
 
+	c.cleanupBootSnapshot(showList)
+	c.GC(false, nil)
+
 	diskInfo := c.fsInfo.MatchDestPoint(c.conf.RepoList[0].RepoMountPoint)
 	listInfo := bootkitinfo.Load(showList, diskInfo.DiskUUID)
+	current := bootCmdline()
 	for _, v := range showList {
 		commitName := c.GrubTitle(v)
 		listInfo.SetVersionName(v, commitName)
+		add, remove := c.cmdlineOverrides(v, current)
+		listInfo.SetVersionCmdline(v, add, remove)
 	}
+	c.verifySecureBoot(&listInfo)
 
 	return listInfo.ToJson(), exitCode, nil
 }
 
 func (c *Upgrader) Snapshot(version string) error {
-	for _, v := range c.conf.RepoList {
+	for _, v := range c.versionRepos(version) {
 		err := c.repoSnapShot(v, version)
 		if err != nil {
 			return err
 		}
 	}
+	c.GC(false, []string{version})
 	return nil
 }
 
@@ -628,12 +660,29 @@ func (c *Upgrader) getRollbackInfo(version, rootdir string) (string, bool, error
 func (c *Upgrader) Rollback(version string,
 	evHandler func(op, state int32, target, desc string)) (excode int, err error) {
 	exitCode := _STATE_TY_SUCCESS
+	var backVersion string
+	var isCanRollback bool
+	var mountedPointList mountpoint.MountPointList
+	var esp *espRestore
 	c.SendingSignal(evHandler, _OP_TY_ROLLBACK_PREPARING_START, _STATE_TY_RUNING, version, "")
 
+	// commit to the rollback of the version tried
+	if len(version) == 0 && len(c.rootMP) == 1 {
+		version = TrialVersion()
+	}
 	c.LoadRollbackRecords(true)
 	logger.Debugf("status code for the current state file, %v", c.recordsInfo.CurrentState)
 	c.SendingSignal(evHandler, _OP_TY_ROLLBACK_PREPARING_SET_CONFIG, _STATE_TY_RUNING, version, "")
-	backVersion, isCanRollback, err := c.getRollbackInfo(version, c.rootMP)
+	// only the partition of the first repo is mounted in initramfs, the others are mounted before
+	// the version is checked in them
+	if len(c.rootMP) != 1 {
+		mountedPointList, err = c.mountRepos()
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+			goto failure
+		}
+	}
+	backVersion, isCanRollback, err = c.getRollbackInfo(version, c.rootMP)
 	if err != nil {
 		exitCode = _STATE_TY_FAILED_NO_REPO
 		goto failure
@@ -641,11 +690,31 @@ func (c *Upgrader) Rollback(version string,
 	if isCanRollback && len(backVersion) != 0 {
 		c.UpdateProgress(0)
 		logger.Infof("start rollback a old version: %s, state: %v.", backVersion, c.recordsInfo.CurrentState)
-		var mountedPointList mountpoint.MountPointList
+		repos := c.versionRepos(backVersion)
 
 		if len(c.recordsInfo.RollbackVersion) == 0 {
 			c.recordsInfo.Reset(backVersion)
 		}
+		if c.autoRollback {
+			logger.Info("the rollback is selected automatically after repeated failed boots")
+			c.recordsInfo.SetAutomatic()
+		}
+		// the paths selected when preparing the rollback
+		if len(c.rollbackPaths) != 0 {
+			c.recordsInfo.SetRollbackPaths(c.rollbackPaths)
+		} else {
+			c.rollbackPaths = c.recordsInfo.Paths(backVersion)
+		}
+		// keep the system replaced by the rollback, unless it's committed when preparing in the system
+		if c.recordsInfo.IsReady() && len(c.recordsInfo.Undo(backVersion)) == 0 && !c.recordsInfo.NoUndo {
+			_, err = c.commitUndo(backVersion)
+			if err != nil {
+				// the rollback goes on, but the system replaced couldn't be returned to
+				logger.Warning("failed commit the pre-rollback version, the rollback can't be undone, err:", err)
+				c.recordsInfo.SetNoUndo()
+				err = nil
+			}
+		}
 		// checkout specified version file
 		err = c.Snapshot(backVersion)
 		if err != nil {
@@ -655,30 +724,61 @@ func (c *Upgrader) Rollback(version string,
 
 		c.UpdateProgress(20)
 		// need load rollback version config
-		err := c.conf.LoadVersionData(backVersion, c.rootMP)
+		err = c.conf.LoadVersionData(backVersion, c.rootMP)
 		if err != nil {
 			exitCode = _STATE_TY_FAILED_OSTREE_ROLLBACK
 			goto failure
 		}
-		c.recordsInfo.SetAfterRun(c.conf.RepoList[0].AfterRun)
-		// update the mount of the first repo
-		mountedPointList, err = c.UpdateMount(c.conf.RepoList[0], backVersion)
-		if err != nil {
-			exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+		var afterRuns []string
+		for _, v := range repos {
+			if len(v.AfterRun) != 0 && !util.IsItemInList(v.AfterRun, afterRuns) {
+				afterRuns = append(afterRuns, v.AfterRun)
+			}
+		}
+		c.recordsInfo.SetAfterRun(strings.Join(afterRuns, records.AfterRunSep))
+		// update the mounts by the fstab of the version, the other repos not holding it are skipped
+		for i, v := range repos {
+			if i != 0 && !util.IsExists(filepath.Join(c.rootMP, v.SnapshotDir, backVersion, "/etc/fstab")) {
+				continue
+			}
+			var localMountedList mountpoint.MountPointList
+			localMountedList, err = c.UpdateMount(v, backVersion)
+			mountedPointList = append(mountedPointList, localMountedList...)
 			if err != nil {
+				exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
 				logger.Warning(err)
+				goto failure
 			}
-			goto failure
 		}
 		c.UpdateProgress(30)
-		// rollback system files
-		for _, v := range c.conf.RepoList {
-			err = c.repoRollback(v, backVersion)
+		c.forgetSnapshot(backVersion)
+		c.loadJournal(backVersion)
+		c.recordsInfo.SetMismatches(nil)
+		// the old entries of the EFI System Partition are kept until the dirs are rolled back
+		if c.conf.IncludeESP {
+			esp, err = c.restoreESP(backVersion)
 			if err != nil {
+				logger.Error("failed restore the EFI System Partition, err:", err)
 				exitCode = _STATE_TY_FAILED_OSTREE_ROLLBACK
 				goto failure
 			}
 		}
+		// rollback system files
+		err = c.reposRollback(backVersion)
+		if err != nil {
+			if esp != nil {
+				if err := esp.revert(); err != nil {
+					logger.Error("failed revert the EFI System Partition, err:", err)
+				}
+			}
+			exitCode = _STATE_TY_FAILED_OSTREE_ROLLBACK
+			goto failure
+		}
+		if esp != nil {
+			esp.finish()
+		}
+		// the old dirs are removed, the flags of the version don't block it
+		c.restoreAttrs(backVersion)
 		// before umount the partiton operations
 		err = c.AfterRollbackOper(backVersion, true)
 		if err != nil {
@@ -700,7 +800,21 @@ func (c *Upgrader) Rollback(version string,
 			}
 		}
 		c.UpdateProgress(100)
+	} else if len(TrialVersion()) != 0 {
+		// the system is tried in memory, the rollback is done on the next boot
+		err = c.rollbackAfterTrial(backVersion)
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_UPDATE_GRUB
+			goto failure
+		}
 	} else {
+		c.SendingSignal(evHandler, _OP_TY_ROLLBACK_PREPARING_COMMIT_UNDO, _STATE_TY_RUNING, version, "")
+		// keep the system replaced by the rollback, rolling forward returns to it
+		_, err = c.commitUndo(backVersion)
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_OSTREE_COMMIT
+			goto failure
+		}
 		c.SendingSignal(evHandler, _OP_TY_ROLLBACK_PREPARING_SET_WAITTIME, _STATE_TY_RUNING, version, "")
 		out, err := exec.Command("/usr/sbin/deepin-boot-kit", "--action=mkinitrd").CombinedOutput()
 		if err != nil {
@@ -709,32 +823,20 @@ func (c *Upgrader) Rollback(version string,
 			goto failure
 		}
 		if len(c.rootMP) == 1 {
-			grubManager, _ := grub.LoadGrubParams()
-			newTitle := c.GrubTitle(backVersion)
-			newHead, _ := util.GetBootKitText(msgRollBack, langselector.LocalLangEnv())
-			newDef := newHead + ">" + newTitle
-			oldT, err := grubManager.TimeOut()
-			if err != nil {
-				oldT = 2
-			}
-			oldDf, err := grubManager.GrubDefault()
-			if err != nil {
-				oldDf = "0"
-			}
-			//Before setting the configuration needs to be saved
-			c.recordsInfo.SetRollbackInfo(backVersion, oldDf, newHead, oldT)
-			err = grubManager.SetTimeOut(0)
+			c.recordsInfo.SetRollbackPaths(c.rollbackPaths)
+			c.recordsInfo.SetRollbackInfo(backVersion)
+			// regenerate the rollback entries with the initrd updated
+			exitCode, err = c.UpdateGrub()
 			if err != nil {
 				exitCode = _STATE_TY_FAILED_UPDATE_GRUB
 				goto failure
 			}
-			grubManager.SetGrubDefault(newDef)
-			exitCode, err = c.UpdateGrub()
+			err = setNextBoot(c.rootMP, backVersion)
 			if err != nil {
 				exitCode = _STATE_TY_FAILED_UPDATE_GRUB
 				goto failure
 			}
-			logger.Infof("Success to set the default rollback configuratio, timeout 1, default grub %s", newDef)
+			logger.Infof("Success to set the rollback entry %s booted once", rollbackEntry(backVersion))
 		}
 		logger.Info("start set rollback a old version:", backVersion)
 	}
@@ -744,12 +846,17 @@ func (c *Upgrader) Rollback(version string,
 failure:
 	//failed mount -2 < 0, running must in initramfs
 	if int(exitCode) < int(_STATE_TY_FAILED_NO_REPO) && len(c.rootMP) != 1 {
-		err = c.AfterRollbackOper(backVersion, false)
-		if err != nil {
-			logger.Warning("failed run after rollback operation, err:", err)
+		e := c.AfterRollbackOper(backVersion, false)
+		if e != nil {
+			logger.Warning("failed run after rollback operation, err:", e)
 		}
 		c.UpdateProgress(100)
 	}
+	if len(c.rootMP) != 1 {
+		if e := mountedPointList.Umount(); e != nil {
+			logger.Warning("failed restore system mount, err:", e)
+		}
+	}
 	c.SendingSignal(evHandler, _OP_TY_ROLLBACK_PREPARING_END, exitCode, version, err.Error())
 	return int(exitCode), err
 }
@@ -787,13 +894,19 @@ func (c *Upgrader) repoCommit(repoConf *config.RepoConfig, newVersion, subject s
 			return err
 		}
 		// need handle filter dirs
-		repoConf.FilterList = append(repoConf.FilterList, c.getFilterList(repoConf.FilterList, repoConf.SubscribeList)...)
+		repoConf.FilterList = append(repoConf.FilterList, c.getFilterList(c.fsInfo, repoConf.FilterList, repoConf.SubscribeList)...)
 		repoConf.FilterList = util.RemoveSameItemInSlice(repoConf.FilterList)
 		dataDir = filepath.Join(c.rootMP, c.conf.CacheDir, c.conf.Distribution)
 		err = c.copyRepoData(c.rootMP, dataDir, repoConf.SubscribeList, repoConf.FilterList)
 		if err != nil {
 			return err
 		}
+		if c.conf.IncludeESP && repoConf == c.conf.RepoList[0] {
+			err = c.copyESPData(dataDir)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	c.SendingSignal(evHandler, _OP_TY_COMMIT_REPO_SUBMIT, _STATE_TY_RUNING, newVersion, "")
 	logger.Debugf("will submitted version to the repo, version:%s, sub:%s, dataDir:%s", newVersion, subject, dataDir)
@@ -804,12 +917,12 @@ func (c *Upgrader) repoCommit(repoConf *config.RepoConfig, newVersion, subject s
 	return nil
 }
 
-func (c *Upgrader) getFilterList(filterlist, sublist []string) []string {
+func (c *Upgrader) getFilterList(fsInfo fstabinfo.FsInfoList, filterlist, sublist []string) []string {
 	var filterList []string
 	if util.IsItemInList("/", sublist) {
 		filterList = append(filterList, util.FullNeedFilters()...)
 	}
-	for _, fs := range c.fsInfo {
+	for _, fs := range fsInfo {
 		for _, filter := range filterlist {
 			// ex: '/pesistent/home /home' '/pesistent/home/uos/A'
 			// ex: '/pesistent/home /home' '/home/uos/A'
@@ -834,6 +947,10 @@ func (c *Upgrader) getFilterList(filterlist, sublist []string) []string {
 			}
 		}
 	}
+	// the EFI System Partition is committed and restored by itself
+	if esp := c.espMountPoint(); len(esp) != 0 && isPathCovered(esp, sublist) {
+		filterList = append(filterList, esp)
+	}
 	filtersMountInfo, err := mountinfo.GetFilterInfo(SelfMountPath)
 	if err == nil {
 		for _, sub := range sublist {
@@ -848,60 +965,6 @@ func (c *Upgrader) getFilterList(filterlist, sublist []string) []string {
 	return filterList
 }
 
-func (c *Upgrader) repoSnapShot(repoConf *config.RepoConfig, version string) error {
-	handler := c.repoSet[repoConf.Repo]
-	dataDir := filepath.Join(c.rootMP, repoConf.SnapshotDir, version)
-	_ = os.RemoveAll(dataDir)
-	return handler.Snapshot(version, dataDir)
-}
-
-func (c *Upgrader) enableSnapshotBoot(snapDir, version string) error {
-	bootDir := filepath.Join(snapDir, "boot")
-	fiList, err := ioutil.ReadDir(bootDir)
-	if err != nil {
-		return err
-	}
-	dstDir := filepath.Join(c.rootMP, "boot/snapshot", version)
-	localBootDir := filepath.Join(c.rootMP, "/boot")
-	err = os.MkdirAll(dstDir, 0700)
-	if err != nil {
-		return err
-	}
-
-	found := false
-	for _, fi := range fiList {
-		if fi.IsDir() {
-			continue
-		}
-		if strings.HasPrefix(fi.Name(), "vmlinuz-") ||
-			strings.HasPrefix(fi.Name(), "kernel-") ||
-			strings.HasPrefix(fi.Name(), "vmlinux-") ||
-			strings.HasPrefix(fi.Name(), "initrd.img-") {
-
-			snapFile := filepath.Join(bootDir, fi.Name())
-			localFile := filepath.Join(localBootDir, fi.Name())
-			dstFile := filepath.Join(dstDir, fi.Name())
-			isSame, err := util.IsFileSame(localFile, snapFile)
-			if isSame && err == nil {
-				// create file hard link
-				err = util.CopyFile(localFile, dstFile, true)
-			} else {
-				err = util.CopyFile(snapFile, dstFile, false)
-			}
-
-			if err != nil {
-				_ = os.Remove(dstDir)
-				return err
-			}
-			found = true
-		}
-	}
-	if !found {
-		_ = os.Remove(dstDir)
-	}
-	return nil
-}
-
 // @title    handleRepoRollbak
 // @description   handling files on rollback
 // @param     realDir         	string         		"original system file path, ex:/etc"
@@ -974,160 +1037,188 @@ func (c *Upgrader) isSameFilterPartDir(filterdir string, subscribeList []string)
 	return true
 }
 
-func (c *Upgrader) repoRollback(repoConf *config.RepoConfig, version string) error {
-	var FilterPartMountedList, rollbackDirList []string
-	repoConf.FilterList = append(repoConf.FilterList, c.getFilterList(repoConf.FilterList, repoConf.SubscribeList)...)
-	repoConf.FilterList = util.RemoveSameItemInSlice(repoConf.FilterList)
-	logger.Debugf("need filter dir list %v", repoConf.FilterList)
-	for _, v := range repoConf.FilterList {
+func (c *Upgrader) getFilterPartMountedList(fsInfo fstabinfo.FsInfoList, filterList, subscribeList []string) []string {
+	var list []string
+	for _, v := range filterList {
 		//to determine whether filter dirs is in fstab
-		if !c.fsInfo.IsInFstabPoint(c.rootMP, v) {
+		if !fsInfo.IsInFstabPoint(c.rootMP, v) {
 			continue
 		}
 		//to determine whether a rollback partition and filter partitions are the same
-		if c.isSameFilterPartDir(v, repoConf.SubscribeList) {
+		if c.isSameFilterPartDir(v, subscribeList) {
 			continue
 		}
-		if util.IsItemInList(v, FilterPartMountedList) {
+		if util.IsItemInList(v, list) {
 			continue
 		}
-		FilterPartMountedList = append(FilterPartMountedList, filepath.Join(c.rootMP, v))
+		list = append(list, filepath.Join(c.rootMP, v))
 	}
+	return list
+}
+
+// repoRollbackPlan is the rollback of the subscribed dirs of a repo, the dirs replaced are kept until
+// all the repos are rolled back, then cleaned up or recovered together
+type repoRollbackPlan struct {
+	repoConf          *config.RepoConfig
+	snapDir           string
+	subscribeList     []string
+	realDirList       []string
+	realFileList      []string
+	filterMountedList []string
+	ops               *rollbackOps
+}
+
+func (c *Upgrader) planRepoRollback(repoConf *config.RepoConfig, version string) *repoRollbackPlan {
+	repoConf.FilterList = append(repoConf.FilterList, c.getFilterList(c.fsInfo, repoConf.FilterList, repoConf.SubscribeList)...)
+	repoConf.FilterList = util.RemoveSameItemInSlice(repoConf.FilterList)
+	logger.Debugf("need filter dir list %v", repoConf.FilterList)
+	FilterPartMountedList := c.getFilterPartMountedList(c.fsInfo, repoConf.FilterList, repoConf.SubscribeList)
 	logger.Debugf("need filter part mount list %v", FilterPartMountedList)
 
 	snapDir := filepath.Join(repoConf.SnapshotDir, version)
-	realDirSubscribeList, realFileSubcribeList := util.GetRealDirList(repoConf.SubscribeList, c.rootMP, snapDir)
+	subscribeList := selectRollbackPaths(repoConf.SubscribeList, c.rollbackPaths)
+	realDirSubscribeList, realFileSubcribeList := util.GetRealDirList(subscribeList, c.rootMP, snapDir)
 	logger.Debugf("will recovery dirs %v, files %v", realDirSubscribeList, realFileSubcribeList)
-	var err error
-	defer func() {
-		c.UpdateProgress(70)
-		// if failed update, restoring the system
-		if err != nil || c.recordsInfo.IsRestore() {
-			c.recordsInfo.SetRestore()
-			logger.Warning("failed rollback, recover rollback action")
-			for _, dir := range realDirSubscribeList {
-				err := c.handleRepoRollbak(dir, snapDir, version, FilterPartMountedList, &rollbackDirList, util.HandlerDirRecover)
-				if err != nil {
-					logger.Error("failed recover rollback, err:", err)
-				}
-			}
-			if err == nil {
-				err = errors.New("failed rollback dir")
-			}
+	return &repoRollbackPlan{
+		repoConf:          repoConf,
+		snapDir:           snapDir,
+		subscribeList:     subscribeList,
+		realDirList:       realDirSubscribeList,
+		realFileList:      realFileSubcribeList,
+		filterMountedList: FilterPartMountedList,
+		ops: &rollbackOps{
+			c:                 c,
+			repoConf:          repoConf,
+			snapDir:           snapDir,
+			version:           version,
+			filterMountedList: FilterPartMountedList,
+		},
+	}
+}
+
+// repoRollback replaces the subscribed dirs and files of the repo by the version
+func (c *Upgrader) repoRollback(plan *repoRollbackPlan) error {
+	if !c.recordsInfo.IsNeedMainRunning() {
+		return nil
+	}
+	c.UpdateProgress(40)
+	// prepare the repo file under the system path, hardlink the filtered files to it, then replace
+	// the system files, every step is journaled. Last replace /boot dir, protect system boot
+	err := c.journal.Run(bootDirLast(c.rootMP, plan.realDirList), plan.ops)
+	if err != nil {
+		return err
+	}
+	c.UpdateProgress(60)
+
+	// replace file is fast
+	for _, v := range plan.realFileList {
+		realFile := util.TrimRootdir(c.rootMP, v)
+		snapFile := filepath.Join(plan.snapDir, realFile)
+		logger.Debugf("start rolling back file, realfile:%s, snapFile:%s",
+			realFile, snapFile)
+		_, err := util.UnsetFileAttr(filepath.Join(c.rootMP, realFile), util.LockAttrs)
+		if err != nil {
+			logger.Debugf("failed unset the attr of %s, err: %v", realFile, err)
 		}
-		logger.Debug("need to be deleted tmp dirs:", rollbackDirList)
-		// remove all tmp dir and compatible rollback
-		for _, v := range rollbackDirList {
-			oldDir := filepath.Join(path.Dir(v), string("/.old")+version)
-			newDir := filepath.Join(path.Dir(v), string("/.")+version)
-			if util.IsExists(oldDir) {
-				err = os.RemoveAll(oldDir)
-				if err != nil {
-					// When failure to delete extended attributes 'i' delete again
-					util.RemoveDirAttr(oldDir)
-					err = os.RemoveAll(oldDir)
-					if err != nil {
-						logger.Warning("failed remove dir, err:", err)
-					}
-				}
-			}
-			if util.IsExists(newDir) {
-				err = os.RemoveAll(newDir)
-				if err != nil {
-					// When failure to delete extended attributes 'i' delete again
-					util.RemoveDirAttr(newDir)
-					err = os.RemoveAll(newDir)
-					if err != nil {
-						logger.Warning("failed remove dir, err:", err)
-					}
-				}
-			}
+		err = util.CopyFile(filepath.Join(c.rootMP, snapFile), filepath.Join(c.rootMP, realFile), false)
+		if err != nil {
+			return err
 		}
-	}()
-	if c.recordsInfo.IsNeedMainRunning() {
-		// prepare the repo file under the system path
-		for _, dir := range realDirSubscribeList {
-			err = c.handleRepoRollbak(dir, snapDir, version, FilterPartMountedList, &rollbackDirList, util.HandlerDirPrepare)
-			if err != nil {
-				return err
+	}
+	// compare the restored paths with the version before the old dirs are cleaned up, still revertible
+	if mode := c.conf.VerifyRollbackMode(); mode != config.VerifyRollbackOff {
+		version := plan.ops.version
+		mismatches := c.verifyRollback(plan.repoConf, version, plan.subscribeList)
+		c.recordsInfo.SetMismatches(append(c.recordsInfo.Mismatches, mismatches...))
+		if len(mismatches) != 0 {
+			logger.Warningf("%d paths differ from %s", len(mismatches), version)
+			logger.Debugf("the paths differ: %v", mismatches)
+			if mode == config.VerifyRollbackRevert {
+				return fmt.Errorf("the restored paths differ from %s", version)
 			}
 		}
+	}
+	return nil
+}
 
-		c.UpdateProgress(40)
-		// hardlink need to filter file or dir to prepare dir
-		for _, dir := range rollbackDirList {
-			dirRoot := filepath.Dir(dir)
-			filterDirs, filterFiles := util.HandlerFilterList(c.rootMP, dirRoot, repoConf.FilterList)
-			rootPartition, err := dirinfo.GetDirPartition(dirRoot)
-			if err != nil {
-				logger.Warningf("failed get %s partition", dirRoot)
-				continue
-			}
-			for _, v := range filterDirs {
-				dirPartition, err := dirinfo.GetDirPartition(v)
-				if err != nil {
-					logger.Warningf("failed get %s partition", v)
-					continue
-				}
-				if dirPartition != rootPartition {
-					continue
-				}
-				dest := filepath.Join(dir, strings.TrimPrefix(v, dirRoot))
-				util.CopyDir(v, dest, nil, nil, true)
-				logger.Debugf("ignore dir path:%s", dest)
-			}
-			for _, v := range filterFiles {
-				filePartition, err := dirinfo.GetDirPartition(v)
+// reposRollback rolls back the repos one by one, if any fails the repos rolled back are recovered
+// in the reverse order. The replaced dirs are removed at last
+func (c *Upgrader) reposRollback(version string) error {
+	var plans []*repoRollbackPlan
+	var err error
+	for _, v := range c.versionRepos(version) {
+		plan := c.planRepoRollback(v, version)
+		plans = append(plans, plan)
+		err = c.repoRollback(plan)
+		if err != nil {
+			logger.Warningf("failed rollback %s, err: %v", v.Repo, err)
+			break
+		}
+	}
+	c.UpdateProgress(70)
+	// if failed update, restoring the system
+	if err != nil || c.recordsInfo.IsRestore() {
+		c.recordsInfo.SetRestore()
+		logger.Warning("failed rollback, recover rollback action")
+		for i := len(plans) - 1; i >= 0; i-- {
+			plan := plans[i]
+			var rollbackDirList []string
+			for _, dir := range plan.realDirList {
+				err := c.handleRepoRollbak(dir, plan.snapDir, version, plan.filterMountedList, &rollbackDirList, c.swapDirRecover)
 				if err != nil {
-					logger.Warningf("failed get %s partition", v)
-					continue
-				}
-				if filePartition != rootPartition {
-					continue
+					logger.Error("failed recover rollback, err:", err)
 				}
-				dest := filepath.Join(dir, strings.TrimPrefix(v, dirRoot))
-				util.CopyFile(v, dest, true)
-				logger.Debugf("ignore file path:%s", dest)
 			}
 		}
-		var bootDir string
-		// repo files replace system files
-		c.UpdateProgress(60)
-		for _, dir := range realDirSubscribeList {
-			logger.Debug("start replacing the dir:", dir)
-			if strings.HasSuffix(filepath.Join(c.rootMP, "/boot"), dir) {
-				logger.Debugf("the %s needs to be replaced last", dir)
-				bootDir = dir
-				continue
-			}
-			err = c.handleRepoRollbak(dir, snapDir, version, FilterPartMountedList, &rollbackDirList, util.HandlerDirRollback)
-			if err != nil {
-				return err
-			}
+		if err == nil {
+			err = errors.New("failed rollback dir")
 		}
-		// last replace /boot dir, protect system boot
-		if len(bootDir) != 0 {
-			err = c.handleRepoRollbak(bootDir, snapDir, version, FilterPartMountedList, &rollbackDirList, util.HandlerDirRollback)
-			if err != nil {
-				return err
-			}
+	}
+	// remove all tmp dir and compatible rollback
+	for _, plan := range plans {
+		err := c.journal.Finish(plan.realDirList, plan.ops)
+		if err != nil {
+			logger.Warning("failed clean up the rollback, err:", err)
 		}
+	}
+	return err
+}
 
-		// replace file is fast
-		if len(realFileSubcribeList) != 0 {
-			for _, v := range realFileSubcribeList {
-				realFile := util.TrimRootdir(c.rootMP, v)
-				snapFile := filepath.Join(snapDir, realFile)
-				logger.Debugf("start rolling back file, realfile:%s, snapFile:%s",
-					realFile, snapFile)
-				err := util.CopyFile(filepath.Join(c.rootMP, snapFile), filepath.Join(c.rootMP, realFile), false)
-				if err != nil {
-					return err
-				}
-			}
+// copyFilterFiles hardlinks the files need to filter to the prepared dir
+func (c *Upgrader) copyFilterFiles(dir string, filterList []string) {
+	dirRoot := filepath.Dir(dir)
+	filterDirs, filterFiles := util.HandlerFilterList(c.rootMP, dirRoot, filterList)
+	rootPartition, err := dirinfo.GetDirPartition(dirRoot)
+	if err != nil {
+		logger.Warningf("failed get %s partition", dirRoot)
+		return
+	}
+	for _, v := range filterDirs {
+		dirPartition, err := dirinfo.GetDirPartition(v)
+		if err != nil {
+			logger.Warningf("failed get %s partition", v)
+			continue
 		}
+		if dirPartition != rootPartition {
+			continue
+		}
+		dest := filepath.Join(dir, strings.TrimPrefix(v, dirRoot))
+		util.CopyDir(v, dest, nil, nil, true)
+		logger.Debugf("ignore dir path:%s", dest)
+	}
+	for _, v := range filterFiles {
+		filePartition, err := dirinfo.GetDirPartition(v)
+		if err != nil {
+			logger.Warningf("failed get %s partition", v)
+			continue
+		}
+		if filePartition != rootPartition {
+			continue
+		}
+		dest := filepath.Join(dir, strings.TrimPrefix(v, dirRoot))
+		util.CopyFile(v, dest, true)
+		logger.Debugf("ignore file path:%s", dest)
 	}
-	return nil
 }
 
 func (c *Upgrader) copyRepoData(rootDir, dataDir string,
@@ -1201,12 +1292,28 @@ func (c *Upgrader) getMostSpaceDir(rootDir string, subscribeList []string) strin
 
 func (c *Upgrader) isDirSpaceEnough(mountpoint, rootDir string, subscribeList []string,
 	extraSize int64, isFilterPartiton bool) (bool, error) {
+	needSize, free, err := c.dirSpaceSize(mountpoint, rootDir, subscribeList, extraSize, isFilterPartiton)
+	if err != nil {
+		return false, err
+	}
+	if needSize > int64(free) {
+		return false, errors.New("the current partition is out of space")
+	}
+	return true, nil
+}
+
+// @title    dirSpaceSize
+// @description   the size needed by the subscribe list and the free size of the mount point partition
+// @return    needSize			int64   			"bytes needed"
+// @return    free				uint64   			"bytes free"
+func (c *Upgrader) dirSpaceSize(mountpoint, rootDir string, subscribeList []string,
+	extraSize int64, isFilterPartiton bool) (int64, uint64, error) {
 	var needSize int64
 
 	mountPart, err := dirinfo.GetDirPartition(mountpoint)
 	logger.Debugf("the dir is:%s, the partiton is:%s", mountpoint, mountPart)
 	if err != nil {
-		return false, err
+		return 0, 0, err
 	}
 	for _, dir := range subscribeList {
 		srcDir := filepath.Join(rootDir, dir)
@@ -1233,74 +1340,95 @@ func (c *Upgrader) isDirSpaceEnough(mountpoint, rootDir string, subscribeList []
 	}
 	logger.Debugf("the %s partition free size:%.2f GB, extra size:%.2f GB, the need size is:%.2f GB", mountPart,
 		float64(free)/float64(GB), float64(extraSize)/float64(GB), float64(needSize)/float64(GB)+float64(extraSize)/float64(GB))
-	if needSize > int64(free) {
-		return false, errors.New("the current partition is out of space")
-	}
-	return true, nil
+	return needSize, free, nil
 }
 
-func (c *Upgrader) updateLoaclMount(snapDir string) (mountpoint.MountPointList, error) {
-	fstabDir := filepath.Clean(filepath.Join(snapDir, "/etc/fstab"))
-	if !util.IsExists(fstabDir) || util.IsEmptyFile(fstabDir) {
-		fstabDir = filepath.Clean(filepath.Join(c.rootMP, "/etc/fstab"))
-	}
-	_, err := ioutil.ReadFile(fstabDir)
-	var mountedPointList mountpoint.MountPointList
-	if err != nil {
-		return mountedPointList, err
-	}
-	c.fsInfo, err = fstabinfo.Load(fstabDir, c.rootMP)
-	if err != nil {
-		logger.Debugf("the %s file does not exist in the snapshot, read the local fstabl", fstabDir)
-		return mountedPointList, err
-	}
+type mountFixup struct {
+	stale  *mountpoint.MountPoint
+	target *mountpoint.MountPoint
+}
+
+// @title    planLoaclMount
+// @description   the mounts that need to be fixed up according to the fstab
+// @param     fsInfo         	FsInfoList         	"the fstab info, ex: the snapshot /etc/fstab"
+// @return    list				[]*mountFixup   	"stale is the wrong mount need umount, target need mount"
+func (c *Upgrader) planLoaclMount(fsInfo fstabinfo.FsInfoList) ([]*mountFixup, error) {
+	var list []*mountFixup
 	rootPartition, err := dirinfo.GetDirPartition(c.rootMP)
 	if err != nil {
-		return mountedPointList, err
+		return list, err
 	}
-	for _, info := range c.fsInfo {
+	for _, info := range fsInfo {
 		if info.SrcPoint == rootPartition || info.DestPoint == "/" || info.Remote {
 			logger.Debugf("ignore mount point %s", info.DestPoint)
 			continue
 		}
 		logger.Debugf("bind:%v,SrcPoint:%v,DestPoint:%v", info.Bind,
 			info.SrcPoint, filepath.Clean(filepath.Join(c.rootMP, info.DestPoint)))
+		var fixup mountFixup
 		m := c.mountInfos.Match(filepath.Clean(filepath.Join(c.rootMP, info.DestPoint)))
 		if m != nil && !info.Bind {
 			if m.Partition != info.SrcPoint || strings.Contains(m.Options, "ro") {
-				logger.Infof("the %s is mounted %s, not mounted correctly and needs to be unmouted",
-					m.Partition, m.MountPoint)
-				newInfo := &mountpoint.MountPoint{
+				fixup.stale = &mountpoint.MountPoint{
 					Src:     m.Partition,
 					Dest:    m.MountPoint,
 					FSType:  m.FSType,
 					Options: m.Options,
 				}
-				err := newInfo.Umount()
-				if err != nil {
-					continue
-				}
-				err = os.RemoveAll(newInfo.Dest)
-				if err != nil {
-					return mountedPointList, err
-				}
 			} else {
 				continue
 			}
 		}
-		mp := filepath.Join(c.rootMP, info.DestPoint)
-		logger.Infof("the %s is not mounted and needs to be mouted", mp)
-		oldInfo := &mountpoint.MountPoint{
+		fixup.target = &mountpoint.MountPoint{
 			Src:     info.SrcPoint,
-			Dest:    mp,
+			Dest:    filepath.Join(c.rootMP, info.DestPoint),
 			FSType:  info.FSType,
 			Options: info.Options,
 			Bind:    info.Bind,
 		}
+		list = append(list, &fixup)
+	}
+	return list, nil
+}
+
+func (c *Upgrader) updateLoaclMount(snapDir string) (mountpoint.MountPointList, error) {
+	fstabDir := filepath.Clean(filepath.Join(snapDir, "/etc/fstab"))
+	if !util.IsExists(fstabDir) || util.IsEmptyFile(fstabDir) {
+		fstabDir = filepath.Clean(filepath.Join(c.rootMP, "/etc/fstab"))
+	}
+	_, err := ioutil.ReadFile(fstabDir)
+	var mountedPointList mountpoint.MountPointList
+	if err != nil {
+		return mountedPointList, err
+	}
+	c.fsInfo, err = fstabinfo.Load(fstabDir, c.rootMP)
+	if err != nil {
+		logger.Debugf("the %s file does not exist in the snapshot, read the local fstabl", fstabDir)
+		return mountedPointList, err
+	}
+	fixups, err := c.planLoaclMount(c.fsInfo)
+	if err != nil {
+		return mountedPointList, err
+	}
+	for _, fixup := range fixups {
+		if fixup.stale != nil {
+			logger.Infof("the %s is mounted %s, not mounted correctly and needs to be unmouted",
+				fixup.stale.Src, fixup.stale.Dest)
+			err := fixup.stale.Umount()
+			if err != nil {
+				continue
+			}
+			err = os.RemoveAll(fixup.stale.Dest)
+			if err != nil {
+				return mountedPointList, err
+			}
+		}
+		oldInfo := fixup.target
+		logger.Infof("the %s is not mounted and needs to be mouted", oldInfo.Dest)
 		err := oldInfo.Mount()
 		mountedPointList = append(mountedPointList, oldInfo)
 		if err != nil {
-			logger.Error("failed to mount dir", mp)
+			logger.Error("failed to mount dir", oldInfo.Dest)
 			err = oldInfo.Umount()
 			if err != nil {
 				logger.Error("failed to umount dir:", err)
@@ -1312,13 +1440,8 @@ func (c *Upgrader) updateLoaclMount(snapDir string) (mountpoint.MountPointList,
 }
 
 func (c *Upgrader) RepoAutoCleanup() (bool, error) {
-	handler, err := repo.NewRepo(repo.REPO_TY_OSTREE,
-		filepath.Join(c.rootMP, c.conf.RepoList[0].Repo))
-	if err != nil {
-		return false, err
-	}
 	maxVersion := int(c.conf.MaxVersionRetention)
-	list, err := handler.List()
+	list, _, err := c.ListVersion()
 	if err != nil {
 		return false, err
 	}
@@ -1328,6 +1451,8 @@ func (c *Upgrader) RepoAutoCleanup() (bool, error) {
 	}
 	logger.Infof("current version is more than %d, need for cleanup repo", maxVersion)
 
+	// keep a version known to boot successfully, if none of the retained versions is
+	goodVersion := c.GoodVersion("")
 	for i, v := range list {
 		if i == len(list)-1 {
 			continue
@@ -1335,6 +1460,10 @@ func (c *Upgrader) RepoAutoCleanup() (bool, error) {
 		if i < maxVersion-1 {
 			continue
 		}
+		if v == goodVersion {
+			logger.Infof("keep the newest good version %s", v)
+			continue
+		}
 		_, err = c.Delete(v, nil)
 		if err != nil {
 			logger.Warning(err)
@@ -1347,38 +1476,42 @@ func (c *Upgrader) RepoAutoCleanup() (bool, error) {
 func (c *Upgrader) Delete(version string,
 	evHandler func(op, state int32, target, desc string)) (excode int, err error) {
 	exitCode := _STATE_TY_SUCCESS
-	var bootDir, snapshotDir, fisrt string
-	var handler repo.Repository
+	var bootDir string
 	c.SendingSignal(evHandler, _OP_TY_DELETE_START, _STATE_TY_RUNING, version, "")
 	if len(c.conf.RepoList) == 0 || len(version) == 0 {
 		err = errors.New("wrong version number")
 		exitCode = _STATE_TY_FAILED_NO_REPO
 		goto failure
 	}
-	handler, err = repo.NewRepo(repo.REPO_TY_OSTREE,
-		filepath.Join(c.rootMP, c.conf.RepoList[0].Repo))
-	if err != nil {
-		exitCode = _STATE_TY_FAILED_NO_REPO
+	if c.conf.ActiveVersion == version {
+		err = errors.New("the current activated version does not allow deletion")
+		exitCode = _STATE_TY_FAILED_VERSION_DELETE
 		goto failure
 	}
-	fisrt, err = handler.First()
+	// all the repos holding the version must allow the deletion before any is deleted
+	exitCode, err = c.checkDeleteVersion(version)
 	if err != nil {
-		exitCode = _STATE_TY_FAILED_NO_REPO
 		goto failure
 	}
-	if fisrt == version || c.conf.ActiveVersion == version {
-		err = errors.New("the current activated version does not allow deletion")
-		exitCode = _STATE_TY_FAILED_VERSION_DELETE
-		goto failure
+	// the checkout of the version is removed with it
+	if util.IsItemInList(version, c.MountedVersions()) {
+		_, err = c.UmountVersion(version, "")
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_HANDLING_MOUNTS
+			goto failure
+		}
 	}
-	err = handler.Delete(version)
+	err = c.deleteVersion(version)
 	if err != nil {
 		exitCode = _STATE_TY_FAILED_NO_VERSION
 		goto failure
 	}
-	snapshotDir = filepath.Join(c.rootMP, c.conf.RepoList[0].SnapshotDir, version)
-	logger.Debug("delete tmp snapshot directory:", snapshotDir)
-	_ = os.RemoveAll(snapshotDir)
+	if c.isBootCounterArmed(version) {
+		err = c.DisarmBootCounter()
+		if err != nil {
+			logger.Warning("failed disarm the boot counter, err:", err)
+		}
+	}
 	bootDir = filepath.Join(c.rootMP, "boot/snapshot", version)
 	logger.Debug("delete kernel snapshot directory:", bootDir)
 	_ = os.RemoveAll(bootDir)
@@ -1405,12 +1538,7 @@ func (c *Upgrader) IsAutoClean() bool {
 
 func (c *Upgrader) GenerateBranchName() (string, error) {
 	if len(c.conf.RepoList) != 0 {
-		handler, err := repo.NewRepo(repo.REPO_TY_OSTREE,
-			c.conf.RepoList[0].Repo)
-		if err != nil {
-			return "", err
-		}
-		name, err := handler.Last()
+		name, err := c.lastVersion()
 		if err != nil {
 			return "", err
 		}
@@ -1426,18 +1554,32 @@ func (c *Upgrader) ListVersion() ([]string, int, error) {
 		return nil, int(exitCode), nil
 	}
 
-	handler, err := repo.NewRepo(repo.REPO_TY_OSTREE,
-		filepath.Join(c.rootMP, c.conf.RepoList[0].Repo))
-	if err != nil {
-		exitCode = _STATE_TY_FAILED_NO_REPO
-		return nil, int(exitCode), err
-	}
-	list, err := handler.List()
-	if err != nil {
-		exitCode = _STATE_TY_FAILED_NO_REPO
-		return nil, int(exitCode), err
+	// the versions held by all the repos tracking them, in the order of the first repo
+	var list []string
+	for i, v := range c.conf.RepoList {
+		handler, err := c.repoHandler(v)
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_NO_REPO
+			return nil, int(exitCode), err
+		}
+		versions, err := handler.List()
+		if err != nil {
+			exitCode = _STATE_TY_FAILED_NO_REPO
+			return nil, int(exitCode), err
+		}
+		if i == 0 {
+			list = versions
+			continue
+		}
+		var held []string
+		for _, version := range list {
+			if util.IsItemInList(version, versions) || !isVersionTracked(version, versions) {
+				held = append(held, version)
+			}
+		}
+		list = held
 	}
-	return list, int(exitCode), err
+	return list, int(exitCode), nil
 }
 
 func (c *Upgrader) DistributionName() string {
@@ -1446,8 +1588,7 @@ func (c *Upgrader) DistributionName() string {
 
 func (c *Upgrader) Subject(version string) (string, error) {
 	var sub string
-	handler, err := repo.NewRepo(repo.REPO_TY_OSTREE,
-		filepath.Join(c.rootMP, c.conf.RepoList[0].Repo))
+	handler, err := c.repoHandler(c.conf.RepoList[0])
 	if err != nil {
 		return sub, err
 	}
@@ -1465,6 +1606,10 @@ func (c *Upgrader) RepoMountpointAndUUID() (string, string, error) {
 	}
 	repoMountPoint, uuid := c.fsInfo.MaxFreePartitionPoint()
 	for _, v := range c.conf.RepoList {
+		// the other repos keep their partitions
+		if v.RepoMountPoint != c.conf.RepoList[0].RepoMountPoint {
+			continue
+		}
 		// need keep 5GB free space
 		isEnough, err := c.isDirSpaceEnough(repoMountPoint, c.rootMP, v.SubscribeList, LessKeepSize, false)
 		if err != nil {
@@ -1486,16 +1631,11 @@ func (c *Upgrader) ReadyDataPath() string {
 	return c.conf.ReadyDataPath()
 }
 
-func (c *Upgrader) ResetGrub() {
-	m := grub.Init()
-	err := m.Reset()
+// CancelRollback cancels the rollback prepared in the system before rebooting
+func (c *Upgrader) CancelRollback() {
+	err := clearNextBoot(c.rootMP)
 	if err != nil {
-		logger.Warning("failed reset grub, err:", err)
-	} else {
-		m.Join()
-		util.ExecCommand("/usr/bin/sync", []string{})
-		time.Sleep(2 * time.Second)
-		c.UpdateGrub()
+		logger.Warning("failed cancel the rollback entry, err:", err)
 	}
 	c.recordsInfo.Remove()
 }
@@ -1529,6 +1669,11 @@ func (c *Upgrader) AfterRollbackOper(backVersion string, isSuccessful bool) erro
 		logger.Warning(err)
 	}
 	c.UpdateProgress(90)
+	// the counter has done its job, or the rollback is chosen by the user
+	err = c.DisarmBootCounter()
+	if err != nil {
+		logger.Warning("failed disarm the boot counter, err:", err)
+	}
 	// restore mount points under initramfs and save action version
 	c.SaveActiveVersion(backVersion)
 	if c.recordsInfo.IsAfterOper() {
@@ -1537,18 +1682,6 @@ func (c *Upgrader) AfterRollbackOper(backVersion string, isSuccessful bool) erro
 			return err
 		}
 		logger.Debug("start update grub config")
-		//need restore default grub config
-		gb, err := grub.LoadGrubParams()
-		if err != nil {
-			logger.Warning("failed get grub config")
-		} else {
-			t, err := gb.TimeOut()
-			logger.Debugf("current grub time out %v, old grub time out %v", t, c.recordsInfo.TimeOut)
-			if t == 0 && err == nil {
-				gb.SetTimeOut(uint(c.recordsInfo.TimeOut))
-				gb.SetGrubDefault((c.recordsInfo.GrubDefault))
-			}
-		}
 		c.UpdateGrub()
 		err = m.Exit()
 		if err != nil {
@@ -1614,7 +1747,7 @@ func (c *Upgrader) SendSystemNotice() error {
 	}
 	grubServiceObj := sysBus.Object(atomicUpgradeDest,
 		atomicUpgradePath)
-	res, afterRun, err := records.ReadResult()
+	res, afterRun, auto, err := records.ReadResult()
 	logger.Debugf("current self run %s, res %v", afterRun, res)
 	var ret dbus.Variant
 	dbusErr := grubServiceObj.Call("org.freedesktop.DBus.Properties.Get", 0, atomicUpgradeDest, "ActiveVersion").Store(&ret)
@@ -1635,7 +1768,11 @@ func (c *Upgrader) SendSystemNotice() error {
 		}
 	}()
 	if res == 1 {
-		text, err := util.GetUpgradeText(msgSuccessRollBack, []string{})
+		successMsg := msgSuccessRollBack
+		if auto {
+			successMsg = msgAutoRollBack
+		}
+		text, err := util.GetUpgradeText(successMsg, []string{})
 		if err != nil {
 			logger.Warningf("run gettext error: %v", err)
 		}
@@ -1653,23 +1790,51 @@ func (c *Upgrader) SendSystemNotice() error {
 		backMsg = fmt.Sprintf(text, msg)
 		mode = "101"
 	}
+	if mismatches := records.ReadMismatches(); len(backMsg) != 0 && len(mismatches) != 0 {
+		text, err := util.GetUpgradeText(msgRollBackMismatch, []string{})
+		if err != nil {
+			logger.Warningf("run gettext error: %v", err)
+		}
+		backMsg += " " + fmt.Sprintf(text, len(mismatches), records.SelfRecordMismatchPath)
+	}
+	if conflicts := records.ReadConflicts(); len(backMsg) != 0 && len(conflicts) != 0 {
+		text, err := util.GetUpgradeText(msgRollBackEtcConflict, []string{})
+		if err != nil {
+			logger.Warningf("run gettext error: %v", err)
+		}
+		backMsg += " " + fmt.Sprintf(text, len(conflicts), records.SelfRecordConflictPath)
+	}
 	if len(backMsg) != 0 {
 		time.Sleep(5 * time.Second) // wait for osd dbus
 		const selfRuning = "/usr/bin/deepin-upgrade-manager-tool --action=notify"
-		if len(afterRun) > 0 && afterRun != selfRuning {
-			context := strings.Fields(afterRun)
+		var isRun bool
+		var runErr error
+		for _, cmd := range strings.Split(afterRun, records.AfterRunSep) {
+			context := strings.Fields(cmd)
+			if len(context) == 0 || cmd == selfRuning {
+				continue
+			}
 			var arg []string
 			action := context[0]
 			if len(context) > 1 {
 				arg = context[1:]
 			}
 			arg = append(arg, "--mode="+mode)
-			logger.Debugf("exec command %s,action %s", afterRun, arg)
-			return util.ExecCommand(action, arg)
-		} else {
+			logger.Debugf("exec command %s,action %s", cmd, arg)
+			e := util.ExecCommand(action, arg)
+			if e != nil {
+				logger.Warningf("failed run %s, err: %v", cmd, e)
+				if runErr == nil {
+					runErr = e
+				}
+			}
+			isRun = true
+		}
+		if !isRun {
 			fmt.Println(backMsg)
 			return notify.SetNotifyText(backMsg)
 		}
+		return runErr
 	}
 	return nil
 }
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: 2018 - 2023 UnionTech Software Technology Co., Ltd.
+//
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package upgrader
+
+import (
+	config "deepin-upgrade-manager/pkg/config/upgrader"
+	"deepin-upgrade-manager/pkg/logger"
+	"deepin-upgrade-manager/pkg/module/records"
+	"deepin-upgrade-manager/pkg/module/repo/tree"
+	"deepin-upgrade-manager/pkg/module/util"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+)
+
+// the mismatches reported at most, the rest are counted
+const maxMismatches = 1000
+
+const (
+	changeAdded    = 'A'
+	changeModified = 'M'
+	changeDeleted  = 'D'
+)
+
+// pathChange is the path differing between the local tree and the version
+type pathChange struct {
+	path   string
+	kind   byte
+	reason string
+	isDir  bool
+}
+
+// verifyRollback compares the restored paths with the version by type, mode, owner, size and link target,
+// returns the paths differing as 'path: reason'. The filtered paths and the files of the rollback are skipped
+func (c *Upgrader) verifyRollback(repoConf *config.RepoConfig, version string, subscribeList []string) []string {
+	skipList := append([]string{SelfRecordStatePath, records.SelfRecordResultPath, records.SelfRecordMismatchPath,
+		records.SelfRecordConflictPath}, nonEmptyPaths(repoConf.FilterList)...)
+	// the local changes merged differ from the version
+	skipList = append(skipList, c.recordsInfo.EtcMerged...)
+	isSkipped := func(path string) bool {
+		name := filepath.Base(path)
+		return name == "."+version || name == ".old"+version || isPathCovered(path, skipList)
+	}
+	var list []string
+	for _, v := range c.diffLocalPaths(repoConf, version, subscribeList, isSkipped, compareFileInfo) {
+		switch v.kind {
+		case changeAdded:
+			list = append(list, v.path+": not in the version")
+		case changeDeleted:
+			list = append(list, v.path+": missing")
+		default:
+			list = append(list, v.path+": "+v.reason)
+		}
+	}
+	if len(list) > maxMismatches {
+		list = append(list[:maxMismatches], fmt.Sprintf("... and %d more", len(list)-maxMismatches))
+	}
+	return list
+}
+
+// diffLocalPaths walks the local paths and compares the files with the version by compare, the entries under
+// an added or a deleted dir are not listed. The paths not in the version are skipped
+func (c *Upgrader) diffLocalPaths(repoConf *config.RepoConfig, version string, paths []string,
+	isSkipped func(string) bool, compare func(string, os.FileInfo, *tree.FileInfo) string) []*pathChange {
+	handler := c.repoSet[repoConf.Repo]
+	var list []*pathChange
+	for _, v := range paths {
+		if isSkipped(v) {
+			continue
+		}
+		infos, err := handler.Ls(version, v, true)
+		if err != nil || len(infos) == 0 {
+			logger.Debugf("%s does not exist in %s, skip comparing", v, version)
+			continue
+		}
+		expected := infos.Map()
+		root := filepath.Join(c.rootMP, v)
+		err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			rel := util.TrimRootdir(c.rootMP, path)
+			if isSkipped(rel) {
+				delete(expected, rel)
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, ok := expected[rel]
+			if !ok {
+				list = append(list, &pathChange{path: rel, kind: changeAdded, isDir: fi.IsDir()})
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			delete(expected, rel)
+			reason := compare(path, fi, info)
+			if len(reason) == 0 {
+				return nil
+			}
+			list = append(list, &pathChange{path: rel, kind: changeModified, reason: reason, isDir: fi.IsDir()})
+			if fi.IsDir() && !info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			list = append(list, &pathChange{path: v, kind: changeModified, reason: err.Error()})
+			continue
+		}
+		list = append(list, missingPaths(expected, isSkipped)...)
+	}
+	return list
+}
+
+// missingPaths returns the paths of the version left, the entries under a missing dir are not listed
+func missingPaths(expected map[string]*tree.FileInfo, isSkipped func(string) bool) []*pathChange {
+	var paths []string
+	for path := range expected {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var list []*pathChange
+	var missingDirs []string
+	for _, path := range paths {
+		if isSkipped(path) || isPathCovered(path, missingDirs) {
+			continue
+		}
+		list = append(list, &pathChange{path: path, kind: changeDeleted, isDir: expected[path].IsDir()})
+		if expected[path].IsDir() {
+			missingDirs = append(missingDirs, path)
+		}
+	}
+	return list
+}
+
+// compareFileInfo returns why the local file differs from the file of the version, empty if the same
+func compareFileInfo(path string, fi os.FileInfo, info *tree.FileInfo) string {
+	var typ byte
+	switch {
+	case fi.Mode().IsRegular():
+		typ = tree.TYPE_FILE
+	case fi.IsDir():
+		typ = tree.TYPE_DIR
+	case fi.Mode()&os.ModeSymlink != 0:
+		typ = tree.TYPE_SYMLINK
+	}
+	if typ != info.Type {
+		return fmt.Sprintf("type %q, expected %q", typ, info.Type)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	if !info.IsSymlink() && st.Mode&07777 != uint32(info.Mode)&07777 {
+		return fmt.Sprintf("mode %04o, expected %04o", st.Mode&07777, uint32(info.Mode)&07777)
+	}
+	if st.Uid != info.Uid || st.Gid != info.Gid {
+		return fmt.Sprintf("owner %d:%d, expected %d:%d", st.Uid, st.Gid, info.Uid, info.Gid)
+	}
+	if info.IsRegular() && fi.Size() != info.Size {
+		return fmt.Sprintf("size %d, expected %d", fi.Size(), info.Size)
+	}
+	if info.IsSymlink() {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err.Error()
+		}
+		if target != info.Target {
+			return fmt.Sprintf("target %s, expected %s", target, info.Target)
+		}
+	}
+	return ""
+}